@@ -0,0 +1,215 @@
+// Command apikey issues, lists and revokes API keys and sets their team roles.
+// It is how the first key, the one with the keys:manage scope, gets created.
+//
+//	apikey issue -name reporting -scopes segments:read,stats:read -roles growth=viewer
+//	apikey list
+//	apikey revoke -id 3
+//	apikey grant -id 3 -team growth -role editor
+//	apikey ungrant -id 3 -team growth
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ABDURAZZAKK/avito_experiment/config"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/actor"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/repo"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/service"
+	"github.com/ABDURAZZAKK/avito_experiment/pkg/postgres"
+	log "github.com/sirupsen/logrus"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage:\n")
+	fmt.Fprintf(os.Stderr, "  apikey issue -name NAME -scopes SCOPE[,SCOPE...] [-roles TEAM=ROLE[,TEAM=ROLE...]]\n")
+	fmt.Fprintf(os.Stderr, "  apikey list\n")
+	fmt.Fprintf(os.Stderr, "  apikey revoke -id ID\n")
+	fmt.Fprintf(os.Stderr, "  apikey grant -id ID -team TEAM -role ROLE\n")
+	fmt.Fprintf(os.Stderr, "  apikey ungrant -id ID -team TEAM\n")
+	fmt.Fprintf(os.Stderr, "scopes: %s\n", joinScopes(entity.SCOPES))
+	fmt.Fprintf(os.Stderr, "roles: %s, %s, %s; team %s is every team\n",
+		entity.ROLE_VIEWER, entity.ROLE_EDITOR, entity.ROLE_ADMIN, entity.ALL_TEAMS)
+	os.Exit(2)
+}
+
+func main() {
+	configPath := flag.String("config", "config/config.yaml", "path to the config file")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+	}
+
+	cfg, err := config.NewConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Config error: %s", err)
+	}
+	pg, err := postgres.New(cfg.PG.URL, postgres.MaxPoolSize(1))
+	if err != nil {
+		log.Fatalf("postgres.New: %v", err)
+	}
+	defer pg.Close()
+	repositories := repo.NewRepositories(pg)
+	keys := service.NewApiKeyService(repositories.ApiKey, repositories.Audit)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	// whoever can reach the database may grant any role
+	ctx = actor.WithKey(ctx, entity.ApiKey{
+		Name:  actor.OPERATOR,
+		Roles: map[string]entity.Role{entity.ALL_TEAMS: entity.ROLE_ADMIN},
+	})
+
+	args := flag.Args()[1:]
+	switch flag.Arg(0) {
+	case "issue":
+		err = issue(ctx, keys, args)
+	case "list":
+		err = list(ctx, keys)
+	case "revoke":
+		err = revoke(ctx, keys, args)
+	case "grant":
+		err = grant(ctx, keys, args)
+	case "ungrant":
+		err = ungrant(ctx, keys, args)
+	default:
+		usage()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func issue(ctx context.Context, keys service.ApiKey, args []string) error {
+	fs := flag.NewFlagSet("issue", flag.ExitOnError)
+	name := fs.String("name", "", "name of the key, recorded as the actor of its changes")
+	scopes := fs.String("scopes", "", "comma separated scopes")
+	roles := fs.String("roles", "", "comma separated team=role pairs")
+	_ = fs.Parse(args)
+	if *name == "" || *scopes == "" {
+		usage()
+	}
+
+	var input service.ApiKeyIssueInput
+	input.Name = *name
+	for _, s := range strings.Split(*scopes, ",") {
+		input.Scopes = append(input.Scopes, entity.Scope(strings.TrimSpace(s)))
+	}
+	if *roles != "" {
+		input.Roles = make(map[string]entity.Role)
+		for _, pair := range strings.Split(*roles, ",") {
+			team, role, ok := strings.Cut(pair, "=")
+			if !ok {
+				usage()
+			}
+			input.Roles[strings.TrimSpace(team)] = entity.Role(strings.TrimSpace(role))
+		}
+	}
+	output, err := keys.Issue(ctx, input)
+	if err != nil {
+		return fmt.Errorf("issue: %w", err)
+	}
+	fmt.Printf("id:     %d\nname:   %s\nscopes: %s\nroles:  %s\nkey:    %s\n",
+		output.Key.Id, output.Key.Name, joinScopes(output.Key.Scopes), joinRoles(output.Key.Roles), output.Token)
+	fmt.Fprintln(os.Stderr, "The key is not stored and cannot be shown again.")
+	return nil
+}
+
+func list(ctx context.Context, keys service.ApiKey) error {
+	all, err := keys.List(ctx)
+	if err != nil {
+		return fmt.Errorf("list: %w", err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tPREFIX\tSCOPES\tROLES\tCREATED\tLAST USED\tREVOKED")
+	for _, k := range all {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			k.Id, k.Name, k.Prefix, joinScopes(k.Scopes), joinRoles(k.Roles),
+			k.CreatedAt.Format(time.RFC3339), formatTime(k.LastUsedAt), formatTime(k.RevokedAt))
+	}
+	return w.Flush()
+}
+
+func revoke(ctx context.Context, keys service.ApiKey, args []string) error {
+	fs := flag.NewFlagSet("revoke", flag.ExitOnError)
+	id := fs.Int64("id", 0, "id of the key")
+	_ = fs.Parse(args)
+	if *id <= 0 {
+		usage()
+	}
+	if err := keys.Revoke(ctx, *id); err != nil {
+		return fmt.Errorf("revoke: %w", err)
+	}
+	fmt.Printf("revoked %d\n", *id)
+	return nil
+}
+
+func grant(ctx context.Context, keys service.ApiKey, args []string) error {
+	fs := flag.NewFlagSet("grant", flag.ExitOnError)
+	id := fs.Int64("id", 0, "id of the key")
+	team := fs.String("team", "", "team, * for every team")
+	role := fs.String("role", "", "viewer, editor or admin")
+	_ = fs.Parse(args)
+	if *id <= 0 || *team == "" || *role == "" {
+		usage()
+	}
+	if err := keys.SetRole(ctx, *id, *team, entity.Role(*role)); err != nil {
+		return fmt.Errorf("grant: %w", err)
+	}
+	fmt.Printf("%d is %s in %s\n", *id, *role, *team)
+	return nil
+}
+
+func ungrant(ctx context.Context, keys service.ApiKey, args []string) error {
+	fs := flag.NewFlagSet("ungrant", flag.ExitOnError)
+	id := fs.Int64("id", 0, "id of the key")
+	team := fs.String("team", "", "team")
+	_ = fs.Parse(args)
+	if *id <= 0 || *team == "" {
+		usage()
+	}
+	if err := keys.DeleteRole(ctx, *id, *team); err != nil {
+		return fmt.Errorf("ungrant: %w", err)
+	}
+	fmt.Printf("%d has no role in %s\n", *id, *team)
+	return nil
+}
+
+func joinRoles(roles map[string]entity.Role) string {
+	if len(roles) == 0 {
+		return "-"
+	}
+	teams := make([]string, 0, len(roles))
+	for team := range roles {
+		teams = append(teams, team)
+	}
+	sort.Strings(teams)
+	pairs := make([]string, 0, len(teams))
+	for _, team := range teams {
+		pairs = append(pairs, team+"="+string(roles[team]))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func joinScopes(scopes []entity.Scope) string {
+	s := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		s = append(s, string(scope))
+	}
+	return strings.Join(s, ",")
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
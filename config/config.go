@@ -4,15 +4,22 @@ import (
 	"fmt"
 	"github.com/ilyakaznacheev/cleanenv"
 	"path"
+	"time"
 )
 
 type (
 	Config struct {
-		App    `yaml:"app"`
-		HTTP   `yaml:"http"`
-		Log    `yaml:"log"`
-		PG     `yaml:"postgres"`
-		BROKER `yaml:"rabbitmq"`
+		App         `yaml:"app"`
+		HTTP        `yaml:"http"`
+		Log         `yaml:"log"`
+		PG          `yaml:"postgres"`
+		BROKER      `yaml:"rabbitmq"`
+		Sweeper     `yaml:"sweeper"`
+		Relay       `yaml:"relay"`
+		Consumer    `yaml:"consumer"`
+		Webhook     `yaml:"webhook"`
+		Idempotency `yaml:"idempotency"`
+		RateLimit   `yaml:"rate_limit"`
 	}
 
 	App struct {
@@ -33,7 +40,73 @@ type (
 		URL         string `env-required:"true"                      env:"PG_URL"`
 	}
 	BROKER struct {
-		URL string `env-required:"true" env:"RABBITMQ_URL"`
+		// Kind is amqp or memory. The memory broker runs the consumer inside the app.
+		Kind string `env-required:"true" yaml:"kind" env:"BROKER_KIND"`
+		URL  string `env:"RABBITMQ_URL"`
+		// ConfirmTimeout bounds how long a publish waits for the broker's confirm.
+		ConfirmTimeout time.Duration `env-required:"true" yaml:"confirm_timeout" env:"RABBITMQ_CONFIRM_TIMEOUT"`
+		// ReconnectDelay caps the pause between reconnect attempts.
+		ReconnectDelay time.Duration `env-required:"true" yaml:"reconnect_delay" env:"RABBITMQ_RECONNECT_DELAY"`
+	}
+
+	Sweeper struct {
+		Interval  time.Duration `env-required:"true" yaml:"interval"   env:"SWEEPER_INTERVAL"`
+		BatchSize int           `env-required:"true" yaml:"batch_size" env:"SWEEPER_BATCH_SIZE"`
+	}
+
+	Relay struct {
+		Interval  time.Duration `env-required:"true" yaml:"interval"    env:"RELAY_INTERVAL"`
+		BatchSize int           `env-required:"true" yaml:"batch_size"  env:"RELAY_BATCH_SIZE"`
+		// Lease hides claimed messages from other relays while they are published.
+		// It should outlast publishing a batch, or messages may be published twice.
+		Lease      time.Duration `env-required:"true" yaml:"lease"       env:"RELAY_LEASE"`
+		MaxBackoff time.Duration `env-required:"true" yaml:"max_backoff" env:"RELAY_MAX_BACKOFF"`
+	}
+
+	Consumer struct {
+		Workers  int `env-required:"true" yaml:"workers"  env:"CONSUMER_WORKERS"`
+		Prefetch int `env-required:"true" yaml:"prefetch" env:"CONSUMER_PREFETCH"`
+		// ShutdownTimeout is how long running tasks may take to finish after SIGTERM.
+		ShutdownTimeout time.Duration `env-required:"true" yaml:"shutdown_timeout" env:"CONSUMER_SHUTDOWN_TIMEOUT"`
+		// MaxAttempts counts the first delivery, so 1 disables retries.
+		MaxAttempts    int           `env-required:"true" yaml:"max_attempts"     env:"CONSUMER_MAX_ATTEMPTS"`
+		RetryBaseDelay time.Duration `env-required:"true" yaml:"retry_base_delay" env:"CONSUMER_RETRY_BASE_DELAY"`
+		RetryMaxDelay  time.Duration `env-required:"true" yaml:"retry_max_delay"  env:"CONSUMER_RETRY_MAX_DELAY"`
+	}
+
+	Webhook struct {
+		Interval    time.Duration `env-required:"true" yaml:"interval"     env:"WEBHOOK_INTERVAL"`
+		BatchSize   int           `env-required:"true" yaml:"batch_size"   env:"WEBHOOK_BATCH_SIZE"`
+		Timeout     time.Duration `env-required:"true" yaml:"timeout"      env:"WEBHOOK_TIMEOUT"`
+		MaxAttempts int           `env-required:"true" yaml:"max_attempts" env:"WEBHOOK_MAX_ATTEMPTS"`
+		MaxBackoff  time.Duration `env-required:"true" yaml:"max_backoff"  env:"WEBHOOK_MAX_BACKOFF"`
+	}
+
+	Idempotency struct {
+		// Retention is how long a response is replayed for the same Idempotency-Key.
+		Retention time.Duration `env-required:"true" yaml:"retention"      env:"IDEMPOTENCY_RETENTION"`
+		// Lease is how long a request holds its key; a key still without a
+		// response after it, e.g. of a crashed replica, is taken by the next request.
+		Lease         time.Duration `env-required:"true" yaml:"lease"          env:"IDEMPOTENCY_LEASE"`
+		PurgeInterval time.Duration `env-required:"true" yaml:"purge_interval" env:"IDEMPOTENCY_PURGE_INTERVAL"`
+		BatchSize     int           `env-required:"true" yaml:"batch_size"     env:"IDEMPOTENCY_BATCH_SIZE"`
+	}
+
+	// RateLimit holds the token buckets of each API key per route class. The
+	// buckets live in the memory of each replica, so with N replicas a key gets
+	// up to N times the rate; ExportsPerDay is counted in the database and shared.
+	RateLimit struct {
+		Reads   RateLimitBucket `yaml:"reads"   env-prefix:"RATE_LIMIT_READS_"`
+		Writes  RateLimitBucket `yaml:"writes"  env-prefix:"RATE_LIMIT_WRITES_"`
+		Exports RateLimitBucket `yaml:"exports" env-prefix:"RATE_LIMIT_EXPORTS_"`
+		// ExportsPerDay caps the exports a key may start per UTC day, 0 disables the cap.
+		ExportsPerDay int `yaml:"exports_per_day" env:"RATE_LIMIT_EXPORTS_PER_DAY"`
+	}
+
+	RateLimitBucket struct {
+		// Rate is the number of requests per second a bucket refills with, 0 disables the limit.
+		Rate  float64 `yaml:"rate"  env:"RATE"`
+		Burst int     `yaml:"burst" env:"BURST"`
 	}
 )
 
@@ -0,0 +1,47 @@
+// Package actor carries who is making a change through the request context, so
+// the change can be attributed in the stats and events it produces.
+package actor
+
+import (
+	"context"
+
+	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
+)
+
+const (
+	// ANONYMOUS is the actor of requests that did not identify themselves.
+	ANONYMOUS = "anonymous"
+	// SWEEPER removes expired memberships.
+	SWEEPER = "sweeper"
+	// OPERATOR manages API keys with the apikey command.
+	OPERATOR = "operator"
+)
+
+type key struct{}
+
+// With returns a context for changes made by name: an API key or a service.
+func With(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, key{}, name)
+}
+
+// From returns the actor of ctx, ANONYMOUS when there is none.
+func From(ctx context.Context) string {
+	if name, ok := ctx.Value(key{}).(string); ok && name != "" {
+		return name
+	}
+	return ANONYMOUS
+}
+
+type apiKey struct{}
+
+// WithKey returns a context for changes made with the API key. The key is
+// also the actor.
+func WithKey(ctx context.Context, key entity.ApiKey) context.Context {
+	return context.WithValue(With(ctx, key.Name), apiKey{}, key)
+}
+
+// Key returns the API key of ctx.
+func Key(ctx context.Context) (entity.ApiKey, bool) {
+	key, ok := ctx.Value(apiKey{}).(entity.ApiKey)
+	return key, ok
+}
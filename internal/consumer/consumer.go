@@ -0,0 +1,200 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/repo"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/task"
+	"github.com/ABDURAZZAKK/avito_experiment/pkg/broker"
+	log "github.com/sirupsen/logrus"
+)
+
+// Retry bounds how often a failed task is redelivered and how long it waits
+// between attempts.
+type Retry struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// Consumer runs the tasks published by the app.
+type Consumer struct {
+	exportJobRepo       repo.ExportJob
+	usersSegmentsRepo   repo.UsersSegments
+	rejectedMessageRepo repo.RejectedMessage
+	workers             int
+	shutdownTimeout     time.Duration
+	retry               Retry
+}
+
+func New(repos *repo.Repositories, workers int, shutdownTimeout time.Duration, retry Retry) *Consumer {
+	return &Consumer{
+		exportJobRepo:       repos.ExportJob,
+		usersSegmentsRepo:   repos.UsersSegments,
+		rejectedMessageRepo: repos.RejectedMessage,
+		workers:             workers,
+		shutdownTimeout:     shutdownTimeout,
+		retry:               retry,
+	}
+}
+
+// Run handles deliveries with a fixed number of workers until ctx is done or
+// the broker stops delivering. Once ctx is done no new deliveries are taken and
+// running tasks get shutdownTimeout to finish; whatever is still running after
+// that is cancelled and handed back to the broker.
+func (c *Consumer) Run(ctx context.Context, b broker.Consumer) error {
+	deliveries, err := b.Consume(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Tasks outlive ctx so a shutdown does not abort them halfway.
+	taskCtx, cancelTasks := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelTasks()
+
+	var wg sync.WaitGroup
+	for i := 0; i < c.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range deliveries {
+				c.handle(taskCtx, d)
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	log.Infof("consumer - waiting up to %s for running tasks", c.shutdownTimeout)
+	select {
+	case <-done:
+	case <-time.After(c.shutdownTimeout):
+		log.Warn("consumer - shutdown timeout, cancelling running tasks")
+		cancelTasks()
+		<-done
+	}
+	return nil
+}
+
+// handle runs a single delivery and settles it: acked on success, sent to the
+// retry queue while attempts remain and dead-lettered after that.
+func (c *Consumer) handle(ctx context.Context, d broker.Delivery) {
+	t, err := task.Decode(d.Body, d.Headers)
+	if err != nil {
+		c.reject(ctx, d, err)
+		if err := d.Ack(); err != nil {
+			log.Errorf("consumer - handle - Delivery.Ack: %v", err)
+		}
+		return
+	}
+
+	err = c.run(ctx, t)
+	if err == nil {
+		if err := d.Ack(); err != nil {
+			log.Errorf("consumer - handle - Delivery.Ack: %v", err)
+		}
+		return
+	}
+
+	// Interrupted by shutdown: the attempt doesn't count, give the message back.
+	if ctx.Err() != nil {
+		log.Warnf("consumer - %s interrupted, returning it to the queue: %v", t.Type(), err)
+		c.onRetry(context.WithoutCancel(ctx), t, err)
+		if err := d.Nack(); err != nil {
+			log.Errorf("consumer - handle - Delivery.Nack: %v", err)
+		}
+		return
+	}
+
+	attempt := d.Attempt()
+	if attempt < c.retry.MaxAttempts {
+		delay := c.backoff(attempt)
+		log.Warnf("consumer - %s attempt %d/%d failed, retrying in %s: %v", t.Type(), attempt, c.retry.MaxAttempts, delay, err)
+		c.onRetry(ctx, t, err)
+		if err := d.Retry(ctx, delay); err != nil {
+			log.Errorf("consumer - handle - Delivery.Retry: %v", err)
+		}
+		return
+	}
+
+	log.Errorf("consumer - %s attempt %d/%d failed, giving up: %v", t.Type(), attempt, c.retry.MaxAttempts, err)
+	c.onDead(ctx, t, err)
+	if err := d.DeadLetter(ctx, err.Error()); err != nil {
+		log.Errorf("consumer - handle - Delivery.DeadLetter: %v", err)
+	}
+}
+
+// run dispatches a task to its handler. A panicking handler fails the attempt
+// instead of the whole process.
+func (c *Consumer) run(ctx context.Context, t task.Task) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	switch t := t.(type) {
+	case *task.CreateCSV:
+		return c.createCSVFromUsersSegments(ctx, t)
+	case *task.DeleteSegmentOnTime:
+		return c.deleteSegmentOnTime(ctx, t)
+	}
+	return fmt.Errorf("no handler for task %s", t.Type())
+}
+
+// onRetry and onDead record the outcome of a failed attempt on the task's own state.
+func (c *Consumer) onRetry(ctx context.Context, t task.Task, reason error) {
+	switch t := t.(type) {
+	case *task.CreateCSV:
+		if err := c.exportJobRepo.MarkRetrying(ctx, t.JobId, reason.Error()); err != nil {
+			log.Errorf("consumer - onRetry - exportJobRepo.MarkRetrying: %v", err)
+		}
+	}
+}
+
+func (c *Consumer) onDead(ctx context.Context, t task.Task, reason error) {
+	switch t := t.(type) {
+	case *task.CreateCSV:
+		if err := c.exportJobRepo.MarkFailed(ctx, t.JobId, reason.Error()); err != nil {
+			log.Errorf("consumer - onDead - exportJobRepo.MarkFailed: %v", err)
+		}
+	}
+}
+
+// backoff doubles the base delay with every attempt, up to MaxDelay.
+func (c *Consumer) backoff(attempt int) time.Duration {
+	delay := c.retry.BaseDelay
+	for i := 1; i < attempt && delay < c.retry.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > c.retry.MaxDelay {
+		delay = c.retry.MaxDelay
+	}
+	return delay
+}
+
+// reject stores a message that cannot be decoded so it can be inspected later.
+func (c *Consumer) reject(ctx context.Context, d broker.Delivery, reason error) {
+	log.Warnf("consumer - reject: %v", reason)
+	_, err := c.rejectedMessageRepo.Create(ctx, entity.RejectedMessage{
+		Headers: d.Headers,
+		Body:    d.Body,
+		Reason:  reason.Error(),
+	})
+	if err != nil {
+		log.Errorf("consumer - reject - rejectedMessageRepo.Create: %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/repo"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/task"
+	"github.com/ABDURAZZAKK/avito_experiment/pkg/broker"
+)
+
+// exportJobRepo records the job updates and fails every MarkRunning.
+type exportJobRepo struct {
+	repo.ExportJob
+
+	mu       sync.Mutex
+	retrying int
+	failed   chan string
+}
+
+func (r *exportJobRepo) MarkRunning(ctx context.Context, id int64) error {
+	return errors.New("database is down")
+}
+
+func (r *exportJobRepo) MarkRetrying(ctx context.Context, id int64, reason string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.retrying++
+	return nil
+}
+
+func (r *exportJobRepo) MarkFailed(ctx context.Context, id int64, reason string) error {
+	r.failed <- reason
+	return nil
+}
+
+type rejectedMessageRepo struct {
+	rejected chan entity.RejectedMessage
+}
+
+func (r *rejectedMessageRepo) Create(ctx context.Context, msg entity.RejectedMessage) (int64, error) {
+	r.rejected <- msg
+	return 1, nil
+}
+
+// usersSegmentsRepo records the expiries set.
+type usersSegmentsRepo struct {
+	repo.UsersSegments
+
+	expired chan expiry
+}
+
+type expiry struct {
+	users     []int
+	segments  []string
+	expiresAt time.Time
+}
+
+func (r *usersSegmentsRepo) SetExpiresAt(ctx context.Context, users []int, segments []string, expiresAt time.Time) (int, error) {
+	r.expired <- expiry{users: users, segments: segments, expiresAt: expiresAt}
+	return len(users) * len(segments), nil
+}
+
+func runConsumer(t *testing.T, repos *repo.Repositories, b *broker.Memory) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c := New(repos, 2, time.Second, Retry{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 4 * time.Millisecond})
+		if err := c.Run(ctx, b); err != nil {
+			t.Errorf("Run: %v", err)
+		}
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestConsumerRetriesThenDeadLetters(t *testing.T) {
+	b := broker.NewMemory(8)
+	defer b.Close()
+	jobs := &exportJobRepo{failed: make(chan string, 1)}
+	runConsumer(t, &repo.Repositories{ExportJob: jobs}, b)
+
+	body, headers, err := task.Encode(&task.CreateCSV{JobId: 1, Year: 2023, Month: 8})
+	if err != nil {
+		t.Fatalf("task.Encode: %v", err)
+	}
+	if err = b.Publish(context.Background(), body, headers); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	select {
+	case <-jobs.failed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("job was not marked failed")
+	}
+	jobs.mu.Lock()
+	retrying := jobs.retrying
+	jobs.mu.Unlock()
+	if retrying != 2 {
+		t.Fatalf("job marked retrying %d times, want 2", retrying)
+	}
+	// the message is dead-lettered right after the job is marked failed
+	dead := b.DeadLetters()
+	for deadline := time.Now().Add(2 * time.Second); len(dead) == 0 && time.Now().Before(deadline); dead = b.DeadLetters() {
+		time.Sleep(time.Millisecond)
+	}
+	if len(dead) != 1 || dead[0].Attempt() != 3 || dead[0].Headers[broker.HEADER_DEAD_REASON] == "" {
+		t.Fatalf("got dead letters %v", dead)
+	}
+}
+
+func TestConsumerRejectsInvalidMessages(t *testing.T) {
+	b := broker.NewMemory(8)
+	defer b.Close()
+	rejected := &rejectedMessageRepo{rejected: make(chan entity.RejectedMessage, 1)}
+	runConsumer(t, &repo.Repositories{RejectedMessage: rejected}, b)
+
+	if err := b.Publish(context.Background(), []byte("not a task"), nil); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	select {
+	case msg := <-rejected.rejected:
+		if string(msg.Body) != "not a task" || msg.Reason == "" {
+			t.Fatalf("got rejected message %+v", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("message was not rejected")
+	}
+	if dead := b.DeadLetters(); len(dead) != 0 {
+		t.Fatalf("rejected message was dead-lettered: %v", dead)
+	}
+}
+
+func TestConsumerTurnsLegacyRemovalsIntoExpiry(t *testing.T) {
+	b := broker.NewMemory(8)
+	defer b.Close()
+	usersSegments := &usersSegmentsRepo{expired: make(chan expiry, 1)}
+	runConsumer(t, &repo.Repositories{UsersSegments: usersSegments}, b)
+
+	body := []byte(`{"task":"DeleteSegmentFromUserOnTime","time":"2023-09-01 12:00:00","users":[1,2],"segments":["AVITO_VOICE_MESSAGES"]}`)
+	if err := b.Publish(context.Background(), body, nil); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	select {
+	case got := <-usersSegments.expired:
+		want := time.Date(2023, 9, 1, 9, 0, 0, 0, time.UTC)
+		if len(got.users) != 2 || len(got.segments) != 1 || got.segments[0] != "AVITO_VOICE_MESSAGES" || !got.expiresAt.Equal(want) {
+			t.Fatalf("got expiry %+v, want users [1 2] of AVITO_VOICE_MESSAGES at %s", got, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expiry was not set")
+	}
+}
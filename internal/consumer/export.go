@@ -0,0 +1,86 @@
+package consumer
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/task"
+	log "github.com/sirupsen/logrus"
+)
+
+// createCSVFromUsersSegments runs an export job and records its success in
+// export_jobs. Failures are returned so the consumer can retry the task.
+func (c *Consumer) createCSVFromUsersSegments(ctx context.Context, t *task.CreateCSV) error {
+	if err := c.exportJobRepo.MarkRunning(ctx, t.JobId); err != nil {
+		return fmt.Errorf("exportJobRepo.MarkRunning: %w", err)
+	}
+
+	filename := task.ExportPath(t.JobId)
+	rows, err := c.writeStatsCSV(ctx, t, filename)
+	if err != nil {
+		return fmt.Errorf("writeStatsCSV: %w", err)
+	}
+	if err := c.exportJobRepo.MarkSucceeded(ctx, t.JobId, filename, rows); err != nil {
+		return fmt.Errorf("exportJobRepo.MarkSucceeded: %w", err)
+	}
+	log.Printf("Succses Create File: %s", filename)
+	return nil
+}
+
+// writeStatsCSV writes the stats of the requested month next to filename and
+// renames it into place, so a half written file is never served.
+func (c *Consumer) writeStatsCSV(ctx context.Context, t *task.CreateCSV, filename string) (int, error) {
+	loc := t.Location()
+	from := time.Date(t.Year, time.Month(t.Month), 1, 0, 0, 0, 0, loc)
+
+	records, err := c.usersSegmentsRepo.GetStats(ctx, entity.StatsFilter{
+		From:  from,
+		To:    from.AddDate(0, 1, 0),
+		Teams: t.Teams,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("usersSegmentsRepo.GetStats: %w", err)
+	}
+
+	tmp := filename + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return 0, fmt.Errorf("os.Create: %w", err)
+	}
+	defer os.Remove(tmp)
+	defer f.Close()
+
+	columns := []string{"Пользователь", "Сегмент", "Операция", "Дата и время", "Источник", "Кто", "Причина"}
+	w := csv.NewWriter(f)
+	w.Write(columns)
+	for _, record := range records {
+		row := []string{
+			strconv.Itoa(record.User),
+			record.Segment,
+			string(record.Operation),
+			record.Created_at.In(loc).Format("2006-01-02 15:04:05"),
+			string(record.Source),
+			record.Actor,
+			record.Reason,
+		}
+		if err := w.Write(row); err != nil {
+			return 0, fmt.Errorf("error writing record to file: %w", err)
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return 0, fmt.Errorf("csv.Writer.Flush: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return 0, fmt.Errorf("os.File.Close: %w", err)
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		return 0, fmt.Errorf("os.Rename: %w", err)
+	}
+	return len(records), nil
+}
@@ -0,0 +1,25 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ABDURAZZAKK/avito_experiment/internal/task"
+	log "github.com/sirupsen/logrus"
+)
+
+// deleteSegmentOnTime turns a removal queued by an older version into the
+// expiry of the memberships; the sweeper removes them once it has passed.
+func (c *Consumer) deleteSegmentOnTime(ctx context.Context, t *task.DeleteSegmentOnTime) error {
+	expiresAt, err := t.ExpiresAt()
+	if err != nil {
+		return fmt.Errorf("task.ExpiresAt: %w", err)
+	}
+	n, err := c.usersSegmentsRepo.SetExpiresAt(ctx, t.UserIds(), t.Segments, expiresAt)
+	if err != nil {
+		return fmt.Errorf("usersSegmentsRepo.SetExpiresAt: %w", err)
+	}
+	log.Infof("consumer - %d memberships of %v expire at %s", n, t.Segments, expiresAt.Format(time.RFC3339))
+	return nil
+}
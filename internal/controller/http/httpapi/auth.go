@@ -0,0 +1,71 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/ABDURAZZAKK/avito_experiment/internal/actor"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/service"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// HEADER_API_KEY carries the key for clients that cannot send
+	// "Authorization: Bearer <key>".
+	HEADER_API_KEY = "X-Api-Key"
+
+	contextApiKey = "apiKey"
+)
+
+// Authenticate rejects requests without a valid API key. The key becomes the
+// actor of the membership changes made by the request, and its team roles
+// are checked by the services.
+func Authenticate(apiKeyService service.ApiKey) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			token := bearerToken(c.Request())
+			if token == "" {
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, "Bearer")
+				return NewError(http.StatusUnauthorized, CODE_UNAUTHORIZED, "api key required")
+			}
+			key, err := apiKeyService.Authenticate(c.Request().Context(), token)
+			if err != nil {
+				if errors.Is(err, service.ErrInvalidApiKey) {
+					c.Response().Header().Set(echo.HeaderWWWAuthenticate, "Bearer")
+				}
+				return err
+			}
+
+			c.Set(contextApiKey, key)
+			req := c.Request()
+			c.SetRequest(req.WithContext(actor.WithKey(req.Context(), key)))
+			return next(c)
+		}
+	}
+}
+
+// RequireScope rejects requests whose key lacks the scope. It runs after Authenticate.
+func RequireScope(scope entity.Scope) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			key, ok := c.Get(contextApiKey).(entity.ApiKey)
+			if !ok || !key.HasScope(scope) {
+				return NewError(http.StatusForbidden, CODE_FORBIDDEN, "api key lacks the "+string(scope)+" scope")
+			}
+			return next(c)
+		}
+	}
+}
+
+func bearerToken(req *http.Request) string {
+	if auth := req.Header.Get(echo.HeaderAuthorization); auth != "" {
+		scheme, token, ok := strings.Cut(auth, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+		return ""
+	}
+	return req.Header.Get(HEADER_API_KEY)
+}
@@ -0,0 +1,21 @@
+package httpapi
+
+import "encoding/base64"
+
+// Page cursors are opaque to clients: the key of the last item of a page,
+// base64 encoded. An empty cursor means the first page.
+
+func EncodeCursor(key string) string {
+	if key == "" {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func DecodeCursor(cursor string) (string, error) {
+	key, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", err
+	}
+	return string(key), nil
+}
@@ -0,0 +1,197 @@
+// Package httpapi holds what the versions of the HTTP API share: the error
+// responses, input validation, authentication, rate limits, idempotency keys,
+// ETags and page cursors.
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/ABDURAZZAKK/avito_experiment/internal/service"
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	CODE_INVALID_REQUEST   = "invalid_request"
+	CODE_UNAUTHORIZED      = "unauthorized"
+	CODE_FORBIDDEN         = "forbidden"
+	CODE_NOT_FOUND         = "not_found"
+	CODE_CONFLICT          = "conflict"
+	CODE_PRECONDITION      = "precondition_failed"
+	CODE_RATE_LIMITED      = "rate_limited"
+	CODE_INTERNAL          = "internal_error"
+	CODE_SEGMENT_NOT_FOUND = "segment_not_found"
+)
+
+// Error is the body of every error response. Code is stable and meant for
+// machines, Message is for people and may change.
+type Error struct {
+	Status  int           `json:"-"`
+	Code    string        `json:"code"`
+	Message string        `json:"message"`
+	Details []ErrorDetail `json:"details,omitempty"`
+}
+
+// ErrorDetail points at the part of the request an error is about: a field of
+// the input or a segment slug.
+type ErrorDetail struct {
+	Field   string `json:"field,omitempty"`
+	Slug    string `json:"slug,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// LegacyError is the body of the v1 error responses, kept for the clients
+// written before Error.
+type LegacyError struct {
+	Message string `json:"message"`
+}
+
+func NewError(status int, code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+func InvalidRequest(message string) *Error {
+	return NewError(http.StatusBadRequest, CODE_INVALID_REQUEST, message)
+}
+
+// serviceErrors maps the errors of the services to responses. The first entry
+// err matches with errors.Is wins.
+var serviceErrors = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{service.ErrSegmentNotFound, http.StatusNotFound, CODE_SEGMENT_NOT_FOUND},
+	{service.ErrSegmentAlreadyExists, http.StatusConflict, "segment_already_exists"},
+	{service.ErrUserAlreadyInSegment, http.StatusConflict, "user_already_in_segment"},
+	{service.ErrSegmentNotActive, http.StatusConflict, "segment_not_active"},
+	{service.ErrUserNotFound, http.StatusNotFound, "user_not_found"},
+	{service.ErrUserAlreadyExists, http.StatusConflict, "user_already_exists"},
+	{service.ErrSegmentsVersionMismatch, http.StatusPreconditionFailed, "segments_version_mismatch"},
+	{service.ErrWebhookNotFound, http.StatusNotFound, "webhook_not_found"},
+	{service.ErrInvalidWebhookURL, http.StatusBadRequest, "invalid_webhook_url"},
+	{service.ErrIdempotencyKeyReused, http.StatusUnprocessableEntity, "idempotency_key_reused"},
+	{service.ErrIdempotencyKeyInProgress, http.StatusConflict, "idempotency_key_in_progress"},
+	{service.ErrInvalidApiKey, http.StatusUnauthorized, "invalid_api_key"},
+	{service.ErrApiKeyNotFound, http.StatusNotFound, "api_key_not_found"},
+	{service.ErrApiKeyAlreadyExists, http.StatusConflict, "api_key_already_exists"},
+	{service.ErrInvalidApiKeyName, http.StatusBadRequest, "invalid_api_key_name"},
+	{service.ErrInvalidScope, http.StatusBadRequest, "invalid_scope"},
+	{service.ErrInvalidRole, http.StatusBadRequest, "invalid_role"},
+	{service.ErrInvalidTeam, http.StatusBadRequest, "invalid_team"},
+	{service.ErrRoleNotFound, http.StatusNotFound, "role_not_found"},
+	{service.ErrExportQuotaExceeded, http.StatusTooManyRequests, "export_quota_exceeded"},
+	{service.ErrExportNotReady, http.StatusConflict, "export_not_ready"},
+	{service.ErrForbidden, http.StatusForbidden, CODE_FORBIDDEN},
+	{service.ErrNotFound, http.StatusNotFound, CODE_NOT_FOUND},
+	{service.ErrAlreadyExists, http.StatusConflict, CODE_CONFLICT},
+}
+
+// legacyStatuses are the statuses v1 answered codes with before conflicts got
+// their own 409.
+var legacyStatuses = map[string]int{
+	"segment_already_exists":  http.StatusBadRequest,
+	"user_already_in_segment": http.StatusBadRequest,
+	"segment_not_active":      http.StatusBadRequest,
+	"user_already_exists":     http.StatusBadRequest,
+	"api_key_already_exists":  http.StatusBadRequest,
+	CODE_CONFLICT:             http.StatusBadRequest,
+}
+
+const contextLegacyErrors = "legacyErrors"
+
+// LegacyErrors makes ErrorHandler answer the requests of the group with the
+// statuses and LegacyError body v1 always had.
+func LegacyErrors(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		c.Set(contextLegacyErrors, true)
+		return next(c)
+	}
+}
+
+// legacy converts e to the status and body of v1. The invalid fields are
+// listed in the message, as LegacyError has no details.
+func (e *Error) legacy() (int, LegacyError) {
+	status := e.Status
+	if legacyStatus, ok := legacyStatuses[e.Code]; ok {
+		status = legacyStatus
+	}
+	fields := make([]string, 0, len(e.Details))
+	for _, detail := range e.Details {
+		if detail.Field != "" {
+			fields = append(fields, detail.Field+" "+detail.Message)
+		}
+	}
+	message := e.Message
+	if len(fields) != 0 {
+		message += ": " + strings.Join(fields, "; ")
+	}
+	return status, LegacyError{Message: message}
+}
+
+// toError maps err to the response it is answered with. Errors that are
+// not known are internal and their message is not shown to the client.
+func toError(err error) *Error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	for _, e := range serviceErrors {
+		if !errors.Is(err, e.err) {
+			continue
+		}
+		apiErr = NewError(e.status, e.code, e.err.Error())
+		var segmentErr *service.SegmentError
+		if errors.As(err, &segmentErr) {
+			apiErr.Message = segmentErr.Error()
+			apiErr.Details = []ErrorDetail{{Slug: segmentErr.Slug, Message: segmentErr.Err.Error()}}
+		}
+		return apiErr
+	}
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		code := strings.ReplaceAll(strings.ToLower(http.StatusText(httpErr.Code)), " ", "_")
+		if httpErr.Code == http.StatusBadRequest {
+			code = CODE_INVALID_REQUEST
+		}
+		message := http.StatusText(httpErr.Code)
+		if m, ok := httpErr.Message.(string); ok {
+			message = m
+		}
+		return NewError(httpErr.Code, code, message)
+	}
+	return NewError(http.StatusInternalServerError, CODE_INTERNAL, "internal server error")
+}
+
+// ErrorHandler writes the response for the error a handler or middleware
+// returned. Handlers return errors instead of writing them, so every error
+// response has the same shape: Error, or LegacyError behind LegacyErrors.
+func ErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+	apiErr := toError(err)
+	if apiErr.Status >= http.StatusInternalServerError {
+		log.Errorf("httpapi - %s %s: %v", c.Request().Method, c.Path(), err)
+	}
+
+	var body interface{} = apiErr
+	status := apiErr.Status
+	if legacy, _ := c.Get(contextLegacyErrors).(bool); legacy {
+		status, body = apiErr.legacy()
+	}
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(status)
+	} else {
+		err = c.JSON(status, body)
+	}
+	if err != nil {
+		log.Errorf("httpapi - ErrorHandler - c.JSON: %v", err)
+	}
+}
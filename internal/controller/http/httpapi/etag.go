@@ -0,0 +1,44 @@
+package httpapi
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	HEADER_ETAG          = "ETag"
+	HEADER_IF_MATCH      = "If-Match"
+	HEADER_IF_NONE_MATCH = "If-None-Match"
+)
+
+// FormatETag returns the ETag of a user's segments: the quoted segments version.
+func FormatETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// ParseETag reads a version from an ETag. Weak ETags are accepted.
+func ParseETag(etag string) (int64, bool) {
+	etag = strings.TrimPrefix(strings.TrimSpace(etag), "W/")
+	if len(etag) < 2 || etag[0] != '"' || etag[len(etag)-1] != '"' {
+		return 0, false
+	}
+	version, err := strconv.ParseInt(etag[1:len(etag)-1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return version, true
+}
+
+// ETagMatches reports whether the comma separated list of an If-None-Match or
+// If-Match header holds "*" or the given version.
+func ETagMatches(header string, version int64) bool {
+	for _, etag := range strings.Split(header, ",") {
+		if strings.TrimSpace(etag) == "*" {
+			return true
+		}
+		if v, ok := ParseETag(etag); ok && v == version {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/service"
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	HEADER_IDEMPOTENCY_KEY = "Idempotency-Key"
+	// HEADER_IDEMPOTENT_REPLAYED marks a response replayed from an earlier request.
+	HEADER_IDEMPOTENT_REPLAYED = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 255
+)
+
+// replayedHeaders are stored with the response and replayed with it.
+var replayedHeaders = []string{echo.HeaderLocation, "ETag"}
+
+// Idempotency makes mutating requests sent with an Idempotency-Key header safe
+// to retry. The first request with a key runs normally and its response is
+// stored; repeats of the same request get that response again, and the key
+// used with a different method, path or body is rejected. Keys are scoped to
+// the API key. Server errors are not stored, so the request can be retried
+// with the same key.
+func Idempotency(idempotencyService service.Idempotency) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			key := c.Request().Header.Get(HEADER_IDEMPOTENCY_KEY)
+			if key == "" || !isMutating(c.Request().Method) {
+				return next(c)
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				return InvalidRequest("idempotency key is too long")
+			}
+
+			body, err := io.ReadAll(c.Request().Body)
+			if err != nil {
+				return InvalidRequest("invalid request body")
+			}
+			c.Request().Body = io.NopCloser(bytes.NewReader(body))
+
+			var apiKeyId int64
+			if apiKey, ok := c.Get(contextApiKey).(entity.ApiKey); ok {
+				apiKeyId = apiKey.Id
+			}
+			ctx := c.Request().Context()
+			record, reserved, err := idempotencyService.Begin(ctx, apiKeyId, key, requestHash(c, body))
+			if err != nil {
+				return err
+			}
+			if !reserved {
+				for name, value := range record.ResponseHeaders {
+					c.Response().Header().Set(name, value)
+				}
+				c.Response().Header().Set(HEADER_IDEMPOTENT_REPLAYED, "true")
+				return c.Blob(*record.ResponseStatus, record.ResponseContentType, record.ResponseBody)
+			}
+
+			recorder := &responseRecorder{ResponseWriter: c.Response().Writer}
+			c.Response().Writer = recorder
+			completed := false
+			defer func() {
+				if !completed {
+					release(ctx, idempotencyService, record)
+				}
+			}()
+
+			// the error is written here rather than by ErrorHandler, so the
+			// response passes through the recorder and is stored
+			if err = next(c); err != nil {
+				c.Error(err)
+			}
+
+			status := c.Response().Status
+			if !c.Response().Committed || status >= http.StatusInternalServerError {
+				return err
+			}
+			contentType := c.Response().Header().Get(echo.HeaderContentType)
+			headers := map[string]string{}
+			for _, name := range replayedHeaders {
+				if value := c.Response().Header().Get(name); value != "" {
+					headers[name] = value
+				}
+			}
+			if cerr := idempotencyService.Complete(context.WithoutCancel(ctx), record, status, contentType, headers, recorder.body.Bytes()); cerr != nil {
+				log.Errorf("httpapi - idempotency - idempotencyService.Complete: %v", cerr)
+				return err
+			}
+			completed = true
+			return err
+		}
+	}
+}
+
+func release(ctx context.Context, idempotencyService service.Idempotency, reservation entity.IdempotencyKey) {
+	if err := idempotencyService.Release(context.WithoutCancel(ctx), reservation); err != nil {
+		log.Errorf("httpapi - idempotency - idempotencyService.Release: %v", err)
+	}
+}
+
+func isMutating(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// requestHash identifies a request by its method, path with query and body.
+func requestHash(c echo.Context, body []byte) string {
+	req := c.Request()
+	h := sha256.New()
+	h.Write([]byte(req.Method + " " + req.URL.RequestURI() + "\n"))
+	h.Write([]byte(strconv.Itoa(len(body)) + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// responseRecorder keeps a copy of the response body written through it.
+type responseRecorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
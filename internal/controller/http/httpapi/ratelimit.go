@@ -0,0 +1,69 @@
+package httpapi
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
+	"github.com/ABDURAZZAKK/avito_experiment/pkg/ratelimit"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	HEADER_RATE_LIMIT_LIMIT     = "RateLimit-Limit"
+	HEADER_RATE_LIMIT_REMAINING = "RateLimit-Remaining"
+	HEADER_RATE_LIMIT_RESET     = "RateLimit-Reset"
+)
+
+// RateLimiters hold the token buckets of the API keys for each route class.
+type RateLimiters struct {
+	Reads   *ratelimit.Limiter
+	Writes  *ratelimit.Limiter
+	Exports *ratelimit.Limiter
+}
+
+// RateLimit takes a token from the bucket of the request's key for its route
+// class and answers 429 when the bucket is empty. exportRoutes start exports
+// and take tokens from the export buckets instead of the write ones. It runs
+// after Authenticate.
+func RateLimit(limiters RateLimiters, exportRoutes ...string) echo.MiddlewareFunc {
+	exports := make(map[string]bool, len(exportRoutes))
+	for _, route := range exportRoutes {
+		exports[route] = true
+	}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			key, ok := c.Get(contextApiKey).(entity.ApiKey)
+			if !ok {
+				return next(c)
+			}
+			limiter := limiters.Writes
+			switch {
+			case exports[c.Path()]:
+				limiter = limiters.Exports
+			case !isMutating(c.Request().Method):
+				limiter = limiters.Reads
+			}
+
+			result := limiter.Allow(strconv.FormatInt(key.Id, 10))
+			if result.Limit > 0 {
+				header := c.Response().Header()
+				header.Set(HEADER_RATE_LIMIT_LIMIT, strconv.Itoa(result.Limit))
+				header.Set(HEADER_RATE_LIMIT_REMAINING, strconv.Itoa(result.Remaining))
+				header.Set(HEADER_RATE_LIMIT_RESET, FormatSeconds(result.Reset))
+			}
+			if !result.Allowed {
+				c.Response().Header().Set(echo.HeaderRetryAfter, FormatSeconds(result.RetryAfter))
+				return NewError(http.StatusTooManyRequests, CODE_RATE_LIMITED, "rate limit exceeded")
+			}
+			return next(c)
+		}
+	}
+}
+
+// FormatSeconds formats d as whole seconds, rounded up.
+func FormatSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
@@ -0,0 +1,43 @@
+package httpapi
+
+import (
+	"time"
+	_ "time/tzdata"
+)
+
+const (
+	DELETE_AT_LAYOUT   = "2006-01-02 15:04:05"
+	DELETE_AT_LOCATION = "Europe/Moscow"
+)
+
+// ParseDeleteAt converts the delete_at field of a request into the expiry time
+// of the memberships it creates. An empty value means the membership never expires.
+func ParseDeleteAt(deleteAt string) (*time.Time, error) {
+	if deleteAt == "" {
+		return nil, nil
+	}
+	loc, err := time.LoadLocation(DELETE_AT_LOCATION)
+	if err != nil {
+		return nil, err
+	}
+	t, err := time.ParseInLocation(DELETE_AT_LAYOUT, deleteAt, loc)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// ParseTimeIn accepts an RFC 3339 timestamp or a date with optional time of day.
+// Values without an explicit offset are read in loc. An empty value gives the zero time.
+func ParseTimeIn(value string, loc *time.Location) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(DELETE_AT_LAYOUT, value, loc); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, loc)
+}
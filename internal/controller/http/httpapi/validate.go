@@ -0,0 +1,153 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	MAX_SEGMENT_SLUG_LENGTH = 150
+	MAX_USER_SLUG_LENGTH    = 100
+	MAX_TEAM_LENGTH         = 100
+	MAX_REASON_LENGTH       = 1000
+)
+
+// segmentSlugPattern is the format of new segment slugs. Slugs end up in the
+// routing keys of the events, so dots and the AMQP wildcards are left out.
+var segmentSlugPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// Validatable inputs check their fields after binding.
+type Validatable interface {
+	Validate() error
+}
+
+// Bind binds the request into input and validates it.
+func Bind(c echo.Context, input Validatable) error {
+	if err := c.Bind(input); err != nil {
+		return InvalidRequest("invalid request body")
+	}
+	return input.Validate()
+}
+
+// Validator collects every invalid field of an input, so one response can
+// list all of them.
+type Validator struct {
+	details []ErrorDetail
+}
+
+func (v *Validator) Check(ok bool, field, message string) {
+	if !ok {
+		v.details = append(v.details, ErrorDetail{Field: field, Message: message})
+	}
+}
+
+// Err is nil when every check passed.
+func (v *Validator) Err() error {
+	if len(v.details) == 0 {
+		return nil
+	}
+	err := NewError(http.StatusBadRequest, CODE_INVALID_REQUEST, "invalid request")
+	err.Details = v.details
+	return err
+}
+
+func (v *Validator) Id(field string, id int64) {
+	v.Check(id > 0, field, "must be a positive id")
+}
+
+func (v *Validator) Limit(field string, limit int) {
+	v.Check(limit >= 0, field, "must not be negative")
+}
+
+// NewSegmentSlug checks the slug of a segment that is being created.
+func (v *Validator) NewSegmentSlug(field, slug string) {
+	switch {
+	case slug == "":
+		v.Check(false, field, "is required")
+	case len(slug) > MAX_SEGMENT_SLUG_LENGTH:
+		v.Check(false, field, fmt.Sprintf("must be at most %d characters", MAX_SEGMENT_SLUG_LENGTH))
+	default:
+		v.Check(segmentSlugPattern.MatchString(slug), field, "must contain only letters, digits, '_' and '-'")
+	}
+}
+
+// SegmentSlug checks the slug of an existing segment. Segments created before
+// the slug format was enforced are still accepted here.
+func (v *Validator) SegmentSlug(field, slug string) {
+	switch {
+	case slug == "":
+		v.Check(false, field, "is required")
+	default:
+		v.Check(len(slug) <= MAX_SEGMENT_SLUG_LENGTH, field, fmt.Sprintf("must be at most %d characters", MAX_SEGMENT_SLUG_LENGTH))
+	}
+}
+
+// SegmentSlugs checks each slug of the list with check and rejects repeats.
+// It returns the slugs that passed, for checks across lists.
+func (v *Validator) SegmentSlugs(field string, slugs []string, check func(field, slug string)) map[string]bool {
+	seen := make(map[string]bool, len(slugs))
+	for i, slug := range slugs {
+		itemField := fmt.Sprintf("%s[%d]", field, i)
+		if seen[slug] {
+			v.Check(false, itemField, fmt.Sprintf("%q is repeated", slug))
+			continue
+		}
+		before := len(v.details)
+		check(itemField, slug)
+		if len(v.details) == before {
+			seen[slug] = true
+		}
+	}
+	return seen
+}
+
+func (v *Validator) MaxLength(field, value string, max int) {
+	v.Check(len(value) <= max, field, fmt.Sprintf("must be at most %d characters", max))
+}
+
+// Percent checks that percent is within [min, 100].
+func (v *Validator) Percent(field string, percent, min int) {
+	v.Check(percent >= min && percent <= 100, field, fmt.Sprintf("must be between %d and 100", min))
+}
+
+// DeleteAt checks the format of a delete_at field and that it is in the future.
+func (v *Validator) DeleteAt(field, value string) {
+	t, err := ParseDeleteAt(value)
+	if err != nil {
+		v.Check(false, field, fmt.Sprintf("must be formatted as %q", DELETE_AT_LAYOUT))
+		return
+	}
+	v.Check(t == nil || t.After(time.Now()), field, "must be in the future")
+}
+
+// Location checks an IANA time zone name and returns the zone, nil when name
+// is not one. "Local", the zone of the server, is not accepted.
+func (v *Validator) Location(field, name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil || name == "" || name == "Local" {
+		v.Check(false, field, "must be an IANA time zone")
+		return nil
+	}
+	return loc
+}
+
+// TimeIn checks a value for ParseTimeIn. The time zone is not known yet, so
+// the value is only checked for its format.
+func (v *Validator) TimeIn(field, value string) {
+	_, err := ParseTimeIn(value, time.UTC)
+	v.Check(err == nil, field, "must be an RFC 3339 timestamp, a date or a date with time")
+}
+
+// Cursor checks a page cursor. Numeric cursors hold an id.
+func (v *Validator) Cursor(field, cursor string, numeric bool) {
+	key, err := DecodeCursor(cursor)
+	if err == nil && numeric && cursor != "" {
+		_, err = strconv.ParseInt(key, 10, 64)
+	}
+	v.Check(err == nil, field, "is not a cursor returned by this API")
+}
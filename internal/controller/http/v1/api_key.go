@@ -0,0 +1,223 @@
+package v1
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ABDURAZZAKK/avito_experiment/internal/controller/http/httpapi"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/service"
+	"github.com/labstack/echo/v4"
+)
+
+type apiKeyRoutes struct {
+	apiKeyService service.ApiKey
+}
+
+func newApiKeyRoutes(g *echo.Group, apiKeyService service.ApiKey) {
+	r := &apiKeyRoutes{
+		apiKeyService: apiKeyService,
+	}
+	g.Use(httpapi.RequireScope(entity.SCOPE_KEYS))
+	g.POST("", r.issue)
+	g.GET("", r.list)
+	g.DELETE("/:id", r.revoke)
+	g.PUT("/:id/roles/:team", r.setRole)
+	g.DELETE("/:id/roles/:team", r.deleteRole)
+}
+
+type apiKeyResponse struct {
+	Id     int64          `json:"id"`
+	Name   string         `json:"name"`
+	Prefix string         `json:"prefix"`
+	Scopes []entity.Scope `json:"scopes"`
+	// Roles maps teams to the role of the key in them.
+	Roles map[string]entity.Role `json:"roles"`
+	// Key is only returned when the key is issued.
+	Key        string     `json:"key,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+func newApiKeyResponse(key entity.ApiKey) apiKeyResponse {
+	roles := key.Roles
+	if roles == nil {
+		roles = map[string]entity.Role{}
+	}
+	return apiKeyResponse{
+		Id:         key.Id,
+		Name:       key.Name,
+		Prefix:     key.Prefix,
+		Scopes:     key.Scopes,
+		Roles:      roles,
+		CreatedAt:  key.CreatedAt,
+		LastUsedAt: key.LastUsedAt,
+		RevokedAt:  key.RevokedAt,
+	}
+}
+
+type apiKeyIssueInput struct {
+	Name   string                 `json:"name"`
+	Scopes []entity.Scope         `json:"scopes"`
+	Roles  map[string]entity.Role `json:"roles"`
+}
+
+func (i *apiKeyIssueInput) Validate() error {
+	var v httpapi.Validator
+	v.Check(i.Name != "", "name", "is required")
+	v.Check(len(i.Scopes) != 0, "scopes", "must not be empty")
+	for j, scope := range i.Scopes {
+		v.Check(scope.Valid(), fmt.Sprintf("scopes[%d]", j), "is not a known scope")
+	}
+	for team, role := range i.Roles {
+		v.Check(team != "", "roles", "team must not be empty")
+		v.MaxLength("roles."+team, team, httpapi.MAX_TEAM_LENGTH)
+		v.Check(role.Valid(), "roles."+team, "must be viewer, editor or admin")
+	}
+	return v.Err()
+}
+
+// @Summary Issue API key
+// @Description Issue a key with the scopes and roles. Roles take the admin role in their teams. The key is only shown in this response
+// @Tags Keys
+// @Accept json
+// @Produce json
+// @Success 201 {object} v1.apiKeyResponse
+// @Failure 400 {object} httpapi.LegacyError
+// @Failure 403 {object} httpapi.LegacyError
+// @Failure 500 {object} httpapi.LegacyError
+// @Router /api/v1/keys [post]
+func (r *apiKeyRoutes) issue(c echo.Context) error {
+	var input apiKeyIssueInput
+	if err := httpapi.Bind(c, &input); err != nil {
+		return err
+	}
+	output, err := r.apiKeyService.Issue(c.Request().Context(), service.ApiKeyIssueInput{
+		Name:   input.Name,
+		Scopes: input.Scopes,
+		Roles:  input.Roles,
+	})
+	if err != nil {
+		return err
+	}
+
+	response := newApiKeyResponse(output.Key)
+	response.Key = output.Token
+	return c.JSON(http.StatusCreated, response)
+}
+
+// @Summary List API keys
+// @Tags Keys
+// @Accept json
+// @Produce json
+// @Success 200 {object} v1.apiKeyRoutes.list.response
+// @Failure 500 {object} httpapi.LegacyError
+// @Router /api/v1/keys [get]
+func (r *apiKeyRoutes) list(c echo.Context) error {
+	keys, err := r.apiKeyService.List(c.Request().Context())
+	if err != nil {
+		return err
+	}
+
+	type response struct {
+		Keys []apiKeyResponse `json:"keys"`
+	}
+	items := make([]apiKeyResponse, 0, len(keys))
+	for _, key := range keys {
+		items = append(items, newApiKeyResponse(key))
+	}
+	return c.JSON(http.StatusOK, response{
+		Keys: items,
+	})
+}
+
+type apiKeyIdInput struct {
+	Id int64 `param:"id"`
+}
+
+func (i *apiKeyIdInput) Validate() error {
+	var v httpapi.Validator
+	v.Id("id", i.Id)
+	return v.Err()
+}
+
+// @Summary Revoke API key
+// @Tags Keys
+// @Accept json
+// @Produce json
+// @Success 204
+// @Failure 400 {object} httpapi.LegacyError
+// @Failure 404 {object} httpapi.LegacyError
+// @Failure 500 {object} httpapi.LegacyError
+// @Router /api/v1/keys/{id} [delete]
+func (r *apiKeyRoutes) revoke(c echo.Context) error {
+	var input apiKeyIdInput
+	if err := httpapi.Bind(c, &input); err != nil {
+		return err
+	}
+	err := r.apiKeyService.Revoke(c.Request().Context(), input.Id)
+	if err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+type apiKeyRoleInput struct {
+	Id   int64       `param:"id"`
+	Team string      `param:"team"`
+	Role entity.Role `json:"role"`
+}
+
+func (i *apiKeyRoleInput) Validate() error {
+	var v httpapi.Validator
+	v.Id("id", i.Id)
+	v.MaxLength("team", i.Team, httpapi.MAX_TEAM_LENGTH)
+	return v.Err()
+}
+
+// @Summary Set API key role
+// @Description Give the key a role in the team, replacing the one it had. Team * is every team. Takes the admin role in the team
+// @Tags Keys
+// @Accept json
+// @Produce json
+// @Success 204
+// @Failure 400 {object} httpapi.LegacyError
+// @Failure 403 {object} httpapi.LegacyError
+// @Failure 404 {object} httpapi.LegacyError
+// @Failure 500 {object} httpapi.LegacyError
+// @Router /api/v1/keys/{id}/roles/{team} [put]
+func (r *apiKeyRoutes) setRole(c echo.Context) error {
+	var input apiKeyRoleInput
+	if err := httpapi.Bind(c, &input); err != nil {
+		return err
+	}
+	err := r.apiKeyService.SetRole(c.Request().Context(), input.Id, input.Team, input.Role)
+	if err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// @Summary Delete API key role
+// @Tags Keys
+// @Accept json
+// @Produce json
+// @Success 204
+// @Failure 400 {object} httpapi.LegacyError
+// @Failure 403 {object} httpapi.LegacyError
+// @Failure 404 {object} httpapi.LegacyError
+// @Failure 500 {object} httpapi.LegacyError
+// @Router /api/v1/keys/{id}/roles/{team} [delete]
+func (r *apiKeyRoutes) deleteRole(c echo.Context) error {
+	var input apiKeyRoleInput
+	if err := httpapi.Bind(c, &input); err != nil {
+		return err
+	}
+	err := r.apiKeyService.DeleteRole(c.Request().Context(), input.Id, input.Team)
+	if err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
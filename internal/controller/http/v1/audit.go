@@ -0,0 +1,111 @@
+package v1
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ABDURAZZAKK/avito_experiment/internal/controller/http/httpapi"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/service"
+	"github.com/labstack/echo/v4"
+)
+
+type auditRoutes struct {
+	auditService service.Audit
+}
+
+func newAuditRoutes(g *echo.Group, auditService service.Audit) {
+	r := &auditRoutes{
+		auditService: auditService,
+	}
+	g.GET("", r.list, httpapi.RequireScope(entity.SCOPE_KEYS))
+}
+
+type listAuditInput struct {
+	Actor  string `query:"actor"`
+	Action string `query:"action"`
+	Team   string `query:"team"`
+	Cursor string `query:"cursor"`
+	Limit  int    `query:"limit"`
+}
+
+func (i *listAuditInput) Validate() error {
+	var v httpapi.Validator
+	v.Cursor("cursor", i.Cursor, true)
+	v.Limit("limit", i.Limit)
+	return v.Err()
+}
+
+// @Summary Get audit log
+// @Description Get the denied attempts recorded in the audit log ordered by id. Requires the admin role in all teams
+// @Tags Keys
+// @Accept json
+// @Produce json
+// @Success 200 {object} v1.auditRoutes.list.response
+// @Failure 400 {object} httpapi.LegacyError
+// @Failure 403 {object} httpapi.LegacyError
+// @Failure 500 {object} httpapi.LegacyError
+// @Router /api/v1/audit [get]
+func (r *auditRoutes) list(c echo.Context) error {
+	var input listAuditInput
+	if err := httpapi.Bind(c, &input); err != nil {
+		return err
+	}
+	var after int64
+	if input.Cursor != "" {
+		key, err := httpapi.DecodeCursor(input.Cursor)
+		if err == nil {
+			after, err = strconv.ParseInt(key, 10, 64)
+		}
+		if err != nil {
+			return httpapi.InvalidRequest("invalid cursor")
+		}
+	}
+	output, err := r.auditService.List(c.Request().Context(), entity.AuditFilter{
+		Actor:  input.Actor,
+		Action: input.Action,
+		Team:   input.Team,
+		After:  after,
+		Limit:  input.Limit,
+	})
+	if err != nil {
+		return err
+	}
+
+	type entry struct {
+		Id        int64               `json:"id"`
+		Actor     string              `json:"actor"`
+		Action    string              `json:"action"`
+		Resource  string              `json:"resource"`
+		Team      string              `json:"team"`
+		Outcome   entity.AuditOutcome `json:"outcome"`
+		Reason    string              `json:"reason,omitempty"`
+		CreatedAt time.Time           `json:"created_at"`
+	}
+	type response struct {
+		Entries    []entry `json:"entries"`
+		NextCursor string  `json:"next_cursor,omitempty"`
+	}
+	entries := make([]entry, 0, len(output.Entries))
+	for _, e := range output.Entries {
+		entries = append(entries, entry{
+			Id:        e.Id,
+			Actor:     e.Actor,
+			Action:    e.Action,
+			Resource:  e.Resource,
+			Team:      e.Team,
+			Outcome:   e.Outcome,
+			Reason:    e.Reason,
+			CreatedAt: e.CreatedAt,
+		})
+	}
+	var next string
+	if output.Next > 0 {
+		next = httpapi.EncodeCursor(strconv.FormatInt(output.Next, 10))
+	}
+	return c.JSON(http.StatusOK, response{
+		Entries:    entries,
+		NextCursor: next,
+	})
+}
@@ -1,65 +1,172 @@
 package v1
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"path/filepath"
 	"time"
 
-	"github.com/ABDURAZZAKK/avito_experiment/pkg/broker"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/controller/http/httpapi"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/service"
 	"github.com/labstack/echo/v4"
 )
 
 type fileRoutes struct {
-	Rabbit *broker.RabbitMQ
+	exportService service.Export
 }
 
-func newFileRoutes(g *echo.Group, rabbit *broker.RabbitMQ) {
+func newFileRoutes(g *echo.Group, exportService service.Export) {
 	r := &fileRoutes{
-		Rabbit: rabbit,
+		exportService: exportService,
 	}
-	g.POST("/createCSVPerStats", r.createCSVFromUsersSegments)
+	g.POST("/createCSVPerStats", r.createCSVFromUsersSegments, httpapi.RequireScope(entity.SCOPE_STATS_EXPORT))
+	g.GET("/exports/:id", r.getExport, httpapi.RequireScope(entity.SCOPE_STATS_EXPORT))
+	g.GET("/exports/:id/file", r.getExportFile, httpapi.RequireScope(entity.SCOPE_STATS_EXPORT))
+}
+
+type exportResponse struct {
+	Id         int64               `json:"id"`
+	Status     entity.ExportStatus `json:"status"`
+	Year       int                 `json:"year"`
+	Month      int                 `json:"month"`
+	Timezone   string              `json:"timezone"`
+	RowCount   int                 `json:"row_count"`
+	Error      string              `json:"error,omitempty"`
+	URL        string              `json:"url,omitempty"`
+	CreatedAt  time.Time           `json:"created_at"`
+	StartedAt  *time.Time          `json:"started_at,omitempty"`
+	FinishedAt *time.Time          `json:"finished_at,omitempty"`
+}
+
+// newExportResponse only exposes the download link once the file is written.
+// The link points at the host the request was sent to and needs the same API
+// key as the rest of the API.
+func newExportResponse(c echo.Context, job entity.ExportJob) exportResponse {
+	response := exportResponse{
+		Id:         job.Id,
+		Status:     job.Status,
+		Year:       job.Year,
+		Month:      job.Month,
+		Timezone:   job.Timezone,
+		RowCount:   job.RowCount,
+		Error:      job.Error,
+		CreatedAt:  job.CreatedAt,
+		StartedAt:  job.StartedAt,
+		FinishedAt: job.FinishedAt,
+	}
+	if job.Status == entity.EXPORT_SUCCEEDED {
+		response.URL = fmt.Sprintf("%s://%s/api/v1/stats/exports/%d/file", c.Scheme(), c.Request().Host, job.Id)
+	}
+	return response
 }
 
 type createCSVInput struct {
-	Year  int `json:"year"`
-	Month int `json:"month"`
+	Year     int    `json:"year"`
+	Month    int    `json:"month"`
+	Timezone string `json:"timezone,omitempty"`
+
+	// location is the parsed Timezone, UTC when none was sent.
+	location *time.Location
 }
 
+func (i *createCSVInput) Validate() error {
+	var v httpapi.Validator
+	v.Check(i.Year > 0, "year", "must be positive")
+	v.Check(i.Month >= 1 && i.Month <= 12, "month", "must be between 1 and 12")
+	i.location = time.UTC
+	if i.Timezone != "" {
+		i.location = v.Location("timezone", i.Timezone)
+	}
+	return v.Err()
+}
+
+// @Summary Export stats to CSV
+// @Description Queue a CSV export of the stats for a month
+// @Tags Stats
+// @Accept json
+// @Produce json
+// @Success 202 {object} v1.exportResponse
+// @Failure 400 {object} httpapi.LegacyError
+// @Failure 429 {object} httpapi.LegacyError
+// @Failure 500 {object} httpapi.LegacyError
+// @Router /api/v1/stats/createCSVPerStats [post]
 func (r *fileRoutes) createCSVFromUsersSegments(c echo.Context) error {
 	var input createCSVInput
-	if err := c.Bind(&input); err != nil || input.Month <= 0 || input.Month > 12 {
-		newErrorResponse(c, http.StatusBadRequest, "invalid request body")
+	if err := httpapi.Bind(c, &input); err != nil {
 		return err
 	}
-	filename := fmt.Sprintf("%s/user_segments_%v.csv",
-		STATIC_CSV_PATH,
-		time.Date(
-			input.Year,
-			time.Month(input.Month),
-			1, 0, 0, 0, 0, time.Local).
-			Format("2006_01_02"))
-
-	msg, err := broker.MsgSerialize(broker.Message{
-		"task":     "createCSVFromUsersSegments",
-		"year":     input.Year,
-		"month":    input.Month,
-		"filename": filename,
+	job, err := r.exportService.Create(c.Request().Context(), service.ExportCreateInput{
+		Year:     input.Year,
+		Month:    input.Month,
+		Location: input.location,
 	})
 	if err != nil {
-		newErrorResponse(c, http.StatusInternalServerError, "internal server error")
+		if errors.Is(err, service.ErrExportQuotaExceeded) {
+			// the daily quota resets at the next UTC midnight
+			now := time.Now().UTC()
+			midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+			c.Response().Header().Set(echo.HeaderRetryAfter, httpapi.FormatSeconds(midnight.Sub(now)))
+		}
 		return err
 	}
 
-	err = r.Rabbit.Publish(msg)
-	if err != nil {
-		newErrorResponse(c, http.StatusInternalServerError, "internal server error")
+	return c.JSON(http.StatusAccepted, newExportResponse(c, job))
+}
+
+type getExportInput struct {
+	Id int64 `param:"id"`
+}
+
+func (i *getExportInput) Validate() error {
+	var v httpapi.Validator
+	v.Id("id", i.Id)
+	return v.Err()
+}
+
+// @Summary Get export
+// @Description Get the state of a CSV export and its download link once ready
+// @Tags Stats
+// @Accept json
+// @Produce json
+// @Success 200 {object} v1.exportResponse
+// @Failure 400 {object} httpapi.LegacyError
+// @Failure 403 {object} httpapi.LegacyError
+// @Failure 404 {object} httpapi.LegacyError
+// @Failure 500 {object} httpapi.LegacyError
+// @Router /api/v1/stats/exports/{id} [get]
+func (r *fileRoutes) getExport(c echo.Context) error {
+	var input getExportInput
+	if err := httpapi.Bind(c, &input); err != nil {
 		return err
 	}
-	type response struct {
-		URL string `json:"url"`
+	job, err := r.exportService.GetById(c.Request().Context(), input.Id)
+	if err != nil {
+		return err
 	}
-	return c.JSON(http.StatusOK, response{
-		URL: fmt.Sprintf("http://localhost:8000/%s", filename),
-	})
+	return c.JSON(http.StatusOK, newExportResponse(c, job))
+}
 
+// @Summary Download export
+// @Description Download the CSV file of a succeeded export. Only the key that started the export and admins of every team may download it.
+// @Tags Stats
+// @Produce text/csv
+// @Success 200 {file} file
+// @Failure 400 {object} httpapi.LegacyError
+// @Failure 403 {object} httpapi.LegacyError
+// @Failure 404 {object} httpapi.LegacyError
+// @Failure 409 {object} httpapi.LegacyError
+// @Failure 500 {object} httpapi.LegacyError
+// @Router /api/v1/stats/exports/{id}/file [get]
+func (r *fileRoutes) getExportFile(c echo.Context) error {
+	var input getExportInput
+	if err := httpapi.Bind(c, &input); err != nil {
+		return err
+	}
+	filename, err := r.exportService.GetFile(c.Request().Context(), input.Id)
+	if err != nil {
+		return err
+	}
+	return c.Attachment(filename, filepath.Base(filename))
 }
@@ -3,34 +3,33 @@ package v1
 import (
 	"os"
 
+	"github.com/ABDURAZZAKK/avito_experiment/internal/controller/http/httpapi"
 	"github.com/ABDURAZZAKK/avito_experiment/internal/service"
 
 	log "github.com/sirupsen/logrus"
 
-	"github.com/ABDURAZZAKK/avito_experiment/pkg/broker"
-
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 )
 
-const (
-	STATIC_CSV_PATH = "assets/csv"
-)
-
-func NewRouter(handler *echo.Echo, services *service.Services, rabbit *broker.RabbitMQ) {
+func NewRouter(handler *echo.Echo, services *service.Services, limiters httpapi.RateLimiters) {
 	handler.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{
 		Format: `{"time":"${time_rfc3339_nano}", "method":"${method}","uri":"${uri}", "status":${status},"error":"${error}"}` + "\n",
 		Output: setLogsFile(),
 	}))
 	handler.Use(middleware.Recover())
-	handler.Static("/assets/csv", STATIC_CSV_PATH)
 	handler.GET("/health", func(c echo.Context) error { return c.NoContent(200) })
 
-	v1 := handler.Group("/api/v1")
+	v1 := handler.Group("/api/v1", httpapi.LegacyErrors, httpapi.Authenticate(services.ApiKey), httpapi.RateLimit(limiters, "/api/v1/stats/createCSVPerStats"), httpapi.Idempotency(services.Idempotency))
 	{
-		newUserRoutes(v1.Group("/users"), services.User, rabbit)
-		newSegmentRoutes(v1.Group("/segments"), services.Segment, services.User, rabbit)
-		newFileRoutes(v1.Group("/stats"), rabbit)
+		newUserRoutes(v1.Group("/users"), services.User)
+		newSegmentRoutes(v1.Group("/segments"), services.Segment)
+		stats := v1.Group("/stats")
+		newStatsRoutes(stats, services.Stats)
+		newFileRoutes(stats, services.Export)
+		newWebhookRoutes(v1.Group("/webhooks"), services.Webhook)
+		newApiKeyRoutes(v1.Group("/keys"), services.ApiKey)
+		newAuditRoutes(v1.Group("/audit"), services.Audit)
 	}
 }
 
@@ -1,36 +1,325 @@
 package v1
 
 import (
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/ABDURAZZAKK/avito_experiment/internal/controller/http/httpapi"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
 	"github.com/ABDURAZZAKK/avito_experiment/internal/service"
-	"github.com/ABDURAZZAKK/avito_experiment/pkg/broker"
 	"github.com/labstack/echo/v4"
 )
 
 type segmentRoutes struct {
 	segmentService service.Segment
-	userService    service.User
-	Rabbit         *broker.RabbitMQ
 }
 
-func newSegmentRoutes(g *echo.Group, segmentService service.Segment, userService service.User, rabbit *broker.RabbitMQ) *segmentRoutes {
+func newSegmentRoutes(g *echo.Group, segmentService service.Segment) *segmentRoutes {
 	r := &segmentRoutes{
 		segmentService: segmentService,
-		userService:    userService,
-		Rabbit:         rabbit,
 	}
 
-	g.POST("/create", r.create)
-	g.POST("/createAll", r.createAll)
-	g.DELETE("/delete", r.delete)
+	g.GET("", r.list, httpapi.RequireScope(entity.SCOPE_SEGMENTS_READ))
+	g.GET("/:slug", r.get, httpapi.RequireScope(entity.SCOPE_SEGMENTS_READ))
+	g.PATCH("/:slug", r.update, httpapi.RequireScope(entity.SCOPE_SEGMENTS_WRITE))
+	g.GET("/:slug/users", r.members, httpapi.RequireScope(entity.SCOPE_SEGMENTS_READ))
+	g.POST("/create", r.create, httpapi.RequireScope(entity.SCOPE_SEGMENTS_WRITE))
+	g.POST("/createAll", r.createAll, httpapi.RequireScope(entity.SCOPE_SEGMENTS_WRITE))
+	g.POST("/rollout", r.rollout, httpapi.RequireScope(entity.SCOPE_SEGMENTS_WRITE))
+	g.DELETE("/delete", r.delete, httpapi.RequireScope(entity.SCOPE_SEGMENTS_WRITE))
 	return r
 }
 
+type segmentResponse struct {
+	Slug              string               `json:"slug"`
+	Description       string               `json:"description"`
+	Owner             string               `json:"owner"`
+	Team              string               `json:"team"`
+	Status            entity.SegmentStatus `json:"status"`
+	Tags              []string             `json:"tags"`
+	RolloutPercentage int                  `json:"rollout_percentage"`
+	RolloutExpiresAt  *time.Time           `json:"rollout_expires_at,omitempty"`
+	CreatedAt         time.Time            `json:"created_at"`
+	UpdatedAt         time.Time            `json:"updated_at"`
+	Members           *int                 `json:"members,omitempty"`
+}
+
+func newSegmentResponse(segment entity.Segment) segmentResponse {
+	return segmentResponse{
+		Slug:              segment.Slug,
+		Description:       segment.Description,
+		Owner:             segment.Owner,
+		Team:              segment.Team,
+		Status:            segment.Status,
+		Tags:              segment.Tags,
+		RolloutPercentage: segment.RolloutPercentage,
+		RolloutExpiresAt:  segment.RolloutExpiresAt,
+		CreatedAt:         segment.CreatedAt,
+		UpdatedAt:         segment.UpdatedAt,
+	}
+}
+
+type listSegmentsInput struct {
+	Prefix     string               `query:"prefix"`
+	Status     entity.SegmentStatus `query:"status"`
+	Tag        string               `query:"tag"`
+	Team       string               `query:"team"`
+	Cursor     string               `query:"cursor"`
+	Limit      int                  `query:"limit"`
+	WithCounts bool                 `query:"with_counts"`
+}
+
+func (i *listSegmentsInput) Validate() error {
+	var v httpapi.Validator
+	v.Check(i.Status == "" || i.Status.Valid(), "status", "must be draft, active, paused or archived")
+	v.Cursor("cursor", i.Cursor, false)
+	v.Limit("limit", i.Limit)
+	return v.Err()
+}
+
+// @Summary List segments
+// @Description List segments ordered by slug, optionally with member counts
+// @Tags Segments
+// @Accept json
+// @Produce json
+// @Success 200 {object} v1.segmentRoutes.list.response
+// @Failure 400 {object} httpapi.LegacyError
+// @Failure 500 {object} httpapi.LegacyError
+// @Router /api/v1/segments [get]
+func (r *segmentRoutes) list(c echo.Context) error {
+	var input listSegmentsInput
+	if err := httpapi.Bind(c, &input); err != nil {
+		return err
+	}
+	after, err := httpapi.DecodeCursor(input.Cursor)
+	if err != nil {
+		return httpapi.InvalidRequest("invalid cursor")
+	}
+	output, err := r.segmentService.List(c.Request().Context(), entity.SegmentFilter{
+		SlugPrefix: input.Prefix,
+		Status:     input.Status,
+		Tag:        input.Tag,
+		Team:       input.Team,
+		After:      after,
+		Limit:      input.Limit,
+	}, input.WithCounts)
+	if err != nil {
+		return err
+	}
+
+	type response struct {
+		Segments   []segmentResponse `json:"segments"`
+		NextCursor string            `json:"next_cursor,omitempty"`
+	}
+	segments := make([]segmentResponse, 0, len(output.Segments))
+	for _, segment := range output.Segments {
+		item := newSegmentResponse(segment)
+		if output.Members != nil {
+			members := output.Members[segment.Slug]
+			item.Members = &members
+		}
+		segments = append(segments, item)
+	}
+	return c.JSON(http.StatusOK, response{
+		Segments:   segments,
+		NextCursor: httpapi.EncodeCursor(output.Next),
+	})
+}
+
+type getSegmentInput struct {
+	Slug string `param:"slug"`
+}
+
+func (i *getSegmentInput) Validate() error {
+	var v httpapi.Validator
+	v.SegmentSlug("slug", i.Slug)
+	return v.Err()
+}
+
+// @Summary Get segment
+// @Description Get segment with its metadata
+// @Tags Segments
+// @Accept json
+// @Produce json
+// @Success 200 {object} v1.segmentResponse
+// @Failure 404 {object} httpapi.LegacyError
+// @Failure 500 {object} httpapi.LegacyError
+// @Router /api/v1/segments/{slug} [get]
+func (r *segmentRoutes) get(c echo.Context) error {
+	var input getSegmentInput
+	if err := httpapi.Bind(c, &input); err != nil {
+		return err
+	}
+	segment, err := r.segmentService.GetBySlug(c.Request().Context(), input.Slug)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, newSegmentResponse(segment))
+}
+
+type segmentMembersInput struct {
+	Slug          string    `param:"slug"`
+	Cursor        string    `query:"cursor"`
+	Limit         int       `query:"limit"`
+	AssignedFrom  time.Time `query:"assigned_from"`
+	AssignedTo    time.Time `query:"assigned_to"`
+	ExpiresAfter  time.Time `query:"expires_after"`
+	ExpiresBefore time.Time `query:"expires_before"`
+	HasExpiry     *bool     `query:"has_expiry"`
+}
+
+func (i *segmentMembersInput) Validate() error {
+	var v httpapi.Validator
+	v.SegmentSlug("slug", i.Slug)
+	v.Cursor("cursor", i.Cursor, true)
+	v.Limit("limit", i.Limit)
+	return v.Err()
+}
+
+// @Summary Get segment members
+// @Description List users of the segment ordered by id
+// @Tags Segments
+// @Accept json
+// @Produce json
+// @Success 200 {object} v1.segmentRoutes.members.response
+// @Failure 400 {object} httpapi.LegacyError
+// @Failure 404 {object} httpapi.LegacyError
+// @Failure 500 {object} httpapi.LegacyError
+// @Router /api/v1/segments/{slug}/users [get]
+func (r *segmentRoutes) members(c echo.Context) error {
+	var input segmentMembersInput
+	if err := httpapi.Bind(c, &input); err != nil {
+		return err
+	}
+	var after int
+	if input.Cursor != "" {
+		key, err := httpapi.DecodeCursor(input.Cursor)
+		if err == nil {
+			after, err = strconv.Atoi(key)
+		}
+		if err != nil {
+			return httpapi.InvalidRequest("invalid cursor")
+		}
+	}
+	output, err := r.segmentService.GetMembers(c.Request().Context(), entity.MembersFilter{
+		Segment:       input.Slug,
+		After:         after,
+		Limit:         input.Limit,
+		AssignedFrom:  input.AssignedFrom,
+		AssignedTo:    input.AssignedTo,
+		ExpiresAfter:  input.ExpiresAfter,
+		ExpiresBefore: input.ExpiresBefore,
+		HasExpiry:     input.HasExpiry,
+	})
+	if err != nil {
+		return err
+	}
+
+	type member struct {
+		Id         int        `json:"id"`
+		AssignedAt time.Time  `json:"assigned_at"`
+		ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	}
+	type response struct {
+		Users      []member `json:"users"`
+		NextCursor string   `json:"next_cursor,omitempty"`
+	}
+	users := make([]member, 0, len(output.Members))
+	for _, m := range output.Members {
+		users = append(users, member{
+			Id:         m.User,
+			AssignedAt: m.AssignedAt,
+			ExpiresAt:  m.ExpiresAt,
+		})
+	}
+	var next string
+	if output.Next > 0 {
+		next = httpapi.EncodeCursor(strconv.Itoa(output.Next))
+	}
+	return c.JSON(http.StatusOK, response{
+		Users:      users,
+		NextCursor: next,
+	})
+}
+
+type updateSegmentInput struct {
+	Slug        string                `param:"slug"`
+	Description *string               `json:"description"`
+	Owner       *string               `json:"owner"`
+	Team        *string               `json:"team"`
+	Status      *entity.SegmentStatus `json:"status"`
+	Tags        *[]string             `json:"tags"`
+}
+
+func (i *updateSegmentInput) Validate() error {
+	var v httpapi.Validator
+	v.SegmentSlug("slug", i.Slug)
+	if i.Team != nil {
+		v.MaxLength("team", *i.Team, httpapi.MAX_TEAM_LENGTH)
+	}
+	v.Check(i.Status == nil || i.Status.Valid(), "status", "must be draft, active, paused or archived")
+	if i.Tags != nil {
+		for j, tag := range *i.Tags {
+			v.Check(tag != "", fmt.Sprintf("tags[%d]", j), "must not be empty")
+		}
+	}
+	return v.Err()
+}
+
+// @Summary Update segment
+// @Description Update segment metadata and lifecycle status
+// @Tags Segments
+// @Accept json
+// @Produce json
+// @Success 200 {object} v1.segmentResponse
+// @Failure 400 {object} httpapi.LegacyError
+// @Failure 404 {object} httpapi.LegacyError
+// @Failure 500 {object} httpapi.LegacyError
+// @Router /api/v1/segments/{slug} [patch]
+func (r *segmentRoutes) update(c echo.Context) error {
+	var input updateSegmentInput
+	if err := httpapi.Bind(c, &input); err != nil {
+		return err
+	}
+	segment, err := r.segmentService.Update(c.Request().Context(), input.Slug, service.SegmentUpdateInput{
+		Description: input.Description,
+		Owner:       input.Owner,
+		Team:        input.Team,
+		Status:      input.Status,
+		Tags:        input.Tags,
+	})
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, newSegmentResponse(segment))
+}
+
 type segmentCreateInput struct {
-	Slug              string `json:"slug"`
-	PercentageOfUsers int    `json:"percentage_of_users,omitempty"`
-	DeleteAt          string `json:"delete_at,omitempty"`
+	Slug              string               `json:"slug"`
+	Description       string               `json:"description,omitempty"`
+	Owner             string               `json:"owner,omitempty"`
+	Team              string               `json:"team"`
+	Status            entity.SegmentStatus `json:"status,omitempty"`
+	Tags              []string             `json:"tags,omitempty"`
+	PercentageOfUsers int                  `json:"percentage_of_users,omitempty"`
+	DeleteAt          string               `json:"delete_at,omitempty"`
+	Reason            string               `json:"reason,omitempty"`
+}
+
+func (i *segmentCreateInput) Validate() error {
+	var v httpapi.Validator
+	v.NewSegmentSlug("slug", i.Slug)
+	v.MaxLength("team", i.Team, httpapi.MAX_TEAM_LENGTH)
+	v.Check(i.Status == "" || i.Status.Valid(), "status", "must be draft, active, paused or archived")
+	for j, tag := range i.Tags {
+		v.Check(tag != "", fmt.Sprintf("tags[%d]", j), "must not be empty")
+	}
+	v.Percent("percentage_of_users", i.PercentageOfUsers, 0)
+	v.DeleteAt("delete_at", i.DeleteAt)
+	v.MaxLength("reason", i.Reason, httpapi.MAX_REASON_LENGTH)
+	return v.Err()
 }
 
 // @Summary Create segment
@@ -39,51 +328,32 @@ type segmentCreateInput struct {
 // @Accept json
 // @Produce json
 // @Success 201 {object} v1.segmentRoutes.create.response
-// @Failure 400 {object} echo.HTTPError
-// @Failure 500 {object} echo.HTTPError
+// @Failure 400 {object} httpapi.LegacyError
+// @Failure 500 {object} httpapi.LegacyError
 // @Router /api/v1/segments/create [post]
 func (r *segmentRoutes) create(c echo.Context) error {
 	var input segmentCreateInput
-	if err := c.Bind(&input); err != nil || input.PercentageOfUsers > 100 {
-		newErrorResponse(c, http.StatusBadRequest, "invalid request body")
+	if err := httpapi.Bind(c, &input); err != nil {
 		return err
 	}
-	var users []int
-	var err error
-	if input.PercentageOfUsers > 0 && input.DeleteAt != "" {
-		users, err = r.userService.GetRandomIDsWithPercent(c.Request().Context(), input.PercentageOfUsers)
-		if err != nil {
-			newErrorResponse(c, http.StatusInternalServerError, "internal server error")
-			return err
-		}
+	expiresAt, err := httpapi.ParseDeleteAt(input.DeleteAt)
+	if err != nil {
+		return httpapi.InvalidRequest("invalid delete_at")
 	}
-	slug, err := r.segmentService.Create(c.Request().Context(), input.Slug, users)
+	slug, err := r.segmentService.Create(c.Request().Context(), service.SegmentCreateInput{
+		Slug:        input.Slug,
+		Description: input.Description,
+		Owner:       input.Owner,
+		Team:        input.Team,
+		Status:      input.Status,
+		Tags:        input.Tags,
+		Percent:     input.PercentageOfUsers,
+		ExpiresAt:   expiresAt,
+		Reason:      input.Reason,
+	})
 	if err != nil {
-		if err == service.ErrAlreadyExists {
-			newErrorResponse(c, http.StatusBadRequest, err.Error())
-			return err
-		}
-		newErrorResponse(c, http.StatusInternalServerError, "internal server error")
 		return err
 	}
-	if len(users) > 0 {
-		msg, err := broker.MsgSerialize(broker.Message{
-			"task":     "DeleteSegmentFromUserOnTime",
-			"time":     input.DeleteAt,
-			"users":    users,
-			"segments": []string{input.Slug},
-		})
-		if err != nil {
-			newErrorResponse(c, http.StatusInternalServerError, "internal server error")
-			return err
-		}
-
-		err = r.Rabbit.Publish(msg)
-		if err != nil {
-			newErrorResponse(c, http.StatusInternalServerError, "internal server error")
-			return err
-		}
-	}
 
 	type response struct {
 		Slug string `json:"slug"`
@@ -96,8 +366,21 @@ func (r *segmentRoutes) create(c echo.Context) error {
 
 type segmentCreateAllInput struct {
 	Slugs             []string `json:"slugs"`
+	Team              string   `json:"team"`
 	PercentageOfUsers int      `json:"percentage_of_users,omitempty"`
 	DeleteAt          string   `json:"delete_at,omitempty"`
+	Reason            string   `json:"reason,omitempty"`
+}
+
+func (i *segmentCreateAllInput) Validate() error {
+	var v httpapi.Validator
+	v.Check(len(i.Slugs) != 0, "slugs", "must not be empty")
+	v.SegmentSlugs("slugs", i.Slugs, v.NewSegmentSlug)
+	v.MaxLength("team", i.Team, httpapi.MAX_TEAM_LENGTH)
+	v.Percent("percentage_of_users", i.PercentageOfUsers, 0)
+	v.DeleteAt("delete_at", i.DeleteAt)
+	v.MaxLength("reason", i.Reason, httpapi.MAX_REASON_LENGTH)
+	return v.Err()
 }
 
 // @Summary Create segment
@@ -106,53 +389,23 @@ type segmentCreateAllInput struct {
 // @Accept json
 // @Produce json
 // @Success 201 {object} v1.segmentRoutes.create.response
-// @Failure 400 {object} echo.HTTPError
-// @Failure 500 {object} echo.HTTPError
+// @Failure 400 {object} httpapi.LegacyError
+// @Failure 500 {object} httpapi.LegacyError
 // @Router /api/v1/segments/createAll [post]
 func (r *segmentRoutes) createAll(c echo.Context) error {
 	var input segmentCreateAllInput
-	if err := c.Bind(&input); err != nil || len(input.Slugs) == 0 || input.PercentageOfUsers > 100 {
-		newErrorResponse(c, http.StatusBadRequest, "invalid request body")
+	if err := httpapi.Bind(c, &input); err != nil {
 		return err
 	}
-	var users []int
-	var err error
-	if input.PercentageOfUsers > 0 && input.DeleteAt != "" {
-		users, err = r.userService.GetRandomIDsWithPercent(c.Request().Context(), input.PercentageOfUsers)
-		if err != nil {
-			newErrorResponse(c, http.StatusInternalServerError, "internal server error")
-			return err
-		}
+	expiresAt, err := httpapi.ParseDeleteAt(input.DeleteAt)
+	if err != nil {
+		return httpapi.InvalidRequest("invalid delete_at")
 	}
-	err = r.segmentService.CreateAll(c.Request().Context(), input.Slugs, users)
+	err = r.segmentService.CreateAll(c.Request().Context(), input.Slugs, input.Team, input.PercentageOfUsers, expiresAt, input.Reason)
 	if err != nil {
-		if err == service.ErrAlreadyExists {
-			newErrorResponse(c, http.StatusBadRequest, err.Error())
-			return err
-		}
-		newErrorResponse(c, http.StatusInternalServerError, "internal server error")
 		return err
 	}
 
-	if len(users) > 0 {
-		msg, err := broker.MsgSerialize(broker.Message{
-			"task":     "DeleteSegmentFromUserOnTime",
-			"time":     input.DeleteAt,
-			"users":    users,
-			"segments": input.Slugs,
-		})
-		if err != nil {
-			newErrorResponse(c, http.StatusInternalServerError, "internal server error")
-			return err
-		}
-
-		err = r.Rabbit.Publish(msg)
-		if err != nil {
-			newErrorResponse(c, http.StatusInternalServerError, "internal server error")
-			return err
-		}
-	}
-
 	type response struct {
 		Message string `json:"message"`
 	}
@@ -162,32 +415,81 @@ func (r *segmentRoutes) createAll(c echo.Context) error {
 	})
 }
 
+type segmentRolloutInput struct {
+	Slug              string `json:"slug"`
+	PercentageOfUsers int    `json:"percentage_of_users"`
+	DeleteAt          string `json:"delete_at,omitempty"`
+	Reason            string `json:"reason,omitempty"`
+}
+
+func (i *segmentRolloutInput) Validate() error {
+	var v httpapi.Validator
+	v.SegmentSlug("slug", i.Slug)
+	v.Percent("percentage_of_users", i.PercentageOfUsers, 1)
+	v.DeleteAt("delete_at", i.DeleteAt)
+	v.MaxLength("reason", i.Reason, httpapi.MAX_REASON_LENGTH)
+	return v.Err()
+}
+
+// @Summary Rollout segment
+// @Description Add the segment to a stable percentage of users
+// @Tags Segments
+// @Accept json
+// @Produce json
+// @Success 200 {object} v1.segmentRoutes.rollout.response
+// @Failure 400 {object} httpapi.LegacyError
+// @Failure 404 {object} httpapi.LegacyError
+// @Failure 500 {object} httpapi.LegacyError
+// @Router /api/v1/segments/rollout [post]
+func (r *segmentRoutes) rollout(c echo.Context) error {
+	var input segmentRolloutInput
+	if err := httpapi.Bind(c, &input); err != nil {
+		return err
+	}
+	expiresAt, err := httpapi.ParseDeleteAt(input.DeleteAt)
+	if err != nil {
+		return httpapi.InvalidRequest("invalid delete_at")
+	}
+	added, err := r.segmentService.Rollout(c.Request().Context(), input.Slug, input.PercentageOfUsers, expiresAt, input.Reason)
+	if err != nil {
+		return err
+	}
+	type response struct {
+		Slug  string `json:"slug"`
+		Added int    `json:"added"`
+	}
+	return c.JSON(http.StatusOK, response{
+		Slug:  input.Slug,
+		Added: added,
+	})
+}
+
 type deleteSegmentInput struct {
 	Slug string `json:"slug"`
 }
 
+func (i *deleteSegmentInput) Validate() error {
+	var v httpapi.Validator
+	v.SegmentSlug("slug", i.Slug)
+	return v.Err()
+}
+
 // @Summary Delete segment
 // @Description Delete segment
 // @Tags Segments
 // @Accept json
 // @Produce json
 // @Success 200 {object} v1.segmentRoutes.create.response
-// @Failure 400 {object} echo.HTTPError
-// @Failure 500 {object} echo.HTTPError
+// @Failure 400 {object} httpapi.LegacyError
+// @Failure 500 {object} httpapi.LegacyError
 // @Router /api/v1/segments/delete [delete]
 func (r *segmentRoutes) delete(c echo.Context) error {
 	var input deleteSegmentInput
-	if err := c.Bind(&input); err != nil {
-		newErrorResponse(c, http.StatusBadRequest, "invalid request body")
+	if err := httpapi.Bind(c, &input); err != nil {
 		return err
 	}
 	slug, err := r.segmentService.Delete(c.Request().Context(), input.Slug)
 	if err != nil {
-		if err == service.ErrNotFound {
-			newErrorResponse(c, http.StatusNotFound, err.Error())
-			return err
-		}
-		newErrorResponse(c, http.StatusInternalServerError, "internal server error")
 		return err
 	}
 	type response struct {
@@ -0,0 +1,139 @@
+package v1
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ABDURAZZAKK/avito_experiment/internal/controller/http/httpapi"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/service"
+	"github.com/labstack/echo/v4"
+)
+
+type statsRoutes struct {
+	statsService service.Stats
+}
+
+func newStatsRoutes(g *echo.Group, statsService service.Stats) {
+	r := &statsRoutes{
+		statsService: statsService,
+	}
+	g.GET("", r.get, httpapi.RequireScope(entity.SCOPE_STATS_READ))
+}
+
+type getStatsInput struct {
+	From      string           `query:"from"`
+	To        string           `query:"to"`
+	Timezone  string           `query:"tz"`
+	User      int              `query:"user"`
+	Segment   string           `query:"segment"`
+	Operation entity.Operation `query:"operation"`
+	Actor     string           `query:"actor"`
+	Source    entity.Source    `query:"source"`
+	Cursor    string           `query:"cursor"`
+	Limit     int              `query:"limit"`
+
+	// location is the parsed Timezone, UTC when none was sent.
+	location *time.Location
+}
+
+func (i *getStatsInput) Validate() error {
+	var v httpapi.Validator
+	v.TimeIn("from", i.From)
+	v.TimeIn("to", i.To)
+	i.location = time.UTC
+	if i.Timezone != "" {
+		i.location = v.Location("tz", i.Timezone)
+	}
+	v.Check(i.User >= 0, "user", "must not be negative")
+	v.Check(i.Operation == "" || i.Operation == entity.SEGMENT_ADDED || i.Operation == entity.SEGMENT_REMOVED,
+		"operation", "must be segment_added or segment_removed")
+	v.Check(i.Source == "" || i.Source.Valid(), "source", "is not a known source")
+	v.Cursor("cursor", i.Cursor, true)
+	v.Limit("limit", i.Limit)
+	return v.Err()
+}
+
+// @Summary Get stats
+// @Description Get the history of segment membership changes within [from, to) of the segments of the teams the key is a viewer in
+// @Tags Stats
+// @Accept json
+// @Produce json
+// @Success 200 {object} v1.statsRoutes.get.response
+// @Failure 400 {object} httpapi.LegacyError
+// @Failure 500 {object} httpapi.LegacyError
+// @Router /api/v1/stats [get]
+func (r *statsRoutes) get(c echo.Context) error {
+	var input getStatsInput
+	if err := httpapi.Bind(c, &input); err != nil {
+		return err
+	}
+	from, err := httpapi.ParseTimeIn(input.From, input.location)
+	if err != nil {
+		return httpapi.InvalidRequest("invalid from")
+	}
+	to, err := httpapi.ParseTimeIn(input.To, input.location)
+	if err != nil {
+		return httpapi.InvalidRequest("invalid to")
+	}
+	var after int64
+	if input.Cursor != "" {
+		key, err := httpapi.DecodeCursor(input.Cursor)
+		if err == nil {
+			after, err = strconv.ParseInt(key, 10, 64)
+		}
+		if err != nil {
+			return httpapi.InvalidRequest("invalid cursor")
+		}
+	}
+
+	output, err := r.statsService.Get(c.Request().Context(), entity.StatsFilter{
+		From:      from,
+		To:        to,
+		User:      input.User,
+		Segment:   input.Segment,
+		Operation: input.Operation,
+		Actor:     input.Actor,
+		Source:    input.Source,
+		After:     after,
+		Limit:     input.Limit,
+	})
+	if err != nil {
+		return err
+	}
+
+	type record struct {
+		User      int              `json:"user"`
+		Segment   string           `json:"segment"`
+		Operation entity.Operation `json:"operation"`
+		Actor     string           `json:"actor"`
+		Source    entity.Source    `json:"source"`
+		Reason    string           `json:"reason,omitempty"`
+		CreatedAt time.Time        `json:"created_at"`
+	}
+	type response struct {
+		Stats      []record `json:"stats"`
+		NextCursor string   `json:"next_cursor,omitempty"`
+	}
+	stats := make([]record, 0, len(output.Stats))
+	for _, s := range output.Stats {
+		stats = append(stats, record{
+			User:      s.User,
+			Segment:   s.Segment,
+			Operation: s.Operation,
+			Actor:     s.Actor,
+			Source:    s.Source,
+			Reason:    s.Reason,
+			CreatedAt: s.Created_at.In(input.location),
+		})
+	}
+	var next string
+	if output.Next > 0 {
+		next = httpapi.EncodeCursor(strconv.FormatInt(output.Next, 10))
+	}
+	return c.JSON(http.StatusOK, response{
+		Stats:      stats,
+		NextCursor: next,
+	})
+}
@@ -1,58 +1,59 @@
 package v1
 
 import (
+	"fmt"
 	"net/http"
+	"strings"
 
+	"github.com/ABDURAZZAKK/avito_experiment/internal/controller/http/httpapi"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
 	"github.com/ABDURAZZAKK/avito_experiment/internal/service"
-	"github.com/ABDURAZZAKK/avito_experiment/pkg/broker"
 
 	"github.com/labstack/echo/v4"
 )
 
 type userRoutes struct {
 	userService service.User
-	Rabbit      *broker.RabbitMQ
 }
 
-func newUserRoutes(g *echo.Group, userService service.User, rabbit *broker.RabbitMQ) {
+func newUserRoutes(g *echo.Group, userService service.User) {
 	r := &userRoutes{
 		userService: userService,
-		Rabbit:      rabbit,
 	}
-	g.GET("", r.get)
-	g.GET("/segments", r.getSegments)
-	g.POST("/create", r.create)
-	g.POST("/addSegments", r.addSegments)
-	g.DELETE("/delete", r.delete)
-
+	g.GET("", r.get, httpapi.RequireScope(entity.SCOPE_USERS_READ))
+	g.GET("/segments", r.getSegments, httpapi.RequireScope(entity.SCOPE_USERS_READ))
+	g.POST("/create", r.create, httpapi.RequireScope(entity.SCOPE_USERS_WRITE))
+	g.POST("/addSegments", r.addSegments, httpapi.RequireScope(entity.SCOPE_USERS_WRITE))
+	g.DELETE("/delete", r.delete, httpapi.RequireScope(entity.SCOPE_USERS_WRITE))
 }
 
 type userCreateInput struct {
 	Slug string `json:"slug"`
 }
 
+func (i *userCreateInput) Validate() error {
+	var v httpapi.Validator
+	v.Check(i.Slug != "", "slug", "is required")
+	v.MaxLength("slug", i.Slug, httpapi.MAX_USER_SLUG_LENGTH)
+	return v.Err()
+}
+
 // @Summary Create user
 // @Description Create user
 // @Tags users
 // @Accept json
 // @Produce json
 // @Success 201 {object} v1.userRoutes.create.response
-// @Failure 400 {object} echo.HTTPError
-// @Failure 500 {object} echo.HTTPError
+// @Failure 400 {object} httpapi.LegacyError
+// @Failure 500 {object} httpapi.LegacyError
 // @Router /api/v1/users/create [post]
 func (r *userRoutes) create(c echo.Context) error {
 	var input userCreateInput
-	if err := c.Bind(&input); err != nil {
-		newErrorResponse(c, http.StatusBadRequest, "invalid request body")
+	if err := httpapi.Bind(c, &input); err != nil {
 		return err
 	}
 	id, err := r.userService.Create(c.Request().Context(), input.Slug)
 	if err != nil {
-		if err == service.ErrUserAlreadyExists {
-			newErrorResponse(c, http.StatusBadRequest, err.Error())
-			return err
-		}
-		newErrorResponse(c, http.StatusInternalServerError, "internal server error")
 		return err
 	}
 
@@ -69,28 +70,28 @@ type getUserInput struct {
 	Id int `query:"id"`
 }
 
+func (i *getUserInput) Validate() error {
+	var v httpapi.Validator
+	v.Id("id", int64(i.Id))
+	return v.Err()
+}
+
 // @Summary Get user
 // @Description Get user
 // @Tags users
 // @Accept json
 // @Produce json
 // @Success 201 {object} v1.userRoutes.create.response
-// @Failure 400 {object} echo.HTTPError
-// @Failure 500 {object} echo.HTTPError
+// @Failure 400 {object} httpapi.LegacyError
+// @Failure 500 {object} httpapi.LegacyError
 // @Router /api/v1/users [get]
 func (r *userRoutes) get(c echo.Context) error {
 	var input getUserInput
-	if err := c.Bind(&input); err != nil || input.Id <= 0 {
-		newErrorResponse(c, http.StatusBadRequest, "invalid request body")
+	if err := httpapi.Bind(c, &input); err != nil {
 		return err
 	}
 	user, err := r.userService.GetById(c.Request().Context(), input.Id)
 	if err != nil {
-		if err == service.ErrUserNotFound {
-			newErrorResponse(c, http.StatusNotFound, err.Error())
-			return err
-		}
-		newErrorResponse(c, http.StatusInternalServerError, "internal server error")
 		return err
 	}
 	type response struct {
@@ -107,26 +108,44 @@ type getUserSegmentsInput struct {
 	Id int `query:"id"`
 }
 
+func (i *getUserSegmentsInput) Validate() error {
+	var v httpapi.Validator
+	v.Id("id", int64(i.Id))
+	return v.Err()
+}
+
+// @Summary Get user segments
+// @Description Get the user's segments. The ETag is the version of the set; send it
+// @Description back in If-None-Match to get 304 while nothing changed
+// @Tags users
+// @Accept json
+// @Produce json
+// @Success 200 {object} v1.userRoutes.getSegments.response
+// @Success 304
+// @Failure 400 {object} httpapi.LegacyError
+// @Failure 404 {object} httpapi.LegacyError
+// @Failure 500 {object} httpapi.LegacyError
+// @Router /api/v1/users/segments [get]
 func (r *userRoutes) getSegments(c echo.Context) error {
 	var input getUserSegmentsInput
-	if err := c.Bind(&input); err != nil || input.Id <= 0 {
-		newErrorResponse(c, http.StatusBadRequest, "invalid request body")
+	if err := httpapi.Bind(c, &input); err != nil {
 		return err
 	}
-	segments, err := r.userService.GetSegments(c.Request().Context(), input.Id)
+	output, err := r.userService.GetSegments(c.Request().Context(), input.Id)
 	if err != nil {
-		if err == service.ErrUserNotFound {
-			newErrorResponse(c, http.StatusNotFound, err.Error())
-			return err
-		}
-		newErrorResponse(c, http.StatusInternalServerError, "internal server error")
 		return err
 	}
+
+	c.Response().Header().Set(httpapi.HEADER_ETAG, httpapi.FormatETag(output.Version))
+	if ifNoneMatch := c.Request().Header.Get(httpapi.HEADER_IF_NONE_MATCH); ifNoneMatch != "" && httpapi.ETagMatches(ifNoneMatch, output.Version) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
 	type response struct {
 		Segments []string `json:"segments"`
 	}
 	return c.JSON(http.StatusOK, response{
-		Segments: segments,
+		Segments: output.Segments,
 	})
 }
 
@@ -135,78 +154,91 @@ type changeUserSegmentsInput struct {
 	AddList    []string `json:"add_list"`
 	RemoveList []string `json:"remove_list"`
 	DeleteAt   string   `json:"delete_at,omitempty"`
+	Reason     string   `json:"reason,omitempty"`
+}
+
+func (i *changeUserSegmentsInput) Validate() error {
+	var v httpapi.Validator
+	v.Id("id", int64(i.Id))
+	v.Check(len(i.AddList) != 0 || len(i.RemoveList) != 0, "add_list", "add_list or remove_list must not be empty")
+	added := v.SegmentSlugs("add_list", i.AddList, v.SegmentSlug)
+	for j, slug := range i.RemoveList {
+		v.Check(!added[slug], fmt.Sprintf("remove_list[%d]", j), fmt.Sprintf("%q is also in add_list", slug))
+	}
+	v.SegmentSlugs("remove_list", i.RemoveList, v.SegmentSlug)
+	v.DeleteAt("delete_at", i.DeleteAt)
+	v.MaxLength("reason", i.Reason, httpapi.MAX_REASON_LENGTH)
+	return v.Err()
 }
 
+// @Summary Change user segments
+// @Description Add and remove segments of the user. With If-Match the change only
+// @Description applies while the segments are still at that ETag, otherwise 412
+// @Tags users
+// @Accept json
+// @Produce json
+// @Success 201 {object} v1.userRoutes.addSegments.response
+// @Failure 400 {object} httpapi.LegacyError
+// @Failure 404 {object} httpapi.LegacyError
+// @Failure 412 {object} httpapi.LegacyError
+// @Failure 500 {object} httpapi.LegacyError
+// @Router /api/v1/users/addSegments [post]
 func (r *userRoutes) addSegments(c echo.Context) error {
 	var input changeUserSegmentsInput
-	if err := c.Bind(&input); err != nil ||
-		input.Id <= 0 ||
-		len(input.AddList) == 0 && len(input.RemoveList) == 0 {
-		newErrorResponse(c, http.StatusBadRequest, "invalid request body")
+	if err := httpapi.Bind(c, &input); err != nil {
 		return err
 	}
-	err := r.userService.ChangeSegments(c.Request().Context(), input.Id, input.AddList, input.RemoveList)
+	expiresAt, err := httpapi.ParseDeleteAt(input.DeleteAt)
 	if err != nil {
-		if err == service.ErrAlreadyExists {
-			newErrorResponse(c, http.StatusBadRequest, err.Error())
-			return err
-		}
-		if err == service.ErrUserNotFound {
-			newErrorResponse(c, http.StatusNotFound, err.Error())
-			return err
-		}
-		if err == service.ErrNotFound {
-			newErrorResponse(c, http.StatusNotFound, err.Error())
-			return err
-		}
-		newErrorResponse(c, http.StatusInternalServerError, "internal server error")
-		return err
+		return httpapi.InvalidRequest("invalid delete_at")
 	}
-	if input.DeleteAt != "" {
-		msg, err := broker.MsgSerialize(broker.Message{
-			"task":     "DeleteSegmentFromUserOnTime",
-			"time":     input.DeleteAt,
-			"user":     input.Id,
-			"segments": input.AddList,
-		})
-		if err != nil {
-			newErrorResponse(c, http.StatusInternalServerError, "internal server error")
-			return err
-		}
-
-		err = r.Rabbit.Publish(msg)
-		if err != nil {
-			newErrorResponse(c, http.StatusInternalServerError, "internal server error")
-			return err
+	var ifVersion *int64
+	if ifMatch := strings.TrimSpace(c.Request().Header.Get(httpapi.HEADER_IF_MATCH)); ifMatch != "" && ifMatch != "*" {
+		version, ok := httpapi.ParseETag(ifMatch)
+		if !ok {
+			return httpapi.NewError(http.StatusPreconditionFailed, httpapi.CODE_PRECONDITION, "invalid If-Match")
 		}
+		ifVersion = &version
+	}
+	output, err := r.userService.ChangeSegments(c.Request().Context(), input.Id, service.UserChangeSegmentsInput{
+		AddList:    input.AddList,
+		RemoveList: input.RemoveList,
+		ExpiresAt:  expiresAt,
+		IfVersion:  ifVersion,
+		Reason:     input.Reason,
+	})
+	if err != nil {
+		return err
 	}
-
 	type response struct {
 		Message string `json:"message"`
 	}
 
+	c.Response().Header().Set(httpapi.HEADER_ETAG, httpapi.FormatETag(output.Version))
 	return c.JSON(http.StatusCreated, response{
 		Message: "Success",
 	})
 }
 
 type deleteUserInput struct {
-	Id int `json:"id"`
+	Id     int    `json:"id"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func (i *deleteUserInput) Validate() error {
+	var v httpapi.Validator
+	v.Id("id", int64(i.Id))
+	v.MaxLength("reason", i.Reason, httpapi.MAX_REASON_LENGTH)
+	return v.Err()
 }
 
 func (r *userRoutes) delete(c echo.Context) error {
 	var input deleteUserInput
-	if err := c.Bind(&input); err != nil || input.Id <= 0 {
-		newErrorResponse(c, http.StatusBadRequest, "invalid request body")
+	if err := httpapi.Bind(c, &input); err != nil {
 		return err
 	}
-	id, err := r.userService.Delete(c.Request().Context(), input.Id)
+	id, err := r.userService.Delete(c.Request().Context(), input.Id, input.Reason)
 	if err != nil {
-		if err == service.ErrUserNotFound {
-			newErrorResponse(c, http.StatusNotFound, err.Error())
-			return err
-		}
-		newErrorResponse(c, http.StatusInternalServerError, "internal server error")
 		return err
 	}
 	type response struct {
@@ -0,0 +1,264 @@
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ABDURAZZAKK/avito_experiment/internal/controller/http/httpapi"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/service"
+	"github.com/labstack/echo/v4"
+)
+
+type webhookRoutes struct {
+	webhookService service.Webhook
+}
+
+func newWebhookRoutes(g *echo.Group, webhookService service.Webhook) {
+	r := &webhookRoutes{
+		webhookService: webhookService,
+	}
+	g.Use(httpapi.RequireScope(entity.SCOPE_WEBHOOKS))
+	g.POST("", r.create)
+	g.GET("", r.list)
+	g.GET("/:id", r.get)
+	g.DELETE("/:id", r.delete)
+	g.GET("/:id/deliveries", r.deliveries)
+}
+
+type webhookResponse struct {
+	Id       int64    `json:"id"`
+	URL      string   `json:"url"`
+	Segments []string `json:"segments"`
+	// Secret is only returned when the webhook is created.
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newWebhookResponse(webhook entity.Webhook) webhookResponse {
+	segments := webhook.Segments
+	if segments == nil {
+		segments = []string{}
+	}
+	return webhookResponse{
+		Id:        webhook.Id,
+		URL:       webhook.URL,
+		Segments:  segments,
+		CreatedAt: webhook.CreatedAt,
+	}
+}
+
+type webhookCreateInput struct {
+	URL      string   `json:"url"`
+	Segments []string `json:"segments"`
+}
+
+func (i *webhookCreateInput) Validate() error {
+	var v httpapi.Validator
+	v.Check(i.URL != "", "url", "is required")
+	v.SegmentSlugs("segments", i.Segments, v.SegmentSlug)
+	return v.Err()
+}
+
+// @Summary Create webhook
+// @Description Subscribe a URL to the membership changes of some or all segments. Takes the viewer role in the teams of the segments, or in every team without segments
+// @Tags Webhooks
+// @Accept json
+// @Produce json
+// @Success 201 {object} v1.webhookResponse
+// @Failure 400 {object} httpapi.LegacyError
+// @Failure 403 {object} httpapi.LegacyError
+// @Failure 404 {object} httpapi.LegacyError
+// @Failure 500 {object} httpapi.LegacyError
+// @Router /api/v1/webhooks [post]
+func (r *webhookRoutes) create(c echo.Context) error {
+	var input webhookCreateInput
+	if err := httpapi.Bind(c, &input); err != nil {
+		return err
+	}
+	webhook, err := r.webhookService.Create(c.Request().Context(), service.WebhookCreateInput{
+		URL:      input.URL,
+		Segments: input.Segments,
+	})
+	if err != nil {
+		return err
+	}
+
+	response := newWebhookResponse(webhook)
+	response.Secret = webhook.Secret
+	return c.JSON(http.StatusCreated, response)
+}
+
+// @Summary List webhooks
+// @Tags Webhooks
+// @Accept json
+// @Produce json
+// @Success 200 {object} v1.webhookRoutes.list.response
+// @Failure 500 {object} httpapi.LegacyError
+// @Router /api/v1/webhooks [get]
+func (r *webhookRoutes) list(c echo.Context) error {
+	webhooks, err := r.webhookService.List(c.Request().Context())
+	if err != nil {
+		return err
+	}
+
+	type response struct {
+		Webhooks []webhookResponse `json:"webhooks"`
+	}
+	items := make([]webhookResponse, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		items = append(items, newWebhookResponse(webhook))
+	}
+	return c.JSON(http.StatusOK, response{
+		Webhooks: items,
+	})
+}
+
+type webhookIdInput struct {
+	Id int64 `param:"id"`
+}
+
+func (i *webhookIdInput) Validate() error {
+	var v httpapi.Validator
+	v.Id("id", i.Id)
+	return v.Err()
+}
+
+// @Summary Get webhook
+// @Tags Webhooks
+// @Accept json
+// @Produce json
+// @Success 200 {object} v1.webhookResponse
+// @Failure 400 {object} httpapi.LegacyError
+// @Failure 404 {object} httpapi.LegacyError
+// @Failure 500 {object} httpapi.LegacyError
+// @Router /api/v1/webhooks/{id} [get]
+func (r *webhookRoutes) get(c echo.Context) error {
+	var input webhookIdInput
+	if err := httpapi.Bind(c, &input); err != nil {
+		return err
+	}
+	webhook, err := r.webhookService.GetById(c.Request().Context(), input.Id)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, newWebhookResponse(webhook))
+}
+
+// @Summary Delete webhook
+// @Description Delete the webhook and its delivery log
+// @Tags Webhooks
+// @Accept json
+// @Produce json
+// @Success 204
+// @Failure 400 {object} httpapi.LegacyError
+// @Failure 404 {object} httpapi.LegacyError
+// @Failure 500 {object} httpapi.LegacyError
+// @Router /api/v1/webhooks/{id} [delete]
+func (r *webhookRoutes) delete(c echo.Context) error {
+	var input webhookIdInput
+	if err := httpapi.Bind(c, &input); err != nil {
+		return err
+	}
+	err := r.webhookService.Delete(c.Request().Context(), input.Id)
+	if err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+type webhookDeliveriesInput struct {
+	Id     int64                        `param:"id"`
+	Status entity.WebhookDeliveryStatus `query:"status"`
+	Cursor string                       `query:"cursor"`
+	Limit  int                          `query:"limit"`
+}
+
+func (i *webhookDeliveriesInput) Validate() error {
+	var v httpapi.Validator
+	v.Id("id", i.Id)
+	v.Check(i.Status == "" || i.Status.Valid(), "status", "is not a known delivery status")
+	v.Cursor("cursor", i.Cursor, true)
+	v.Limit("limit", i.Limit)
+	return v.Err()
+}
+
+// @Summary Get webhook deliveries
+// @Description Delivery log of the webhook ordered by id
+// @Tags Webhooks
+// @Accept json
+// @Produce json
+// @Success 200 {object} v1.webhookRoutes.deliveries.response
+// @Failure 400 {object} httpapi.LegacyError
+// @Failure 404 {object} httpapi.LegacyError
+// @Failure 500 {object} httpapi.LegacyError
+// @Router /api/v1/webhooks/{id}/deliveries [get]
+func (r *webhookRoutes) deliveries(c echo.Context) error {
+	var input webhookDeliveriesInput
+	if err := httpapi.Bind(c, &input); err != nil {
+		return err
+	}
+	var after int64
+	if input.Cursor != "" {
+		key, err := httpapi.DecodeCursor(input.Cursor)
+		if err == nil {
+			after, err = strconv.ParseInt(key, 10, 64)
+		}
+		if err != nil {
+			return httpapi.InvalidRequest("invalid cursor")
+		}
+	}
+	output, err := r.webhookService.GetDeliveries(c.Request().Context(), entity.WebhookDeliveryFilter{
+		WebhookId: input.Id,
+		Status:    input.Status,
+		After:     after,
+		Limit:     input.Limit,
+	})
+	if err != nil {
+		return err
+	}
+
+	type delivery struct {
+		Id             int64                        `json:"id"`
+		Event          json.RawMessage              `json:"event"`
+		Status         entity.WebhookDeliveryStatus `json:"status"`
+		Attempts       int                          `json:"attempts"`
+		ResponseStatus *int                         `json:"response_status,omitempty"`
+		LastError      string                       `json:"last_error,omitempty"`
+		CreatedAt      time.Time                    `json:"created_at"`
+		NextAttemptAt  *time.Time                   `json:"next_attempt_at,omitempty"`
+		DeliveredAt    *time.Time                   `json:"delivered_at,omitempty"`
+	}
+	type response struct {
+		Deliveries []delivery `json:"deliveries"`
+		NextCursor string     `json:"next_cursor,omitempty"`
+	}
+	deliveries := make([]delivery, 0, len(output.Deliveries))
+	for _, d := range output.Deliveries {
+		item := delivery{
+			Id:             d.Id,
+			Event:          json.RawMessage(d.Payload),
+			Status:         d.Status,
+			Attempts:       d.Attempts,
+			ResponseStatus: d.ResponseStatus,
+			LastError:      d.LastError,
+			CreatedAt:      d.CreatedAt,
+			DeliveredAt:    d.DeliveredAt,
+		}
+		if d.Status == entity.WEBHOOK_PENDING {
+			nextAttemptAt := d.NextAttemptAt
+			item.NextAttemptAt = &nextAttemptAt
+		}
+		deliveries = append(deliveries, item)
+	}
+	var next string
+	if output.Next > 0 {
+		next = httpapi.EncodeCursor(strconv.FormatInt(output.Next, 10))
+	}
+	return c.JSON(http.StatusOK, response{
+		Deliveries: deliveries,
+		NextCursor: next,
+	})
+}
@@ -0,0 +1,17 @@
+package v2
+
+import (
+	"github.com/ABDURAZZAKK/avito_experiment/internal/controller/http/httpapi"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/service"
+	"github.com/labstack/echo/v4"
+)
+
+// NewRouter adds the resource oriented /api/v2 routes. They run on the same
+// services, keys and limits as v1.
+func NewRouter(handler *echo.Echo, services *service.Services, limiters httpapi.RateLimiters) {
+	v2 := handler.Group("/api/v2", httpapi.Authenticate(services.ApiKey), httpapi.RateLimit(limiters), httpapi.Idempotency(services.Idempotency))
+	{
+		newUserRoutes(v2.Group("/users"), services.User)
+		newSegmentRoutes(v2.Group("/segments"), services.Segment)
+	}
+}
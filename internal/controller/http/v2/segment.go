@@ -0,0 +1,449 @@
+package v2
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/ABDURAZZAKK/avito_experiment/internal/controller/http/httpapi"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/service"
+	"github.com/labstack/echo/v4"
+)
+
+type segmentRoutes struct {
+	segmentService service.Segment
+}
+
+func newSegmentRoutes(g *echo.Group, segmentService service.Segment) {
+	r := &segmentRoutes{
+		segmentService: segmentService,
+	}
+	g.GET("", r.list, httpapi.RequireScope(entity.SCOPE_SEGMENTS_READ))
+	g.POST("", r.create, httpapi.RequireScope(entity.SCOPE_SEGMENTS_WRITE))
+	g.GET("/:slug", r.get, httpapi.RequireScope(entity.SCOPE_SEGMENTS_READ))
+	g.PATCH("/:slug", r.update, httpapi.RequireScope(entity.SCOPE_SEGMENTS_WRITE))
+	g.DELETE("/:slug", r.delete, httpapi.RequireScope(entity.SCOPE_SEGMENTS_WRITE))
+	g.GET("/:slug/members", r.members, httpapi.RequireScope(entity.SCOPE_SEGMENTS_READ))
+	g.PUT("/:slug/rollout", r.rollout, httpapi.RequireScope(entity.SCOPE_SEGMENTS_WRITE))
+}
+
+func segmentLocation(slug string) string {
+	return "/api/v2/segments/" + url.PathEscape(slug)
+}
+
+type segmentResponse struct {
+	Slug              string               `json:"slug"`
+	Description       string               `json:"description"`
+	Owner             string               `json:"owner"`
+	Team              string               `json:"team"`
+	Status            entity.SegmentStatus `json:"status"`
+	Tags              []string             `json:"tags"`
+	RolloutPercentage int                  `json:"rollout_percentage"`
+	RolloutExpiresAt  *time.Time           `json:"rollout_expires_at,omitempty"`
+	CreatedAt         time.Time            `json:"created_at"`
+	UpdatedAt         time.Time            `json:"updated_at"`
+	Members           *int                 `json:"members,omitempty"`
+}
+
+func newSegmentResponse(segment entity.Segment) segmentResponse {
+	return segmentResponse{
+		Slug:              segment.Slug,
+		Description:       segment.Description,
+		Owner:             segment.Owner,
+		Team:              segment.Team,
+		Status:            segment.Status,
+		Tags:              segment.Tags,
+		RolloutPercentage: segment.RolloutPercentage,
+		RolloutExpiresAt:  segment.RolloutExpiresAt,
+		CreatedAt:         segment.CreatedAt,
+		UpdatedAt:         segment.UpdatedAt,
+	}
+}
+
+const statusMessage = "must be draft, active, paused or archived"
+
+type listSegmentsInput struct {
+	Prefix     string               `query:"prefix"`
+	Status     entity.SegmentStatus `query:"status"`
+	Tag        string               `query:"tag"`
+	Team       string               `query:"team"`
+	Cursor     string               `query:"cursor"`
+	Limit      int                  `query:"limit"`
+	WithCounts bool                 `query:"with_counts"`
+}
+
+func (i *listSegmentsInput) Validate() error {
+	var v httpapi.Validator
+	v.Check(i.Status == "" || i.Status.Valid(), "status", statusMessage)
+	v.Cursor("cursor", i.Cursor, false)
+	v.Limit("limit", i.Limit)
+	return v.Err()
+}
+
+type segmentListResponse struct {
+	Segments   []segmentResponse `json:"segments"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
+
+// @Summary List segments
+// @Description List segments ordered by slug, optionally with member counts
+// @Tags segments v2
+// @Accept json
+// @Produce json
+// @Success 200 {object} v2.segmentListResponse
+// @Failure 400 {object} httpapi.Error
+// @Failure 500 {object} httpapi.Error
+// @Router /api/v2/segments [get]
+func (r *segmentRoutes) list(c echo.Context) error {
+	var input listSegmentsInput
+	if err := httpapi.Bind(c, &input); err != nil {
+		return err
+	}
+	after, err := httpapi.DecodeCursor(input.Cursor)
+	if err != nil {
+		return err
+	}
+	output, err := r.segmentService.List(c.Request().Context(), entity.SegmentFilter{
+		SlugPrefix: input.Prefix,
+		Status:     input.Status,
+		Tag:        input.Tag,
+		Team:       input.Team,
+		After:      after,
+		Limit:      input.Limit,
+	}, input.WithCounts)
+	if err != nil {
+		return err
+	}
+
+	segments := make([]segmentResponse, 0, len(output.Segments))
+	for _, segment := range output.Segments {
+		item := newSegmentResponse(segment)
+		if output.Members != nil {
+			members := output.Members[segment.Slug]
+			item.Members = &members
+		}
+		segments = append(segments, item)
+	}
+	return c.JSON(http.StatusOK, segmentListResponse{
+		Segments:   segments,
+		NextCursor: httpapi.EncodeCursor(output.Next),
+	})
+}
+
+type segmentCreateInput struct {
+	Slug              string               `json:"slug"`
+	Description       string               `json:"description,omitempty"`
+	Owner             string               `json:"owner,omitempty"`
+	Team              string               `json:"team"`
+	Status            entity.SegmentStatus `json:"status,omitempty"`
+	Tags              []string             `json:"tags,omitempty"`
+	PercentageOfUsers int                  `json:"percentage_of_users,omitempty"`
+	DeleteAt          string               `json:"delete_at,omitempty"`
+	Reason            string               `json:"reason,omitempty"`
+}
+
+func (i *segmentCreateInput) Validate() error {
+	var v httpapi.Validator
+	v.NewSegmentSlug("slug", i.Slug)
+	v.MaxLength("team", i.Team, httpapi.MAX_TEAM_LENGTH)
+	v.Check(i.Status == "" || i.Status.Valid(), "status", statusMessage)
+	for j, tag := range i.Tags {
+		v.Check(tag != "", fmt.Sprintf("tags[%d]", j), "must not be empty")
+	}
+	v.Percent("percentage_of_users", i.PercentageOfUsers, 0)
+	v.DeleteAt("delete_at", i.DeleteAt)
+	v.MaxLength("reason", i.Reason, httpapi.MAX_REASON_LENGTH)
+	return v.Err()
+}
+
+// @Summary Create segment
+// @Description Create a segment, optionally rolled out to a percentage of users
+// @Tags segments v2
+// @Accept json
+// @Produce json
+// @Success 201 {object} v2.segmentResponse
+// @Failure 400 {object} httpapi.Error
+// @Failure 403 {object} httpapi.Error
+// @Failure 409 {object} httpapi.Error
+// @Failure 500 {object} httpapi.Error
+// @Router /api/v2/segments [post]
+func (r *segmentRoutes) create(c echo.Context) error {
+	var input segmentCreateInput
+	if err := httpapi.Bind(c, &input); err != nil {
+		return err
+	}
+	expiresAt, err := httpapi.ParseDeleteAt(input.DeleteAt)
+	if err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	slug, err := r.segmentService.Create(ctx, service.SegmentCreateInput{
+		Slug:        input.Slug,
+		Description: input.Description,
+		Owner:       input.Owner,
+		Team:        input.Team,
+		Status:      input.Status,
+		Tags:        input.Tags,
+		Percent:     input.PercentageOfUsers,
+		ExpiresAt:   expiresAt,
+		Reason:      input.Reason,
+	})
+	if err != nil {
+		return err
+	}
+	segment, err := r.segmentService.GetBySlug(ctx, slug)
+	if err != nil {
+		return err
+	}
+	c.Response().Header().Set(echo.HeaderLocation, segmentLocation(slug))
+	return c.JSON(http.StatusCreated, newSegmentResponse(segment))
+}
+
+type segmentSlugInput struct {
+	Slug string `param:"slug"`
+}
+
+func (i *segmentSlugInput) Validate() error {
+	var v httpapi.Validator
+	v.SegmentSlug("slug", i.Slug)
+	return v.Err()
+}
+
+// @Summary Get segment
+// @Tags segments v2
+// @Accept json
+// @Produce json
+// @Success 200 {object} v2.segmentResponse
+// @Failure 400 {object} httpapi.Error
+// @Failure 404 {object} httpapi.Error
+// @Failure 500 {object} httpapi.Error
+// @Router /api/v2/segments/{slug} [get]
+func (r *segmentRoutes) get(c echo.Context) error {
+	var input segmentSlugInput
+	if err := httpapi.Bind(c, &input); err != nil {
+		return err
+	}
+	segment, err := r.segmentService.GetBySlug(c.Request().Context(), input.Slug)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, newSegmentResponse(segment))
+}
+
+type updateSegmentInput struct {
+	Slug        string                `param:"slug"`
+	Description *string               `json:"description"`
+	Owner       *string               `json:"owner"`
+	Team        *string               `json:"team"`
+	Status      *entity.SegmentStatus `json:"status"`
+	Tags        *[]string             `json:"tags"`
+}
+
+func (i *updateSegmentInput) Validate() error {
+	var v httpapi.Validator
+	v.SegmentSlug("slug", i.Slug)
+	if i.Team != nil {
+		v.MaxLength("team", *i.Team, httpapi.MAX_TEAM_LENGTH)
+	}
+	v.Check(i.Status == nil || i.Status.Valid(), "status", statusMessage)
+	if i.Tags != nil {
+		for j, tag := range *i.Tags {
+			v.Check(tag != "", fmt.Sprintf("tags[%d]", j), "must not be empty")
+		}
+	}
+	return v.Err()
+}
+
+// @Summary Update segment
+// @Description Update segment metadata and lifecycle status. Fields left out are kept
+// @Tags segments v2
+// @Accept json
+// @Produce json
+// @Success 200 {object} v2.segmentResponse
+// @Failure 400 {object} httpapi.Error
+// @Failure 403 {object} httpapi.Error
+// @Failure 404 {object} httpapi.Error
+// @Failure 500 {object} httpapi.Error
+// @Router /api/v2/segments/{slug} [patch]
+func (r *segmentRoutes) update(c echo.Context) error {
+	var input updateSegmentInput
+	if err := httpapi.Bind(c, &input); err != nil {
+		return err
+	}
+	segment, err := r.segmentService.Update(c.Request().Context(), input.Slug, service.SegmentUpdateInput{
+		Description: input.Description,
+		Owner:       input.Owner,
+		Team:        input.Team,
+		Status:      input.Status,
+		Tags:        input.Tags,
+	})
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, newSegmentResponse(segment))
+}
+
+// @Summary Delete segment
+// @Tags segments v2
+// @Accept json
+// @Produce json
+// @Success 204
+// @Failure 400 {object} httpapi.Error
+// @Failure 403 {object} httpapi.Error
+// @Failure 404 {object} httpapi.Error
+// @Failure 500 {object} httpapi.Error
+// @Router /api/v2/segments/{slug} [delete]
+func (r *segmentRoutes) delete(c echo.Context) error {
+	var input segmentSlugInput
+	if err := httpapi.Bind(c, &input); err != nil {
+		return err
+	}
+	if _, err := r.segmentService.Delete(c.Request().Context(), input.Slug); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+type segmentMembersInput struct {
+	Slug          string    `param:"slug"`
+	Cursor        string    `query:"cursor"`
+	Limit         int       `query:"limit"`
+	AssignedFrom  time.Time `query:"assigned_from"`
+	AssignedTo    time.Time `query:"assigned_to"`
+	ExpiresAfter  time.Time `query:"expires_after"`
+	ExpiresBefore time.Time `query:"expires_before"`
+	HasExpiry     *bool     `query:"has_expiry"`
+}
+
+func (i *segmentMembersInput) Validate() error {
+	var v httpapi.Validator
+	v.SegmentSlug("slug", i.Slug)
+	v.Cursor("cursor", i.Cursor, true)
+	v.Limit("limit", i.Limit)
+	return v.Err()
+}
+
+type memberResponse struct {
+	Id         int        `json:"id"`
+	AssignedAt time.Time  `json:"assigned_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+}
+
+type segmentMembersResponse struct {
+	Members    []memberResponse `json:"members"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+// @Summary Get segment members
+// @Description List users of the segment ordered by id
+// @Tags segments v2
+// @Accept json
+// @Produce json
+// @Success 200 {object} v2.segmentMembersResponse
+// @Failure 400 {object} httpapi.Error
+// @Failure 403 {object} httpapi.Error
+// @Failure 404 {object} httpapi.Error
+// @Failure 500 {object} httpapi.Error
+// @Router /api/v2/segments/{slug}/members [get]
+func (r *segmentRoutes) members(c echo.Context) error {
+	var input segmentMembersInput
+	if err := httpapi.Bind(c, &input); err != nil {
+		return err
+	}
+	var after int
+	if input.Cursor != "" {
+		key, err := httpapi.DecodeCursor(input.Cursor)
+		if err == nil {
+			after, err = strconv.Atoi(key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	output, err := r.segmentService.GetMembers(c.Request().Context(), entity.MembersFilter{
+		Segment:       input.Slug,
+		After:         after,
+		Limit:         input.Limit,
+		AssignedFrom:  input.AssignedFrom,
+		AssignedTo:    input.AssignedTo,
+		ExpiresAfter:  input.ExpiresAfter,
+		ExpiresBefore: input.ExpiresBefore,
+		HasExpiry:     input.HasExpiry,
+	})
+	if err != nil {
+		return err
+	}
+
+	members := make([]memberResponse, 0, len(output.Members))
+	for _, m := range output.Members {
+		members = append(members, memberResponse{
+			Id:         m.User,
+			AssignedAt: m.AssignedAt,
+			ExpiresAt:  m.ExpiresAt,
+		})
+	}
+	var next string
+	if output.Next > 0 {
+		next = httpapi.EncodeCursor(strconv.Itoa(output.Next))
+	}
+	return c.JSON(http.StatusOK, segmentMembersResponse{
+		Members:    members,
+		NextCursor: next,
+	})
+}
+
+type segmentRolloutInput struct {
+	Slug              string `param:"slug"`
+	PercentageOfUsers int    `json:"percentage_of_users"`
+	DeleteAt          string `json:"delete_at,omitempty"`
+	Reason            string `json:"reason,omitempty"`
+}
+
+func (i *segmentRolloutInput) Validate() error {
+	var v httpapi.Validator
+	v.SegmentSlug("slug", i.Slug)
+	v.Percent("percentage_of_users", i.PercentageOfUsers, 1)
+	v.DeleteAt("delete_at", i.DeleteAt)
+	v.MaxLength("reason", i.Reason, httpapi.MAX_REASON_LENGTH)
+	return v.Err()
+}
+
+type segmentRolloutResponse struct {
+	Slug  string `json:"slug"`
+	Added int    `json:"added"`
+}
+
+// @Summary Roll out segment
+// @Description Extend the segment to a stable percentage of users. Repeating it adds no one,
+// @Description raising the percentage only adds new users. Only active segments are rolled out, others get 409
+// @Tags segments v2
+// @Accept json
+// @Produce json
+// @Success 200 {object} v2.segmentRolloutResponse
+// @Failure 400 {object} httpapi.Error
+// @Failure 403 {object} httpapi.Error
+// @Failure 404 {object} httpapi.Error
+// @Failure 409 {object} httpapi.Error
+// @Failure 500 {object} httpapi.Error
+// @Router /api/v2/segments/{slug}/rollout [put]
+func (r *segmentRoutes) rollout(c echo.Context) error {
+	var input segmentRolloutInput
+	if err := httpapi.Bind(c, &input); err != nil {
+		return err
+	}
+	expiresAt, err := httpapi.ParseDeleteAt(input.DeleteAt)
+	if err != nil {
+		return err
+	}
+	added, err := r.segmentService.Rollout(c.Request().Context(), input.Slug, input.PercentageOfUsers, expiresAt, input.Reason)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, segmentRolloutResponse{
+		Slug:  input.Slug,
+		Added: added,
+	})
+}
@@ -0,0 +1,373 @@
+package v2
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ABDURAZZAKK/avito_experiment/internal/controller/http/httpapi"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/service"
+	"github.com/labstack/echo/v4"
+)
+
+type userRoutes struct {
+	userService service.User
+}
+
+func newUserRoutes(g *echo.Group, userService service.User) {
+	r := &userRoutes{
+		userService: userService,
+	}
+	g.POST("", r.create, httpapi.RequireScope(entity.SCOPE_USERS_WRITE))
+	g.GET("/:id", r.get, httpapi.RequireScope(entity.SCOPE_USERS_READ))
+	g.DELETE("/:id", r.delete, httpapi.RequireScope(entity.SCOPE_USERS_WRITE))
+	g.GET("/:id/segments", r.getSegments, httpapi.RequireScope(entity.SCOPE_USERS_READ))
+	g.PUT("/:id/segments", r.putSegments, httpapi.RequireScope(entity.SCOPE_USERS_WRITE))
+	g.PATCH("/:id/segments", r.patchSegments, httpapi.RequireScope(entity.SCOPE_USERS_WRITE))
+}
+
+type userResponse struct {
+	Id   int    `json:"id"`
+	Slug string `json:"slug"`
+}
+
+type userSegmentsResponse struct {
+	Segments []string `json:"segments"`
+}
+
+func userLocation(id int) string {
+	return "/api/v2/users/" + strconv.Itoa(id)
+}
+
+type userCreateInput struct {
+	Slug string `json:"slug"`
+}
+
+func (i *userCreateInput) Validate() error {
+	var v httpapi.Validator
+	v.Check(i.Slug != "", "slug", "is required")
+	v.MaxLength("slug", i.Slug, httpapi.MAX_USER_SLUG_LENGTH)
+	return v.Err()
+}
+
+// @Summary Create user
+// @Tags users v2
+// @Accept json
+// @Produce json
+// @Success 201 {object} v2.userResponse
+// @Failure 400 {object} httpapi.Error
+// @Failure 409 {object} httpapi.Error
+// @Failure 500 {object} httpapi.Error
+// @Router /api/v2/users [post]
+func (r *userRoutes) create(c echo.Context) error {
+	var input userCreateInput
+	if err := httpapi.Bind(c, &input); err != nil {
+		return err
+	}
+	id, err := r.userService.Create(c.Request().Context(), input.Slug)
+	if err != nil {
+		return err
+	}
+	c.Response().Header().Set(echo.HeaderLocation, userLocation(id))
+	return c.JSON(http.StatusCreated, userResponse{
+		Id:   id,
+		Slug: input.Slug,
+	})
+}
+
+type userIdInput struct {
+	Id int `param:"id"`
+}
+
+func (i *userIdInput) Validate() error {
+	var v httpapi.Validator
+	v.Id("id", int64(i.Id))
+	return v.Err()
+}
+
+// @Summary Get user
+// @Tags users v2
+// @Accept json
+// @Produce json
+// @Success 200 {object} v2.userResponse
+// @Failure 400 {object} httpapi.Error
+// @Failure 404 {object} httpapi.Error
+// @Failure 500 {object} httpapi.Error
+// @Router /api/v2/users/{id} [get]
+func (r *userRoutes) get(c echo.Context) error {
+	var input userIdInput
+	if err := httpapi.Bind(c, &input); err != nil {
+		return err
+	}
+	user, err := r.userService.GetById(c.Request().Context(), input.Id)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, userResponse{
+		Id:   user.Id,
+		Slug: user.Slug,
+	})
+}
+
+type deleteUserInput struct {
+	Id     int    `param:"id"`
+	Reason string `query:"reason"`
+}
+
+func (i *deleteUserInput) Validate() error {
+	var v httpapi.Validator
+	v.Id("id", int64(i.Id))
+	v.MaxLength("reason", i.Reason, httpapi.MAX_REASON_LENGTH)
+	return v.Err()
+}
+
+// @Summary Delete user
+// @Description Delete the user and its memberships. The reason is recorded with the removals
+// @Tags users v2
+// @Accept json
+// @Produce json
+// @Success 204
+// @Failure 400 {object} httpapi.Error
+// @Failure 403 {object} httpapi.Error
+// @Failure 404 {object} httpapi.Error
+// @Failure 500 {object} httpapi.Error
+// @Router /api/v2/users/{id} [delete]
+func (r *userRoutes) delete(c echo.Context) error {
+	var input deleteUserInput
+	if err := httpapi.Bind(c, &input); err != nil {
+		return err
+	}
+	if _, err := r.userService.Delete(c.Request().Context(), input.Id, input.Reason); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// @Summary Get user segments
+// @Description Get the user's segments. The ETag is the version of the set; send it
+// @Description back in If-None-Match to get 304 while nothing changed
+// @Tags users v2
+// @Accept json
+// @Produce json
+// @Success 200 {object} v2.userSegmentsResponse
+// @Success 304
+// @Failure 400 {object} httpapi.Error
+// @Failure 404 {object} httpapi.Error
+// @Failure 500 {object} httpapi.Error
+// @Router /api/v2/users/{id}/segments [get]
+func (r *userRoutes) getSegments(c echo.Context) error {
+	var input userIdInput
+	if err := httpapi.Bind(c, &input); err != nil {
+		return err
+	}
+	output, err := r.userService.GetSegments(c.Request().Context(), input.Id)
+	if err != nil {
+		return err
+	}
+
+	c.Response().Header().Set(httpapi.HEADER_ETAG, httpapi.FormatETag(output.Version))
+	if ifNoneMatch := c.Request().Header.Get(httpapi.HEADER_IF_NONE_MATCH); ifNoneMatch != "" && httpapi.ETagMatches(ifNoneMatch, output.Version) {
+		return c.NoContent(http.StatusNotModified)
+	}
+	return c.JSON(http.StatusOK, userSegmentsResponse{
+		Segments: nonNil(output.Segments),
+	})
+}
+
+type putUserSegmentsInput struct {
+	Id       int      `param:"id"`
+	Segments []string `json:"segments"`
+	DeleteAt string   `json:"delete_at,omitempty"`
+	Reason   string   `json:"reason,omitempty"`
+}
+
+func (i *putUserSegmentsInput) Validate() error {
+	var v httpapi.Validator
+	v.Id("id", int64(i.Id))
+	v.Check(i.Segments != nil, "segments", "is required, send [] to remove every segment")
+	v.SegmentSlugs("segments", i.Segments, v.SegmentSlug)
+	v.DeleteAt("delete_at", i.DeleteAt)
+	v.MaxLength("reason", i.Reason, httpapi.MAX_REASON_LENGTH)
+	return v.Err()
+}
+
+// @Summary Replace user segments
+// @Description Make segments the user's segment set. Only the difference to all memberships, paused and
+// @Description archived segments included, is added and removed, and only while the set is still the one
+// @Description it was compared with, otherwise 412.
+// @Description delete_at becomes the expiry of every segment of the set, held ones included; without it
+// @Description held segments keep their expiry.
+// @Description With If-Match the set also has to be at that ETag
+// @Tags users v2
+// @Accept json
+// @Produce json
+// @Success 200 {object} v2.userSegmentsResponse
+// @Failure 400 {object} httpapi.Error
+// @Failure 404 {object} httpapi.Error
+// @Failure 412 {object} httpapi.Error
+// @Failure 500 {object} httpapi.Error
+// @Router /api/v2/users/{id}/segments [put]
+func (r *userRoutes) putSegments(c echo.Context) error {
+	var input putUserSegmentsInput
+	if err := httpapi.Bind(c, &input); err != nil {
+		return err
+	}
+	ifVersion, err := ifMatchVersion(c)
+	if err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	current, err := r.userService.GetAllSegments(ctx, input.Id)
+	if err != nil {
+		return err
+	}
+	if ifVersion != nil && *ifVersion != current.Version {
+		return service.ErrSegmentsVersionMismatch
+	}
+
+	expiresAt, err := httpapi.ParseDeleteAt(input.DeleteAt)
+	if err != nil {
+		return err
+	}
+	add, remove, keep := difference(current.Segments, input.Segments)
+	var expire []string
+	if expiresAt != nil {
+		expire = keep
+	}
+	if len(add) == 0 && len(remove) == 0 && len(expire) == 0 {
+		output, err := r.userService.GetSegments(ctx, input.Id)
+		if err != nil {
+			return err
+		}
+		return writeSegments(c, output)
+	}
+	output, err := r.userService.ChangeSegments(ctx, input.Id, service.UserChangeSegmentsInput{
+		AddList:    add,
+		RemoveList: remove,
+		ExpireList: expire,
+		ExpiresAt:  expiresAt,
+		IfVersion:  &current.Version,
+		Reason:     input.Reason,
+	})
+	if err != nil {
+		return err
+	}
+	return writeSegments(c, output)
+}
+
+type patchUserSegmentsInput struct {
+	Id       int      `param:"id"`
+	Add      []string `json:"add"`
+	Remove   []string `json:"remove"`
+	DeleteAt string   `json:"delete_at,omitempty"`
+	Reason   string   `json:"reason,omitempty"`
+}
+
+func (i *patchUserSegmentsInput) Validate() error {
+	var v httpapi.Validator
+	v.Id("id", int64(i.Id))
+	v.Check(len(i.Add) != 0 || len(i.Remove) != 0, "add", "add or remove must not be empty")
+	added := v.SegmentSlugs("add", i.Add, v.SegmentSlug)
+	for j, slug := range i.Remove {
+		v.Check(!added[slug], fmt.Sprintf("remove[%d]", j), fmt.Sprintf("%q is also in add", slug))
+	}
+	v.SegmentSlugs("remove", i.Remove, v.SegmentSlug)
+	v.DeleteAt("delete_at", i.DeleteAt)
+	v.MaxLength("reason", i.Reason, httpapi.MAX_REASON_LENGTH)
+	return v.Err()
+}
+
+// @Summary Change user segments
+// @Description Add and remove some of the user's segments. With If-Match the change only
+// @Description applies while the segments are still at that ETag, otherwise 412
+// @Tags users v2
+// @Accept json
+// @Produce json
+// @Success 200 {object} v2.userSegmentsResponse
+// @Failure 400 {object} httpapi.Error
+// @Failure 404 {object} httpapi.Error
+// @Failure 409 {object} httpapi.Error
+// @Failure 412 {object} httpapi.Error
+// @Failure 500 {object} httpapi.Error
+// @Router /api/v2/users/{id}/segments [patch]
+func (r *userRoutes) patchSegments(c echo.Context) error {
+	var input patchUserSegmentsInput
+	if err := httpapi.Bind(c, &input); err != nil {
+		return err
+	}
+	ifVersion, err := ifMatchVersion(c)
+	if err != nil {
+		return err
+	}
+	expiresAt, err := httpapi.ParseDeleteAt(input.DeleteAt)
+	if err != nil {
+		return err
+	}
+	output, err := r.userService.ChangeSegments(c.Request().Context(), input.Id, service.UserChangeSegmentsInput{
+		AddList:    input.Add,
+		RemoveList: input.Remove,
+		ExpiresAt:  expiresAt,
+		IfVersion:  ifVersion,
+		Reason:     input.Reason,
+	})
+	if err != nil {
+		return err
+	}
+	return writeSegments(c, output)
+}
+
+// writeSegments answers a change with the user's segments and their new ETag.
+func writeSegments(c echo.Context, output service.UserSegmentsOutput) error {
+	c.Response().Header().Set(httpapi.HEADER_ETAG, httpapi.FormatETag(output.Version))
+	return c.JSON(http.StatusOK, userSegmentsResponse{
+		Segments: nonNil(output.Segments),
+	})
+}
+
+// ifMatchVersion reads the version a change is conditional on from If-Match.
+// It is nil without the header or with "*".
+func ifMatchVersion(c echo.Context) (*int64, error) {
+	ifMatch := strings.TrimSpace(c.Request().Header.Get(httpapi.HEADER_IF_MATCH))
+	if ifMatch == "" || ifMatch == "*" {
+		return nil, nil
+	}
+	version, ok := httpapi.ParseETag(ifMatch)
+	if !ok {
+		return nil, httpapi.NewError(http.StatusPreconditionFailed, httpapi.CODE_PRECONDITION, "invalid If-Match")
+	}
+	return &version, nil
+}
+
+// difference returns the segments of want missing from have, those of have
+// missing from want and those in both.
+func difference(have, want []string) (add, remove, keep []string) {
+	inHave := make(map[string]bool, len(have))
+	for _, slug := range have {
+		inHave[slug] = true
+	}
+	inWant := make(map[string]bool, len(want))
+	for _, slug := range want {
+		inWant[slug] = true
+		if inHave[slug] {
+			keep = append(keep, slug)
+		} else {
+			add = append(add, slug)
+		}
+	}
+	for _, slug := range have {
+		if !inWant[slug] {
+			remove = append(remove, slug)
+		}
+	}
+	return add, remove, keep
+}
+
+// nonNil keeps an empty list from being written as null.
+func nonNil(slugs []string) []string {
+	if slugs == nil {
+		return []string{}
+	}
+	return slugs
+}
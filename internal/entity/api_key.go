@@ -0,0 +1,108 @@
+package entity
+
+import "time"
+
+// Scope is a permission granted to an API key.
+type Scope string
+
+const (
+	SCOPE_SEGMENTS_READ  Scope = "segments:read"
+	SCOPE_SEGMENTS_WRITE Scope = "segments:write"
+	SCOPE_USERS_READ     Scope = "users:read"
+	SCOPE_USERS_WRITE    Scope = "users:write"
+	SCOPE_STATS_READ     Scope = "stats:read"
+	SCOPE_STATS_EXPORT   Scope = "stats:export"
+	SCOPE_WEBHOOKS       Scope = "webhooks:manage"
+	SCOPE_KEYS           Scope = "keys:manage"
+)
+
+var SCOPES = []Scope{
+	SCOPE_SEGMENTS_READ,
+	SCOPE_SEGMENTS_WRITE,
+	SCOPE_USERS_READ,
+	SCOPE_USERS_WRITE,
+	SCOPE_STATS_READ,
+	SCOPE_STATS_EXPORT,
+	SCOPE_WEBHOOKS,
+	SCOPE_KEYS,
+}
+
+func (s Scope) Valid() bool {
+	for _, scope := range SCOPES {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Role is what a key may do with the segments of a team. Each role includes
+// the ones before it: viewers read members, editors also change segments and
+// their members, admins also delete segments and move them between teams.
+type Role string
+
+const (
+	ROLE_VIEWER Role = "viewer"
+	ROLE_EDITOR Role = "editor"
+	ROLE_ADMIN  Role = "admin"
+)
+
+// ALL_TEAMS grants a role in every team, including segments of no team.
+const ALL_TEAMS = "*"
+
+func (r Role) rank() int {
+	switch r {
+	case ROLE_VIEWER:
+		return 1
+	case ROLE_EDITOR:
+		return 2
+	case ROLE_ADMIN:
+		return 3
+	}
+	return 0
+}
+
+func (r Role) Valid() bool {
+	return r.rank() > 0
+}
+
+// Allows tells whether r includes role.
+func (r Role) Allows(role Role) bool {
+	return r.Valid() && r.rank() >= role.rank()
+}
+
+// ApiKey authenticates a client of the API. Only the hash of the key is kept;
+// Prefix is the start of the key, to tell keys apart.
+type ApiKey struct {
+	Id     int64   `db:"id"`
+	Name   string  `db:"name"`
+	Prefix string  `db:"prefix"`
+	Hash   string  `db:"hash"`
+	Scopes []Scope `db:"scopes"`
+	// Roles maps teams to the role of the key in them.
+	Roles      map[string]Role `db:"-"`
+	CreatedAt  time.Time       `db:"created_at"`
+	LastUsedAt *time.Time      `db:"last_used_at"`
+	RevokedAt  *time.Time      `db:"revoked_at"`
+}
+
+func (k ApiKey) HasScope(scope Scope) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// RoleIn returns the role of the key in team, the stronger of its role in
+// the team and in ALL_TEAMS. It is empty when the key has neither.
+func (k ApiKey) RoleIn(team string) Role {
+	role := k.Roles[ALL_TEAMS]
+	if team != ALL_TEAMS {
+		if r := k.Roles[team]; r.rank() > role.rank() {
+			role = r
+		}
+	}
+	return role
+}
@@ -0,0 +1,49 @@
+package entity
+
+import "time"
+
+type AuditOutcome string
+
+const (
+	AUDIT_DENIED AuditOutcome = "denied"
+)
+
+// Actions recorded in the audit log.
+const (
+	ACTION_SEGMENT_CREATE       = "segment.create"
+	ACTION_SEGMENT_UPDATE       = "segment.update"
+	ACTION_SEGMENT_ROLLOUT      = "segment.rollout"
+	ACTION_SEGMENT_DELETE       = "segment.delete"
+	ACTION_SEGMENT_MEMBERS      = "segment.members"
+	ACTION_USER_CHANGE_SEGMENTS = "user.change_segments"
+	ACTION_USER_DELETE          = "user.delete"
+	ACTION_EXPORT_READ          = "export.read"
+	ACTION_AUDIT_READ           = "audit.read"
+	ACTION_KEY_ISSUE            = "key.issue"
+	ACTION_KEY_SET_ROLE         = "key.set_role"
+	ACTION_KEY_DELETE_ROLE      = "key.delete_role"
+	ACTION_WEBHOOK_CREATE       = "webhook.create"
+)
+
+// AuditEntry records an attempt of Actor to make Action on Resource, a segment
+// or an API key, in Team, and its Outcome.
+type AuditEntry struct {
+	Id        int64        `db:"id"`
+	CreatedAt time.Time    `db:"created_at"`
+	Actor     string       `db:"actor"`
+	Action    string       `db:"action"`
+	Resource  string       `db:"resource"`
+	Team      string       `db:"team"`
+	Outcome   AuditOutcome `db:"outcome"`
+	Reason    string       `db:"reason"`
+}
+
+// AuditFilter narrows the audit log. Entries are ordered by id and After is
+// the last id of the previous page.
+type AuditFilter struct {
+	Actor  string
+	Action string
+	Team   string
+	After  int64
+	Limit  int
+}
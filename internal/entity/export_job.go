@@ -0,0 +1,30 @@
+package entity
+
+import "time"
+
+type ExportStatus string
+
+const (
+	EXPORT_QUEUED    ExportStatus = "queued"
+	EXPORT_RUNNING   ExportStatus = "running"
+	EXPORT_SUCCEEDED ExportStatus = "succeeded"
+	EXPORT_FAILED    ExportStatus = "failed"
+)
+
+// ExportJob tracks one CSV export of the stats history for a month.
+// Filename is set only once the file has been written.
+type ExportJob struct {
+	Id       int64        `db:"id"`
+	Status   ExportStatus `db:"status"`
+	Year     int          `db:"year"`
+	Month    int          `db:"month"`
+	Timezone string       `db:"timezone"`
+	// RequestedBy is the actor that started the export.
+	RequestedBy string     `db:"requested_by"`
+	Filename    string     `db:"filename"`
+	RowCount    int        `db:"row_count"`
+	Error       string     `db:"error"`
+	CreatedAt   time.Time  `db:"created_at"`
+	StartedAt   *time.Time `db:"started_at"`
+	FinishedAt  *time.Time `db:"finished_at"`
+}
@@ -0,0 +1,19 @@
+package entity
+
+import "time"
+
+// IdempotencyKey is a mutating request identified by the client's
+// Idempotency-Key header, with the response to replay for its repeats.
+// Keys are scoped to the API key that sent them. ResponseStatus is nil while
+// the first request is still running.
+type IdempotencyKey struct {
+	ApiKeyId            int64             `db:"api_key_id"`
+	Key                 string            `db:"key"`
+	RequestHash         string            `db:"request_hash"`
+	ResponseStatus      *int              `db:"response_status"`
+	ResponseContentType string            `db:"response_content_type"`
+	ResponseHeaders     map[string]string `db:"response_headers"`
+	ResponseBody        []byte            `db:"response_body"`
+	CreatedAt           time.Time         `db:"created_at"`
+	StartedAt           time.Time         `db:"started_at"`
+}
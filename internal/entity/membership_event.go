@@ -0,0 +1,57 @@
+package entity
+
+import "time"
+
+// Source tells what made a membership change.
+type Source string
+
+const (
+	SOURCE_MANUAL  Source = "manual"
+	SOURCE_TTL     Source = "ttl"
+	SOURCE_ROLLOUT Source = "rollout"
+	// SOURCE_IMPORT marks bulk loads made outside the API.
+	SOURCE_IMPORT       Source = "import"
+	SOURCE_USER_DELETED Source = "user_deleted"
+)
+
+func (s Source) Valid() bool {
+	switch s {
+	case SOURCE_MANUAL, SOURCE_TTL, SOURCE_ROLLOUT, SOURCE_IMPORT, SOURCE_USER_DELETED:
+		return true
+	}
+	return false
+}
+
+// Change says who made a membership change (an API key or a service), what
+// made it and, optionally, why.
+type Change struct {
+	Actor  string
+	Source Source
+	Reason string
+}
+
+const (
+	HEADER_EVENT_TYPE    = "x-event-type"
+	HEADER_EVENT_VERSION = "x-event-version"
+
+	EVENT_MEMBERSHIP         = "membership"
+	EVENT_MEMBERSHIP_VERSION = 1
+)
+
+// MembershipEvent is published to the events exchange whenever a user enters or
+// leaves a segment.
+type MembershipEvent struct {
+	User       int       `json:"user"`
+	Segment    string    `json:"segment"`
+	Operation  Operation `json:"operation"`
+	Actor      string    `json:"actor"`
+	Source     Source    `json:"source"`
+	Reason     string    `json:"reason"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// RoutingKey is "<segment>.<operation>", so subscribers can bind to a single
+// segment ("AVITO_VOICE_MESSAGES.*") or to one kind of change ("*.segment_removed").
+func (e MembershipEvent) RoutingKey() string {
+	return e.Segment + "." + string(e.Operation)
+}
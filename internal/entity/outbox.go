@@ -0,0 +1,15 @@
+package entity
+
+import "time"
+
+// OutboxMessage is a broker message stored in the same transaction as the change
+// that produced it. The relay publishes it and deletes it afterwards. Messages
+// without a RoutingKey are tasks, the others are events.
+type OutboxMessage struct {
+	Id         int64             `db:"id"`
+	RoutingKey string            `db:"routing_key"`
+	Headers    map[string]string `db:"headers"`
+	Payload    []byte            `db:"payload"`
+	Attempts   int               `db:"attempts"`
+	CreatedAt  time.Time         `db:"created_at"`
+}
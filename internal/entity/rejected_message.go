@@ -0,0 +1,13 @@
+package entity
+
+import "time"
+
+// RejectedMessage is a broker message the consumer could not decode. It is kept
+// for inspection instead of being handled.
+type RejectedMessage struct {
+	Id        int64             `db:"id"`
+	Headers   map[string]string `db:"headers"`
+	Body      []byte            `db:"body"`
+	Reason    string            `db:"reason"`
+	CreatedAt time.Time         `db:"created_at"`
+}
@@ -1,5 +1,49 @@
 package entity
 
+import "time"
+
+// Users are spread over BUCKETS buckets per segment, so a rollout
+// percentage maps to the first percent*BUCKETS/100 of them.
+const BUCKETS = 10000
+
+type SegmentStatus string
+
+const (
+	SEGMENT_DRAFT    SegmentStatus = "draft"
+	SEGMENT_ACTIVE   SegmentStatus = "active"
+	SEGMENT_PAUSED   SegmentStatus = "paused"
+	SEGMENT_ARCHIVED SegmentStatus = "archived"
+)
+
+func (s SegmentStatus) Valid() bool {
+	switch s {
+	case SEGMENT_DRAFT, SEGMENT_ACTIVE, SEGMENT_PAUSED, SEGMENT_ARCHIVED:
+		return true
+	}
+	return false
+}
+
 type Segment struct {
-	Slug string `db:"slug"`
+	Slug              string        `db:"slug"`
+	Salt              string        `db:"salt"`
+	Description       string        `db:"description"`
+	Owner             string        `db:"owner"`
+	Team              string        `db:"team"`
+	Status            SegmentStatus `db:"status"`
+	Tags              []string      `db:"tags"`
+	RolloutPercentage int           `db:"rollout_percentage"`
+	RolloutExpiresAt  *time.Time    `db:"rollout_expires_at"`
+	CreatedAt         time.Time     `db:"created_at"`
+	UpdatedAt         time.Time     `db:"updated_at"`
+}
+
+// SegmentFilter narrows a segment listing. Segments are ordered by slug and
+// After is the last slug of the previous page.
+type SegmentFilter struct {
+	SlugPrefix string
+	Status     SegmentStatus
+	Tag        string
+	Team       string
+	After      string
+	Limit      int
 }
@@ -3,4 +3,6 @@ package entity
 type User struct {
 	Id   int    `db:"id"`
 	Slug string `db:"slug"`
+	// SegmentsVersion changes whenever the segments the user sees change.
+	SegmentsVersion int64 `db:"segments_version"`
 }
@@ -9,15 +9,59 @@ const (
 	SEGMENT_REMOVED Operation = "segment_removed"
 )
 
+// UserSegments is the set of segments a user sees and its version.
+type UserSegments struct {
+	Segments []string
+	Version  int64
+}
+
 // таблица  many to many
 type UsersSegments struct {
-	User    int    `db:"user"`
-	Segment string `db:"segment"`
+	User       int        `db:"user"`
+	Segment    string     `db:"segment"`
+	AssignedAt time.Time  `db:"assigned_at"`
+	ExpiresAt  *time.Time `db:"expires_at"`
+}
+
+// MembersFilter narrows the members of a segment. Members are ordered by user
+// and After is the last user of the previous page. Zero times are ignored.
+type MembersFilter struct {
+	Segment       string
+	After         int
+	Limit         int
+	AssignedFrom  time.Time
+	AssignedTo    time.Time
+	ExpiresAfter  time.Time
+	ExpiresBefore time.Time
+	// HasExpiry keeps only members with (true) or without (false) an expiry.
+	HasExpiry *bool
 }
 
 type UsersSegmentsStats struct {
+	Id         int64     `db:"id"`
 	User       int       `db:"user"`
 	Segment    string    `db:"segment"`
 	Created_at time.Time `db:"creaed_at"`
 	Operation  Operation `db:"operation"`
+	Actor      string    `db:"actor"`
+	Source     Source    `db:"source"`
+	Reason     string    `db:"reason"`
+}
+
+// StatsFilter narrows the stats history to [From, To). Rows are ordered by id
+// and After is the last id of the previous page. Zero values are ignored,
+// a zero Limit returns every matching row.
+type StatsFilter struct {
+	From      time.Time
+	To        time.Time
+	User      int
+	Segment   string
+	Operation Operation
+	Actor     string
+	Source    Source
+	// Teams limits the stats to the segments of these teams, nil means every
+	// team. Stats of deleted segments belong to no team.
+	Teams []string
+	After int64
+	Limit int
 }
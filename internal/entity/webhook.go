@@ -0,0 +1,55 @@
+package entity
+
+import "time"
+
+type WebhookDeliveryStatus string
+
+const (
+	WEBHOOK_PENDING   WebhookDeliveryStatus = "pending"
+	WEBHOOK_SUCCEEDED WebhookDeliveryStatus = "succeeded"
+	WEBHOOK_FAILED    WebhookDeliveryStatus = "failed"
+)
+
+func (s WebhookDeliveryStatus) Valid() bool {
+	switch s {
+	case WEBHOOK_PENDING, WEBHOOK_SUCCEEDED, WEBHOOK_FAILED:
+		return true
+	}
+	return false
+}
+
+// Webhook receives the membership events of its segments, or of every segment
+// when Segments is empty. Secret signs the deliveries.
+type Webhook struct {
+	Id        int64     `db:"id"`
+	URL       string    `db:"url"`
+	Secret    string    `db:"secret"`
+	Segments  []string  `db:"segments"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// WebhookDelivery is one event on its way to a webhook, and the log of how
+// sending it went. URL and Secret are the webhook's.
+type WebhookDelivery struct {
+	Id             int64                 `db:"id"`
+	WebhookId      int64                 `db:"webhook_id"`
+	URL            string                `db:"url"`
+	Secret         string                `db:"secret"`
+	Payload        []byte                `db:"payload"`
+	Status         WebhookDeliveryStatus `db:"status"`
+	Attempts       int                   `db:"attempts"`
+	ResponseStatus *int                  `db:"response_status"`
+	LastError      string                `db:"last_error"`
+	CreatedAt      time.Time             `db:"created_at"`
+	NextAttemptAt  time.Time             `db:"next_attempt_at"`
+	DeliveredAt    *time.Time            `db:"delivered_at"`
+}
+
+// WebhookDeliveryFilter narrows the deliveries of a webhook. Deliveries are
+// ordered by id and After is the last id of the previous page.
+type WebhookDeliveryFilter struct {
+	WebhookId int64
+	Status    WebhookDeliveryStatus
+	After     int64
+	Limit     int
+}
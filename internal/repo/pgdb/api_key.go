@@ -0,0 +1,232 @@
+package pgdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/repo/repoerrs"
+	"github.com/ABDURAZZAKK/avito_experiment/pkg/postgres"
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+type ApiKeyRepo struct {
+	*postgres.Postgres
+}
+
+func NewApiKeyRepo(pg *postgres.Postgres) *ApiKeyRepo {
+	return &ApiKeyRepo{pg}
+}
+
+var apiKeyColumns = []string{
+	"id",
+	"name",
+	"prefix",
+	"hash",
+	"scopes",
+	"created_at",
+	"last_used_at",
+	"revoked_at",
+	"(SELECT COALESCE(jsonb_object_agg(team, role), '{}') FROM api_key_roles WHERE api_key_id = api_keys.id)",
+}
+
+func scanApiKey(row pgx.Row) (entity.ApiKey, error) {
+	var (
+		k      entity.ApiKey
+		scopes []string
+		roles  []byte
+	)
+	err := row.Scan(&k.Id, &k.Name, &k.Prefix, &k.Hash, &scopes, &k.CreatedAt, &k.LastUsedAt, &k.RevokedAt, &roles)
+	if err != nil {
+		return entity.ApiKey{}, err
+	}
+	if err = json.Unmarshal(roles, &k.Roles); err != nil {
+		return entity.ApiKey{}, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+	k.Scopes = make([]entity.Scope, 0, len(scopes))
+	for _, s := range scopes {
+		k.Scopes = append(k.Scopes, entity.Scope(s))
+	}
+	return k, nil
+}
+
+// Create stores the key with its roles in one transaction, so a key is never
+// usable with only some of its roles.
+func (r *ApiKeyRepo) Create(ctx context.Context, key entity.ApiKey) (int64, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("ApiKeyRepo.Create - r.Pool.Begin: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	scopes := make([]string, 0, len(key.Scopes))
+	for _, s := range key.Scopes {
+		scopes = append(scopes, string(s))
+	}
+	sql, args, _ := r.Builder.
+		Insert("api_keys").
+		Columns("name", "prefix", "hash", "scopes").
+		Values(key.Name, key.Prefix, key.Hash, scopes).
+		Suffix("RETURNING id").
+		ToSql()
+
+	var id int64
+	if err = tx.QueryRow(ctx, sql, args...).Scan(&id); err != nil {
+		var pgErr *pgconn.PgError
+		if ok := errors.As(err, &pgErr); ok && pgErr.Code == "23505" {
+			return 0, repoerrs.ErrAlreadyExists
+		}
+		return 0, fmt.Errorf("ApiKeyRepo.Create - tx.QueryRow: %w", err)
+	}
+
+	if len(key.Roles) != 0 {
+		builder := r.Builder.
+			Insert("api_key_roles").
+			Columns("api_key_id", "team", "role")
+		for team, role := range key.Roles {
+			builder = builder.Values(id, team, role)
+		}
+		sql, args, _ = builder.ToSql()
+		if _, err = tx.Exec(ctx, sql, args...); err != nil {
+			return 0, fmt.Errorf("ApiKeyRepo.Create (roles) - tx.Exec: %w", err)
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("ApiKeyRepo.Create - tx.Commit: %w", err)
+	}
+	return id, nil
+}
+
+func (r *ApiKeyRepo) GetById(ctx context.Context, id int64) (entity.ApiKey, error) {
+	sql, args, _ := r.Builder.
+		Select(apiKeyColumns...).
+		From("api_keys").
+		Where("id = ?", id).
+		ToSql()
+
+	k, err := scanApiKey(r.Pool.QueryRow(ctx, sql, args...))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return entity.ApiKey{}, repoerrs.ErrNotFound
+		}
+		return entity.ApiKey{}, fmt.Errorf("ApiKeyRepo.GetById - r.Pool.QueryRow: %w", err)
+	}
+	return k, nil
+}
+
+// GetByHash returns the key with the hash, revoked or not.
+func (r *ApiKeyRepo) GetByHash(ctx context.Context, hash string) (entity.ApiKey, error) {
+	sql, args, _ := r.Builder.
+		Select(apiKeyColumns...).
+		From("api_keys").
+		Where("hash = ?", hash).
+		ToSql()
+
+	k, err := scanApiKey(r.Pool.QueryRow(ctx, sql, args...))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return entity.ApiKey{}, repoerrs.ErrNotFound
+		}
+		return entity.ApiKey{}, fmt.Errorf("ApiKeyRepo.GetByHash - r.Pool.QueryRow: %w", err)
+	}
+	return k, nil
+}
+
+func (r *ApiKeyRepo) List(ctx context.Context) ([]entity.ApiKey, error) {
+	sql, args, _ := r.Builder.
+		Select(apiKeyColumns...).
+		From("api_keys").
+		OrderBy("id").
+		ToSql()
+
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ApiKeyRepo.List - r.Pool.Query: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []entity.ApiKey{}
+	for rows.Next() {
+		k, err := scanApiKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("ApiKeyRepo.List - rows.Scan: %w", err)
+		}
+		keys = append(keys, k)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("ApiKeyRepo.List - rows.Err: %w", err)
+	}
+	return keys, nil
+}
+
+// Revoke marks the key revoked. Revoking a revoked key keeps the first time.
+func (r *ApiKeyRepo) Revoke(ctx context.Context, id int64, at time.Time) error {
+	sql, args, _ := r.Builder.
+		Update("api_keys").
+		Set("revoked_at", squirrel.Expr("COALESCE(revoked_at, ?::timestamptz)", at)).
+		Where("id = ?", id).
+		ToSql()
+
+	tag, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("ApiKeyRepo.Revoke - r.Pool.Exec: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return repoerrs.ErrNotFound
+	}
+	return nil
+}
+
+func (r *ApiKeyRepo) Touch(ctx context.Context, id int64, at time.Time) error {
+	sql, args, _ := r.Builder.
+		Update("api_keys").
+		Set("last_used_at", at).
+		Where("id = ?", id).
+		ToSql()
+
+	if _, err := r.Pool.Exec(ctx, sql, args...); err != nil {
+		return fmt.Errorf("ApiKeyRepo.Touch - r.Pool.Exec: %w", err)
+	}
+	return nil
+}
+
+// SetRole gives the key role in team, replacing the role it had there.
+func (r *ApiKeyRepo) SetRole(ctx context.Context, id int64, team string, role entity.Role) error {
+	sql, args, _ := r.Builder.
+		Insert("api_key_roles").
+		Columns("api_key_id", "team", "role").
+		Values(id, team, role).
+		Suffix("ON CONFLICT (api_key_id, team) DO UPDATE SET role = EXCLUDED.role").
+		ToSql()
+
+	if _, err := r.Pool.Exec(ctx, sql, args...); err != nil {
+		var pgErr *pgconn.PgError
+		if ok := errors.As(err, &pgErr); ok && pgErr.Code == "23503" {
+			return repoerrs.ErrNotFound
+		}
+		return fmt.Errorf("ApiKeyRepo.SetRole - r.Pool.Exec: %w", err)
+	}
+	return nil
+}
+
+func (r *ApiKeyRepo) DeleteRole(ctx context.Context, id int64, team string) error {
+	sql, args, _ := r.Builder.
+		Delete("api_key_roles").
+		Where("api_key_id = ? AND team = ?", id, team).
+		ToSql()
+
+	tag, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("ApiKeyRepo.DeleteRole - r.Pool.Exec: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return repoerrs.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package pgdb
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
+	"github.com/ABDURAZZAKK/avito_experiment/pkg/postgres"
+)
+
+type AuditRepo struct {
+	*postgres.Postgres
+}
+
+func NewAuditRepo(pg *postgres.Postgres) *AuditRepo {
+	return &AuditRepo{pg}
+}
+
+func (r *AuditRepo) Create(ctx context.Context, entry entity.AuditEntry) error {
+	sql, args, _ := r.Builder.
+		Insert("audit_log").
+		Columns("actor", "action", "resource", "team", "outcome", "reason").
+		Values(entry.Actor, entry.Action, entry.Resource, entry.Team, entry.Outcome, entry.Reason).
+		ToSql()
+
+	if _, err := r.Pool.Exec(ctx, sql, args...); err != nil {
+		return fmt.Errorf("AuditRepo.Create - r.Pool.Exec: %w", err)
+	}
+	return nil
+}
+
+func (r *AuditRepo) List(ctx context.Context, filter entity.AuditFilter) ([]entity.AuditEntry, error) {
+	builder := r.Builder.
+		Select("id", "created_at", "actor", "action", "resource", "team", "outcome", "reason").
+		From("audit_log").
+		OrderBy("id").
+		Limit(uint64(filter.Limit))
+	if filter.Actor != "" {
+		builder = builder.Where("actor = ?", filter.Actor)
+	}
+	if filter.Action != "" {
+		builder = builder.Where("action = ?", filter.Action)
+	}
+	if filter.Team != "" {
+		builder = builder.Where("team = ?", filter.Team)
+	}
+	if filter.After > 0 {
+		builder = builder.Where("id > ?", filter.After)
+	}
+	sql, args, _ := builder.ToSql()
+
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("AuditRepo.List - r.Pool.Query: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []entity.AuditEntry{}
+	for rows.Next() {
+		var e entity.AuditEntry
+		err := rows.Scan(
+			&e.Id,
+			&e.CreatedAt,
+			&e.Actor,
+			&e.Action,
+			&e.Resource,
+			&e.Team,
+			&e.Outcome,
+			&e.Reason,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("AuditRepo.List - rows.Scan: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("AuditRepo.List - rows.Err: %w", err)
+	}
+	return entries, nil
+}
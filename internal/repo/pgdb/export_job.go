@@ -0,0 +1,165 @@
+package pgdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/repo/repoerrs"
+	"github.com/ABDURAZZAKK/avito_experiment/pkg/postgres"
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+)
+
+type ExportJobRepo struct {
+	*postgres.Postgres
+}
+
+func NewExportJobRepo(pg *postgres.Postgres) *ExportJobRepo {
+	return &ExportJobRepo{pg}
+}
+
+// NextId reserves an id for a job that is about to be created, so the task
+// message can refer to it before the job row exists.
+func (r *ExportJobRepo) NextId(ctx context.Context) (int64, error) {
+	var id int64
+	err := r.Pool.QueryRow(ctx, "SELECT nextval(pg_get_serial_sequence('export_jobs', 'id'))").Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("ExportJobRepo.NextId - r.Pool.QueryRow: %w", err)
+	}
+	return id, nil
+}
+
+// Create stores a queued job together with the task that asks the consumer
+// to run it, so a job is never left without a task or the other way round.
+// With maxPerDay above zero it fails with repoerrs.ErrLimitExceeded when
+// job.RequestedBy has already started that many exports this UTC day.
+func (r *ExportJobRepo) Create(ctx context.Context, job entity.ExportJob, task entity.OutboxMessage, maxPerDay int) error {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("ExportJobRepo.Create - r.Pool.Begin: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if maxPerDay > 0 {
+		// Concurrent exports of the same actor wait here, so they cannot all
+		// pass the count.
+		_, err = tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", "export_jobs:"+job.RequestedBy)
+		if err != nil {
+			return fmt.Errorf("ExportJobRepo.Create (lock) - tx.Exec: %w", err)
+		}
+		sql, args, _ := r.Builder.
+			Select("count(*)").
+			From("export_jobs").
+			Where("requested_by = ?", job.RequestedBy).
+			Where("created_at >= date_trunc('day', now() AT TIME ZONE 'UTC') AT TIME ZONE 'UTC'").
+			ToSql()
+		var today int
+		if err = tx.QueryRow(ctx, sql, args...).Scan(&today); err != nil {
+			return fmt.Errorf("ExportJobRepo.Create (count) - tx.QueryRow: %w", err)
+		}
+		if today >= maxPerDay {
+			return repoerrs.ErrLimitExceeded
+		}
+	}
+
+	sql, args, _ := r.Builder.
+		Insert("export_jobs").
+		Columns("id", "status", "year", "month", "timezone", "requested_by").
+		Values(job.Id, entity.EXPORT_QUEUED, job.Year, job.Month, job.Timezone, job.RequestedBy).
+		ToSql()
+	if _, err = tx.Exec(ctx, sql, args...); err != nil {
+		return fmt.Errorf("ExportJobRepo.Create - tx.Exec: %w", err)
+	}
+	if err = insertOutbox(ctx, tx, r.Builder, task); err != nil {
+		return fmt.Errorf("ExportJobRepo.Create - insertOutbox: %w", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("ExportJobRepo.Create - tx.Commit: %w", err)
+	}
+	return nil
+}
+
+func (r *ExportJobRepo) GetById(ctx context.Context, id int64) (entity.ExportJob, error) {
+	sql, args, _ := r.Builder.
+		Select("id", "status", "year", "month", "timezone", "requested_by", "filename",
+			"row_count", "error", "created_at", "started_at", "finished_at").
+		From("export_jobs").
+		Where("id = ?", id).
+		ToSql()
+
+	var job entity.ExportJob
+	err := r.Pool.QueryRow(ctx, sql, args...).Scan(
+		&job.Id,
+		&job.Status,
+		&job.Year,
+		&job.Month,
+		&job.Timezone,
+		&job.RequestedBy,
+		&job.Filename,
+		&job.RowCount,
+		&job.Error,
+		&job.CreatedAt,
+		&job.StartedAt,
+		&job.FinishedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return entity.ExportJob{}, repoerrs.ErrNotFound
+		}
+		return entity.ExportJob{}, fmt.Errorf("ExportJobRepo.GetById - r.Pool.QueryRow: %w", err)
+	}
+	return job, nil
+}
+
+func (r *ExportJobRepo) MarkRunning(ctx context.Context, id int64) error {
+	return r.update(ctx, "ExportJobRepo.MarkRunning", r.Builder.
+		Update("export_jobs").
+		Set("status", entity.EXPORT_RUNNING).
+		Set("started_at", squirrel.Expr("now()")).
+		Set("error", "").
+		Where("id = ?", id))
+}
+
+func (r *ExportJobRepo) MarkSucceeded(ctx context.Context, id int64, filename string, rowCount int) error {
+	return r.update(ctx, "ExportJobRepo.MarkSucceeded", r.Builder.
+		Update("export_jobs").
+		Set("status", entity.EXPORT_SUCCEEDED).
+		Set("filename", filename).
+		Set("row_count", rowCount).
+		Set("finished_at", squirrel.Expr("now()")).
+		Where("id = ?", id))
+}
+
+// MarkRetrying puts a job back into the queue after a failed attempt and keeps
+// the last error visible while the task waits for its retry.
+func (r *ExportJobRepo) MarkRetrying(ctx context.Context, id int64, reason string) error {
+	return r.update(ctx, "ExportJobRepo.MarkRetrying", r.Builder.
+		Update("export_jobs").
+		Set("status", entity.EXPORT_QUEUED).
+		Set("error", reason).
+		Where("id = ?", id))
+}
+
+func (r *ExportJobRepo) MarkFailed(ctx context.Context, id int64, reason string) error {
+	return r.update(ctx, "ExportJobRepo.MarkFailed", r.Builder.
+		Update("export_jobs").
+		Set("status", entity.EXPORT_FAILED).
+		Set("error", reason).
+		Set("finished_at", squirrel.Expr("now()")).
+		Where("id = ?", id))
+}
+
+func (r *ExportJobRepo) update(ctx context.Context, op string, builder squirrel.UpdateBuilder) error {
+	sql, args, _ := builder.ToSql()
+	tag, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("%s - r.Pool.Exec: %w", op, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return repoerrs.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package pgdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/repo/repoerrs"
+	"github.com/ABDURAZZAKK/avito_experiment/pkg/postgres"
+	"github.com/jackc/pgx/v5"
+)
+
+type IdempotencyKeyRepo struct {
+	*postgres.Postgres
+}
+
+func NewIdempotencyKeyRepo(pg *postgres.Postgres) *IdempotencyKeyRepo {
+	return &IdempotencyKeyRepo{pg}
+}
+
+// Reserve stores key of the API key for a new request unless it is already
+// taken. A key created before expiredBefore, or reserved before staleBefore by
+// a request that never completed, is forgotten and taken again. It returns
+// true and the new reservation, whose StartedAt identifies it to Complete and
+// Delete, when the key was reserved, otherwise the stored key.
+func (r *IdempotencyKeyRepo) Reserve(ctx context.Context, apiKeyId int64, key string, requestHash string, expiredBefore time.Time, staleBefore time.Time) (entity.IdempotencyKey, bool, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return entity.IdempotencyKey{}, false, fmt.Errorf("IdempotencyKeyRepo.Reserve - r.Pool.Begin: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	sql, args, _ := r.Builder.
+		Delete("idempotency_keys").
+		Where("api_key_id = ? AND key = ?", apiKeyId, key).
+		Where("(created_at < ? OR (response_status IS NULL AND started_at < ?))", expiredBefore, staleBefore).
+		ToSql()
+	if _, err = tx.Exec(ctx, sql, args...); err != nil {
+		return entity.IdempotencyKey{}, false, fmt.Errorf("IdempotencyKeyRepo.Reserve (expired) - tx.Exec: %w", err)
+	}
+
+	sql, args, _ = r.Builder.
+		Insert("idempotency_keys").
+		Columns("api_key_id", "key", "request_hash").
+		Values(apiKeyId, key, requestHash).
+		Suffix("ON CONFLICT (api_key_id, key) DO NOTHING RETURNING created_at, started_at").
+		ToSql()
+	stored := entity.IdempotencyKey{ApiKeyId: apiKeyId, Key: key, RequestHash: requestHash}
+	reserved := true
+	err = tx.QueryRow(ctx, sql, args...).Scan(&stored.CreatedAt, &stored.StartedAt)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return entity.IdempotencyKey{}, false, fmt.Errorf("IdempotencyKeyRepo.Reserve - tx.QueryRow: %w", err)
+		}
+		reserved = false
+	}
+
+	if !reserved {
+		sql, args, _ = r.Builder.
+			Select("api_key_id", "key", "request_hash", "response_status", "response_content_type", "response_headers", "response_body", "created_at", "started_at").
+			From("idempotency_keys").
+			Where("api_key_id = ? AND key = ?", apiKeyId, key).
+			ToSql()
+		err = tx.QueryRow(ctx, sql, args...).Scan(
+			&stored.ApiKeyId,
+			&stored.Key,
+			&stored.RequestHash,
+			&stored.ResponseStatus,
+			&stored.ResponseContentType,
+			&stored.ResponseHeaders,
+			&stored.ResponseBody,
+			&stored.CreatedAt,
+			&stored.StartedAt,
+		)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return entity.IdempotencyKey{}, false, repoerrs.ErrNotFound
+			}
+			return entity.IdempotencyKey{}, false, fmt.Errorf("IdempotencyKeyRepo.Reserve - tx.QueryRow: %w", err)
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return entity.IdempotencyKey{}, false, fmt.Errorf("IdempotencyKeyRepo.Reserve - tx.Commit: %w", err)
+	}
+	return stored, reserved, nil
+}
+
+// Complete stores the response of the request that reserved key at startedAt.
+// A key that already has a response, or was taken over by another request,
+// is left alone.
+func (r *IdempotencyKeyRepo) Complete(ctx context.Context, apiKeyId int64, key string, startedAt time.Time, status int, contentType string, headers map[string]string, body []byte) error {
+	if headers == nil {
+		headers = map[string]string{}
+	}
+	sql, args, _ := r.Builder.
+		Update("idempotency_keys").
+		Set("response_status", status).
+		Set("response_content_type", contentType).
+		Set("response_headers", headers).
+		Set("response_body", body).
+		Where("api_key_id = ? AND key = ? AND started_at = ? AND response_status IS NULL", apiKeyId, key, startedAt).
+		ToSql()
+
+	if _, err := r.Pool.Exec(ctx, sql, args...); err != nil {
+		return fmt.Errorf("IdempotencyKeyRepo.Complete - r.Pool.Exec: %w", err)
+	}
+	return nil
+}
+
+// Delete releases the reservation made at startedAt of a request that did not
+// produce a response worth replaying. A key that already has a response, or
+// was taken over by another request, is kept.
+func (r *IdempotencyKeyRepo) Delete(ctx context.Context, apiKeyId int64, key string, startedAt time.Time) error {
+	sql, args, _ := r.Builder.
+		Delete("idempotency_keys").
+		Where("api_key_id = ? AND key = ? AND started_at = ? AND response_status IS NULL", apiKeyId, key, startedAt).
+		ToSql()
+
+	if _, err := r.Pool.Exec(ctx, sql, args...); err != nil {
+		return fmt.Errorf("IdempotencyKeyRepo.Delete - r.Pool.Exec: %w", err)
+	}
+	return nil
+}
+
+// DeleteExpired removes up to limit keys created before expiredBefore.
+func (r *IdempotencyKeyRepo) DeleteExpired(ctx context.Context, expiredBefore time.Time, limit int) (int, error) {
+	sql := `DELETE FROM idempotency_keys
+	WHERE (api_key_id, key) IN (
+		SELECT api_key_id, key FROM idempotency_keys
+		WHERE created_at < $1
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED
+	)`
+
+	tag, err := r.Pool.Exec(ctx, sql, expiredBefore, limit)
+	if err != nil {
+		return 0, fmt.Errorf("IdempotencyKeyRepo.DeleteExpired - r.Pool.Exec: %w", err)
+	}
+	return int(tag.RowsAffected()), nil
+}
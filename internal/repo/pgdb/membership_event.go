@@ -0,0 +1,70 @@
+package pgdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
+)
+
+// membershipEvent turns a membership change into an outbox message for the
+// events exchange. occurred_at is written in UTC with the microseconds
+// postgres keeps, as membershipEventsSql writes it.
+func membershipEvent(e entity.MembershipEvent) (entity.OutboxMessage, error) {
+	e.OccurredAt = e.OccurredAt.UTC().Truncate(time.Microsecond)
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return entity.OutboxMessage{}, fmt.Errorf("membershipEvent - json.Marshal: %w", err)
+	}
+	return entity.OutboxMessage{
+		RoutingKey: e.RoutingKey(),
+		Headers:    membershipEventHeaders(),
+		Payload:    payload,
+	}, nil
+}
+
+func membershipEventHeaders() map[string]string {
+	return map[string]string{
+		entity.HEADER_EVENT_TYPE:    entity.EVENT_MEMBERSHIP,
+		entity.HEADER_EVENT_VERSION: strconv.Itoa(entity.EVENT_MEMBERSHIP_VERSION),
+	}
+}
+
+// membershipEventsSql is the statement that queues a membership event for
+// every (user_pk, segment_pk) row of the changes CTE, for the set based
+// changes that never load the rows into Go. operation, at and the fields of
+// change are the placeholders of the surrounding statement holding those
+// values. The payload is the JSON of entity.MembershipEvent; occurred_at is
+// formatted like a time.Time in UTC, RFC 3339 with a Z and no trailing zeros
+// in the fraction, rather than the +00:00 of a timestamptz in JSON.
+func membershipEventsSql(changes, operation, at string, change changeArgs) string {
+	headers, _ := json.Marshal(membershipEventHeaders())
+	return fmt.Sprintf(`INSERT INTO outbox (routing_key, headers, payload)
+	SELECT segment_pk || '.' || %[2]s::varchar, '%[4]s'::jsonb,
+		convert_to(json_build_object(
+			'user', user_pk,
+			'segment', segment_pk,
+			'operation', %[2]s::varchar,
+			'actor', %[5]s::varchar,
+			'source', %[6]s::varchar,
+			'reason', %[7]s::text,
+			'occurred_at', rtrim(rtrim(to_char(%[3]s::timestamptz AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.US'), '0'), '.') || 'Z'
+		)::text, 'UTF8')
+	FROM %[1]s`, changes, operation, at, headers, change.actor, change.source, change.reason)
+}
+
+// changeArgs names the placeholders holding the fields of an entity.Change.
+type changeArgs struct {
+	actor, source, reason string
+}
+
+// statsSql is the statement that records every (user_pk, segment_pk) row of
+// the changes CTE in users_segments_stats, with the same placeholders as
+// membershipEventsSql.
+func statsSql(changes, operation, at string, change changeArgs) string {
+	return fmt.Sprintf(`INSERT INTO users_segments_stats (user_pk, segment_pk, created_at, operation, actor, source, reason)
+		SELECT user_pk, segment_pk, %[3]s::timestamptz, %[2]s::varchar, %[4]s::varchar, %[5]s::varchar, %[6]s::text FROM %[1]s`,
+		changes, operation, at, change.actor, change.source, change.reason)
+}
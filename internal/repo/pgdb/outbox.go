@@ -0,0 +1,109 @@
+package pgdb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
+	"github.com/ABDURAZZAKK/avito_experiment/pkg/postgres"
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+)
+
+type OutboxRepo struct {
+	*postgres.Postgres
+}
+
+func NewOutboxRepo(pg *postgres.Postgres) *OutboxRepo {
+	return &OutboxRepo{pg}
+}
+
+// insertOutbox queues messages for the relay inside the caller's transaction.
+func insertOutbox(ctx context.Context, tx pgx.Tx, builder squirrel.StatementBuilderType, messages ...entity.OutboxMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	insert := builder.
+		Insert("outbox").
+		Columns("routing_key", "headers", "payload")
+	for _, m := range messages {
+		headers := m.Headers
+		if headers == nil {
+			headers = map[string]string{}
+		}
+		insert = insert.Values(m.RoutingKey, headers, m.Payload)
+	}
+	sql, args, _ := insert.ToSql()
+	if _, err := tx.Exec(ctx, sql, args...); err != nil {
+		return fmt.Errorf("insertOutbox - tx.Exec: %w", err)
+	}
+	return nil
+}
+
+// Claim takes up to limit due messages, oldest first, and hides them from
+// other relays for lease, long enough to publish them and record the result.
+// No transaction is held while they are published, and a relay that dies in
+// between only delays the messages by lease.
+func (r *OutboxRepo) Claim(ctx context.Context, limit int, lease time.Duration) ([]entity.OutboxMessage, error) {
+	sql := `UPDATE outbox
+	SET next_attempt_at = now() + make_interval(secs => $2::float8)
+	WHERE id IN (
+		SELECT id FROM outbox
+		WHERE next_attempt_at <= now()
+		ORDER BY id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING id, routing_key, headers, payload, attempts, created_at`
+
+	rows, err := r.Pool.Query(ctx, sql, limit, lease.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("OutboxRepo.Claim - r.Pool.Query: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []entity.OutboxMessage
+	for rows.Next() {
+		var m entity.OutboxMessage
+		if err := rows.Scan(&m.Id, &m.RoutingKey, &m.Headers, &m.Payload, &m.Attempts, &m.CreatedAt); err != nil {
+			return nil, fmt.Errorf("OutboxRepo.Claim - rows.Scan: %w", err)
+		}
+		messages = append(messages, m)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("OutboxRepo.Claim - rows.Err: %w", err)
+	}
+	// UPDATE ... RETURNING does not keep the order of the subquery
+	sort.Slice(messages, func(i, j int) bool { return messages[i].Id < messages[j].Id })
+	return messages, nil
+}
+
+// MarkSent deletes a published message.
+func (r *OutboxRepo) MarkSent(ctx context.Context, id int64) error {
+	sql, args, _ := r.Builder.
+		Delete("outbox").
+		Where("id = ?", id).
+		ToSql()
+	if _, err := r.Pool.Exec(ctx, sql, args...); err != nil {
+		return fmt.Errorf("OutboxRepo.MarkSent - r.Pool.Exec: %w", err)
+	}
+	return nil
+}
+
+// MarkFailed postpones a message the broker did not accept with an exponential
+// backoff capped at maxBackoff.
+func (r *OutboxRepo) MarkFailed(ctx context.Context, id int64, reason string, maxBackoff time.Duration) error {
+	sql, args, _ := r.Builder.
+		Update("outbox").
+		Set("attempts", squirrel.Expr("attempts + 1")).
+		Set("last_error", reason).
+		Set("next_attempt_at", squirrel.Expr("now() + make_interval(secs => LEAST(power(2, attempts), ?::float8))", maxBackoff.Seconds())).
+		Where("id = ?", id).
+		ToSql()
+	if _, err := r.Pool.Exec(ctx, sql, args...); err != nil {
+		return fmt.Errorf("OutboxRepo.MarkFailed - r.Pool.Exec: %w", err)
+	}
+	return nil
+}
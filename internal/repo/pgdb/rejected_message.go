@@ -0,0 +1,36 @@
+package pgdb
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
+	"github.com/ABDURAZZAKK/avito_experiment/pkg/postgres"
+)
+
+type RejectedMessageRepo struct {
+	*postgres.Postgres
+}
+
+func NewRejectedMessageRepo(pg *postgres.Postgres) *RejectedMessageRepo {
+	return &RejectedMessageRepo{pg}
+}
+
+func (r *RejectedMessageRepo) Create(ctx context.Context, msg entity.RejectedMessage) (int64, error) {
+	headers := msg.Headers
+	if headers == nil {
+		headers = map[string]string{}
+	}
+	sql, args, _ := r.Builder.
+		Insert("rejected_messages").
+		Columns("headers", "body", "reason").
+		Values(headers, msg.Body, msg.Reason).
+		Suffix("RETURNING id").
+		ToSql()
+
+	var id int64
+	if err := r.Pool.QueryRow(ctx, sql, args...).Scan(&id); err != nil {
+		return 0, fmt.Errorf("RejectedMessageRepo.Create - r.Pool.QueryRow: %w", err)
+	}
+	return id, nil
+}
@@ -4,10 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
 	"github.com/ABDURAZZAKK/avito_experiment/internal/repo/repoerrs"
 	"github.com/ABDURAZZAKK/avito_experiment/pkg/postgres"
+	"github.com/Masterminds/squirrel"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	log "github.com/sirupsen/logrus"
@@ -24,43 +27,136 @@ func NewSegmentRepo(pg *postgres.Postgres) *SegmentRepo {
 func (r *SegmentRepo) GetTransaction(ctx context.Context) (pgx.Tx, error) {
 	tx, err := r.Pool.Begin(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("UsersSegmentsRepo.AddAndRemoveSegmentsUser - r.Pool.Begin: %v", err)
+		return nil, fmt.Errorf("UsersSegmentsRepo.AddAndRemoveSegmentsUser - r.Pool.Begin: %w", err)
 	}
 
 	return tx, nil
 }
 
+var segmentColumns = []string{
+	"slug",
+	"salt",
+	"description",
+	"owner",
+	"team",
+	"status",
+	"tags",
+	"rollout_percentage",
+	"rollout_expires_at",
+	"created_at",
+	"updated_at",
+}
+
+func scanSegment(row pgx.Row, segment *entity.Segment) error {
+	return row.Scan(
+		&segment.Slug,
+		&segment.Salt,
+		&segment.Description,
+		&segment.Owner,
+		&segment.Team,
+		&segment.Status,
+		&segment.Tags,
+		&segment.RolloutPercentage,
+		&segment.RolloutExpiresAt,
+		&segment.CreatedAt,
+		&segment.UpdatedAt,
+	)
+}
+
+// nonNilTags keeps a missing tag list from being written as NULL.
+func nonNilTags(tags []string) []string {
+	if tags == nil {
+		return []string{}
+	}
+	return tags
+}
+
+// escapeLike escapes the LIKE wildcards in a user supplied prefix.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
 func (r *SegmentRepo) GetBySlug(ctx context.Context, slug string) (entity.Segment, error) {
 	sql, args, _ := r.Builder.
-		Select("*").
+		Select(segmentColumns...).
 		From("segments").
 		Where("slug = ?", slug).
 		ToSql()
 
 	var segment entity.Segment
-	err := r.Pool.QueryRow(ctx, sql, args...).Scan(
-		&segment.Slug,
-	)
+	err := scanSegment(r.Pool.QueryRow(ctx, sql, args...), &segment)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return entity.Segment{}, repoerrs.ErrNotFound
 		}
-		return entity.Segment{}, fmt.Errorf("SegmentRepo.GetBySlug - r.Pool.QueryRow: %v", err)
+		return entity.Segment{}, fmt.Errorf("SegmentRepo.GetBySlug - r.Pool.QueryRow: %w", err)
 	}
 
 	return segment, nil
 }
 
-func (r *SegmentRepo) Create(ctx context.Context, slug string) (string, error) {
+func (r *SegmentRepo) List(ctx context.Context, filter entity.SegmentFilter) ([]entity.Segment, error) {
+	builder := r.Builder.
+		Select(segmentColumns...).
+		From("segments").
+		OrderBy("slug").
+		Limit(uint64(filter.Limit))
+	if filter.After != "" {
+		builder = builder.Where("slug > ?", filter.After)
+	}
+	if filter.SlugPrefix != "" {
+		builder = builder.Where(squirrel.Like{"slug": escapeLike(filter.SlugPrefix) + "%"})
+	}
+	if filter.Status != "" {
+		builder = builder.Where("status = ?", filter.Status)
+	}
+	if filter.Tag != "" {
+		builder = builder.Where("tags @> ARRAY[?]::text[]", filter.Tag)
+	}
+	if filter.Team != "" {
+		builder = builder.Where("team = ?", filter.Team)
+	}
+	sql, args, _ := builder.ToSql()
+
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("SegmentRepo.List - r.Pool.Query: %w", err)
+	}
+	defer rows.Close()
+
+	segments := []entity.Segment{}
+	for rows.Next() {
+		var segment entity.Segment
+		if err := scanSegment(rows, &segment); err != nil {
+			return nil, fmt.Errorf("SegmentRepo.List - rows.Scan: %w", err)
+		}
+		segments = append(segments, segment)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("SegmentRepo.List - rows.Err: %w", err)
+	}
+	return segments, nil
+}
+
+// Create inserts the segment. With a RolloutPercentage the segment is rolled
+// out in the same transaction, the memberships attributed to change, so a
+// failed rollout leaves no segment behind.
+func (r *SegmentRepo) Create(ctx context.Context, segment entity.Segment, change entity.Change) (string, error) {
 	sql, args, _ := r.Builder.
 		Insert("segments").
-		Columns("slug").
-		Values(slug).
+		Columns("slug", "description", "owner", "team", "status", "tags").
+		Values(segment.Slug, segment.Description, segment.Owner, segment.Team, segment.Status, nonNilTags(segment.Tags)).
 		Suffix("RETURNING slug").
 		ToSql()
 
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return "", fmt.Errorf("SegmentRepo.Create - r.Pool.Begin: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
 	var _slug string
-	err := r.Pool.QueryRow(ctx, sql, args...).Scan(&_slug)
+	err = tx.QueryRow(ctx, sql, args...).Scan(&_slug)
 	if err != nil {
 		log.Debugf("err: %v", err)
 		var pgErr *pgconn.PgError
@@ -69,33 +165,133 @@ func (r *SegmentRepo) Create(ctx context.Context, slug string) (string, error) {
 				return "", repoerrs.ErrAlreadyExists
 			}
 		}
-		return "", fmt.Errorf("SegmentRepo.Create - r.Pool.QueryRow: %v", err)
+		return "", fmt.Errorf("SegmentRepo.Create - tx.QueryRow: %w", err)
+	}
+	if segment.RolloutPercentage > 0 {
+		_, err = rollout(ctx, tx, r.Builder, _slug, segment.RolloutPercentage, segment.RolloutExpiresAt, change)
+		if err != nil {
+			return "", fmt.Errorf("SegmentRepo.Create - %w", err)
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return "", fmt.Errorf("SegmentRepo.Create - tx.Commit: %w", err)
 	}
 	return _slug, nil
 }
 
-func (r *SegmentRepo) CreateAll(ctx context.Context, slugs []string) error {
+// Update overwrites the editable metadata of the segment and bumps updated_at.
+func (r *SegmentRepo) Update(ctx context.Context, segment entity.Segment) (entity.Segment, error) {
+	sql, args, _ := r.Builder.
+		Update("segments").
+		Set("description", segment.Description).
+		Set("owner", segment.Owner).
+		Set("team", segment.Team).
+		Set("status", segment.Status).
+		Set("tags", nonNilTags(segment.Tags)).
+		Set("updated_at", squirrel.Expr("now()")).
+		Where("slug = ?", segment.Slug).
+		Suffix("RETURNING " + strings.Join(segmentColumns, ", ")).
+		ToSql()
+
+	var updated entity.Segment
+	err := scanSegment(r.Pool.QueryRow(ctx, sql, args...), &updated)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return entity.Segment{}, repoerrs.ErrNotFound
+		}
+		return entity.Segment{}, fmt.Errorf("SegmentRepo.Update - r.Pool.QueryRow: %w", err)
+	}
+	return updated, nil
+}
+
+// CreateAll creates the segments in one transaction and, with a percent, rolls
+// each of them out in it too. When one of them already exists nothing is
+// created and the error names its slug.
+func (r *SegmentRepo) CreateAll(ctx context.Context, slugs []string, team string, percent int, expiresAt *time.Time, change entity.Change) error {
 	builder := r.Builder.
 		Insert("segments").
-		Columns("slug")
+		Columns("slug", "team")
 	for _, slug := range slugs {
-		builder = builder.Values(slug)
+		builder = builder.Values(slug, team)
 	}
-	sql, args, _ := builder.ToSql()
+	sql, args, _ := builder.
+		Suffix("ON CONFLICT DO NOTHING RETURNING slug").
+		ToSql()
 
-	if _, err := r.Pool.Exec(ctx, sql, args...); err != nil {
-		log.Debugf("err: %v", err)
-		var pgErr *pgconn.PgError
-		if ok := errors.As(err, &pgErr); ok {
-			if pgErr.Code == "23505" {
-				return repoerrs.ErrAlreadyExists
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("SegmentRepo.CreateAll - r.Pool.Begin: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("SegmentRepo.CreateAll - tx.Query: %w", err)
+	}
+	created := make(map[string]bool, len(slugs))
+	for rows.Next() {
+		var slug string
+		if err = rows.Scan(&slug); err != nil {
+			rows.Close()
+			return fmt.Errorf("SegmentRepo.CreateAll - rows.Scan: %w", err)
+		}
+		created[slug] = true
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("SegmentRepo.CreateAll - rows.Err: %w", err)
+	}
+	// a slug repeated in slugs is only created once
+	for _, slug := range slugs {
+		if !created[slug] {
+			return &repoerrs.KeyError{Key: slug, Err: repoerrs.ErrAlreadyExists}
+		}
+		delete(created, slug)
+	}
+	if percent > 0 {
+		for _, slug := range slugs {
+			if _, err = rollout(ctx, tx, r.Builder, slug, percent, expiresAt, change); err != nil {
+				return fmt.Errorf("SegmentRepo.CreateAll - %w", err)
 			}
 		}
-		return fmt.Errorf("SegmentRepo.CreateAll - r.Pool.QueryRow: %v", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("SegmentRepo.CreateAll - tx.Commit: %w", err)
 	}
 	return nil
 }
 
+// GetTeams returns the team of each of the segments. Missing segments are
+// missing from the result.
+func (r *SegmentRepo) GetTeams(ctx context.Context, slugs []string) (map[string]string, error) {
+	sql, args, _ := r.Builder.
+		Select("slug", "team").
+		From("segments").
+		Where(squirrel.Eq{"slug": slugs}).
+		ToSql()
+
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("SegmentRepo.GetTeams - r.Pool.Query: %w", err)
+	}
+	defer rows.Close()
+
+	teams := make(map[string]string, len(slugs))
+	for rows.Next() {
+		var slug, team string
+		if err := rows.Scan(&slug, &team); err != nil {
+			return nil, fmt.Errorf("SegmentRepo.GetTeams - rows.Scan: %w", err)
+		}
+		teams[slug] = team
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("SegmentRepo.GetTeams - rows.Err: %w", err)
+	}
+	return teams, nil
+}
+
 func (r *SegmentRepo) Delete(ctx context.Context, slug string) (string, error) {
 	sql, args, _ := r.Builder.
 		Delete("segments").
@@ -106,7 +302,7 @@ func (r *SegmentRepo) Delete(ctx context.Context, slug string) (string, error) {
 	var s string
 	err := r.Pool.QueryRow(ctx, sql, args...).Scan(&s)
 	if err != nil {
-		return "", fmt.Errorf("SegmentRepo.Delete - r.Pool.QueryRow: %v", err)
+		return "", fmt.Errorf("SegmentRepo.Delete - r.Pool.QueryRow: %w", err)
 	}
 	return s, nil
 }
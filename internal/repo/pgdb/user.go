@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
 	"github.com/ABDURAZZAKK/avito_experiment/internal/repo/repoerrs"
@@ -25,13 +26,15 @@ func NewUserRepo(pg *postgres.Postgres) *UserRepo {
 func (r *UserRepo) GetTransaction(ctx context.Context) (pgx.Tx, error) {
 	tx, err := r.Pool.Begin(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("UsersSegmentsRepo.AddAndRemoveSegmentsUser - r.Pool.Begin: %v", err)
+		return nil, fmt.Errorf("UsersSegmentsRepo.AddAndRemoveSegmentsUser - r.Pool.Begin: %w", err)
 	}
 
 	return tx, nil
 }
 
-func (r *UserRepo) Create(ctx context.Context, slug string) (int, error) {
+// Create inserts the user and, in the same transaction, enrolls it into the
+// rollouts it falls under, each membership attributed to change.
+func (r *UserRepo) Create(ctx context.Context, slug string, change entity.Change) (int, error) {
 	sql, args, _ := r.Builder.
 		Insert("users").
 		Columns("slug").
@@ -39,8 +42,14 @@ func (r *UserRepo) Create(ctx context.Context, slug string) (int, error) {
 		Suffix("RETURNING id").
 		ToSql()
 
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("UserRepo.Create - r.Pool.Begin: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
 	var id int
-	err := r.Pool.QueryRow(ctx, sql, args...).Scan(&id)
+	err = tx.QueryRow(ctx, sql, args...).Scan(&id)
 	if err != nil {
 		log.Debugf("err: %v", err)
 		var pgErr *pgconn.PgError
@@ -49,14 +58,21 @@ func (r *UserRepo) Create(ctx context.Context, slug string) (int, error) {
 				return 0, repoerrs.ErrAlreadyExists
 			}
 		}
-		return 0, fmt.Errorf("UserRepo.Create - r.Pool.QueryRow: %v", err)
+		return 0, fmt.Errorf("UserRepo.Create - tx.QueryRow: %w", err)
+	}
+	if _, err = enrollUser(ctx, tx, id, change); err != nil {
+		return 0, fmt.Errorf("UserRepo.Create - %w", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("UserRepo.Create - tx.Commit: %w", err)
 	}
 	return id, nil
 }
 
 func (r *UserRepo) GetById(ctx context.Context, id int) (entity.User, error) {
 	sql, args, _ := r.Builder.
-		Select("*").
+		Select("id", "slug", "segments_version").
 		From("users").
 		Where("id = ?", id).
 		ToSql()
@@ -65,18 +81,42 @@ func (r *UserRepo) GetById(ctx context.Context, id int) (entity.User, error) {
 	err := r.Pool.QueryRow(ctx, sql, args...).Scan(
 		&user.Id,
 		&user.Slug,
+		&user.SegmentsVersion,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return entity.User{}, repoerrs.ErrNotFound
 		}
-		return entity.User{}, fmt.Errorf("UserRepo.GetById - r.Pool.QueryRow: %v", err)
+		return entity.User{}, fmt.Errorf("UserRepo.GetById - r.Pool.QueryRow: %w", err)
 	}
 
 	return user, nil
 }
 
-func (r *UserRepo) Delete(ctx context.Context, id int) (int, error) {
+// Delete removes the user. Its memberships go first, each recorded as a
+// segment_removed stats row and event attributed to change.
+func (r *UserRepo) Delete(ctx context.Context, id int, change entity.Change) (int, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("UserRepo.Delete - r.Pool.Begin: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	placeholders := changeArgs{actor: "$4", source: "$5", reason: "$6"}
+	sql := `WITH removed AS (
+		DELETE FROM users_segments
+		WHERE user_pk = $1
+		RETURNING user_pk, segment_pk
+	), stats AS (
+		` + statsSql("removed", "$3", "$2", placeholders) + `
+	)
+	` + membershipEventsSql("removed", "$3", "$2", placeholders)
+	_, err = tx.Exec(ctx, sql, id, time.Now(), string(entity.SEGMENT_REMOVED),
+		change.Actor, string(change.Source), change.Reason)
+	if err != nil {
+		return 0, fmt.Errorf("UserRepo.Delete (segments) - tx.Exec: %w", err)
+	}
+
 	sql, args, _ := r.Builder.
 		Delete("users").
 		Where("id = ?", id).
@@ -84,40 +124,14 @@ func (r *UserRepo) Delete(ctx context.Context, id int) (int, error) {
 		ToSql()
 
 	var u_id int
-	err := r.Pool.QueryRow(ctx, sql, args...).Scan(&u_id)
+	err = tx.QueryRow(ctx, sql, args...).Scan(&u_id)
 	if err != nil {
-		return 0, fmt.Errorf("UserRepo.Delete - r.Pool.QueryRow: %v", err)
+		return 0, fmt.Errorf("UserRepo.Delete - tx.QueryRow: %w", err)
 	}
-	return u_id, nil
-
-}
-
-func (r *UserRepo) GetRandomIDs(ctx context.Context, limit int) ([]int, error) {
-	sql := fmt.Sprintf("SELECT id FROM users ORDER BY random() LIMIT %d;", limit)
-
-	rows, err := r.Pool.Query(ctx, sql)
-	if err != nil {
-		return nil, fmt.Errorf("UserRepo.GetRandomIDs - r.Pool.Query: %v", err)
+	if err = tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("UserRepo.Delete - tx.Commit: %w", err)
 	}
-	defer rows.Close()
-	var users []int
-	for rows.Next() {
-		var i int
-		err := rows.Scan(&i)
-		if err != nil {
-			return nil, fmt.Errorf("UserRepo.GetRandomIDs - rows.Scan: %v", err)
-		}
-		users = append(users, i)
-	}
-
-	if err = rows.Err(); err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, repoerrs.ErrNotFound
-		}
-		return nil, fmt.Errorf("UserRepo.GetRandomIDs - rows.Err: %v", err)
-	}
-
-	return users, nil
+	return u_id, nil
 }
 
 func (r *UserRepo) GetCount(ctx context.Context) (int, error) {
@@ -125,7 +139,7 @@ func (r *UserRepo) GetCount(ctx context.Context) (int, error) {
 
 	rows, err := r.Pool.Query(ctx, sql)
 	if err != nil {
-		return 0, fmt.Errorf("UserRepo.GetCount - r.Pool.Query: %v", err)
+		return 0, fmt.Errorf("UserRepo.GetCount - r.Pool.Query: %w", err)
 	}
 	defer rows.Close()
 	var count int
@@ -133,7 +147,7 @@ func (r *UserRepo) GetCount(ctx context.Context) (int, error) {
 		count++
 	}
 	if err != nil {
-		return 0, fmt.Errorf("UserRepo.GetCount - rows.Scan: %v", err)
+		return 0, fmt.Errorf("UserRepo.GetCount - rows.Scan: %w", err)
 	}
 	return count, nil
 }
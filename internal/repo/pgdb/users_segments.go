@@ -25,20 +25,20 @@ func NewUsersSegmentsRepo(pg *postgres.Postgres) *UsersSegmentsRepo {
 func (r *UsersSegmentsRepo) GetTransaction(ctx context.Context) (pgx.Tx, error) {
 	tx, err := r.Pool.Begin(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("UsersSegmentsRepo.AddAndRemoveSegmentsUser - r.Pool.Begin: %v", err)
+		return nil, fmt.Errorf("UsersSegmentsRepo.AddAndRemoveSegmentsUser - r.Pool.Begin: %w", err)
 	}
 
 	return tx, nil
 }
 
-func (r *UsersSegmentsRepo) getInsertSqlAddSegmentsToUser(users []int, segments []string, operation entity.Operation) (string, []interface{}, error) {
+func (r *UsersSegmentsRepo) getInsertSqlAddSegmentsToUser(users []int, segments []string, operation entity.Operation, change entity.Change) (string, []interface{}, error) {
 	builder := r.Builder.
 		Insert("users_segments_stats").
-		Columns("user_pk", "segment_pk", "created_at", "operation")
+		Columns("user_pk", "segment_pk", "created_at", "operation", "actor", "source", "reason")
 	for _, user := range users {
 		for _, segment := range segments {
 			builder = builder.
-				Values(user, segment, time.Now(), operation)
+				Values(user, segment, time.Now(), operation, change.Actor, change.Source, change.Reason)
 		}
 	}
 	return builder.ToSql()
@@ -53,168 +53,614 @@ func (r *UsersSegmentsRepo) getDeleteUsersSegmentsSql(users []int, segments []st
 	return r.Builder.
 		Delete("users_segments").
 		Where(some).
+		Suffix("RETURNING user_pk, segment_pk").
 		ToSql()
 }
 
-func getMonthStartEndDates(month int) (start string, end string) {
-	_month := time.Month(month)
-	start = time.Date(2023, _month, 1, 0, 0, 0, 0, time.Local).Format("2006-01-02")
-	end = time.Date(2023, _month+1, 1, 0, 0, 0, 0, time.Local).Format("2006-01-02")
-	return start, end
+// deleteUsersSegments removes the memberships and queues a removal event for
+// each one that existed.
+func (r *UsersSegmentsRepo) deleteUsersSegments(ctx context.Context, tx pgx.Tx, users []int, segments []string, change entity.Change, at time.Time) error {
+	sql, args, _ := r.getDeleteUsersSegmentsSql(users, segments)
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("tx.Query: %w", err)
+	}
+	var events []entity.OutboxMessage
+	for rows.Next() {
+		e := entity.MembershipEvent{
+			Operation:  entity.SEGMENT_REMOVED,
+			Actor:      change.Actor,
+			Source:     change.Source,
+			Reason:     change.Reason,
+			OccurredAt: at,
+		}
+		if err := rows.Scan(&e.User, &e.Segment); err != nil {
+			rows.Close()
+			return fmt.Errorf("rows.Scan: %w", err)
+		}
+		m, err := membershipEvent(e)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		events = append(events, m)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("rows.Err: %w", err)
+	}
+	return insertOutbox(ctx, tx, r.Builder, events...)
+}
+
+// addedEvents builds an addition event for every user and segment pair.
+func addedEvents(users []int, segments []string, change entity.Change, at time.Time) ([]entity.OutboxMessage, error) {
+	events := make([]entity.OutboxMessage, 0, len(users)*len(segments))
+	for _, user := range users {
+		for _, segment := range segments {
+			m, err := membershipEvent(entity.MembershipEvent{
+				User:       user,
+				Segment:    segment,
+				Operation:  entity.SEGMENT_ADDED,
+				Actor:      change.Actor,
+				Source:     change.Source,
+				Reason:     change.Reason,
+				OccurredAt: at,
+			})
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, m)
+		}
+	}
+	return events, nil
 }
 
+// AddAndRemoveSegmentsUser applies the change to the users in one transaction
+// and returns the segments each user sees after it with their version. The
+// segments of addList are added with expiresAt, and the memberships the users
+// already have in the segments of expireList get it as their new expiry.
 func (r *UsersSegmentsRepo) AddAndRemoveSegmentsUser(
 	ctx context.Context,
 	users []int,
 	addList []string,
-	removeList []string) error {
+	removeList []string,
+	expireList []string,
+	expiresAt *time.Time,
+	ifVersion *int64,
+	change entity.Change) (map[int]entity.UserSegments, error) {
 	tx, err := r.Pool.Begin(ctx)
 	if err != nil {
-		return fmt.Errorf("UsersSegmentsRepo.AddAndRemoveSegmentsUser - r.Pool.Begin: %v", err)
+		return nil, fmt.Errorf("UsersSegmentsRepo.AddAndRemoveSegmentsUser - r.Pool.Begin: %w", err)
 	}
 	defer func() { _ = tx.Rollback(ctx) }()
 
+	if ifVersion != nil {
+		if err = r.checkSegmentsVersion(ctx, tx, users, *ifVersion); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
 	if len(addList) != 0 {
-		sql, args, _ := r.getInsertSqlAddSegmentsToUser(users, addList, entity.SEGMENT_ADDED)
+		sql, args, _ := r.getInsertSqlAddSegmentsToUser(users, addList, entity.SEGMENT_ADDED, change)
 		if _, err = tx.Exec(ctx, sql, args...); err != nil {
-			return fmt.Errorf("UsersSegmentsRepo.AddAndRemoveSegmentsUser (add to stats) - tx.Exec: %v", err)
+			return nil, fmt.Errorf("UsersSegmentsRepo.AddAndRemoveSegmentsUser (add to stats) - tx.Exec: %w", err)
 		}
 		builder := r.Builder.
 			Insert("users_segments").
-			Columns("user_pk", "segment_pk")
+			Columns("user_pk", "segment_pk", "expires_at")
 		for _, user := range users {
 			for _, segment := range addList {
 				builder = builder.
-					Values(user, segment)
+					Values(user, segment, expiresAt)
 			}
 		}
-		sql, args, _ = builder.ToSql()
-		if _, err = tx.Exec(ctx, sql, args...); err != nil {
+		sql, args, _ = builder.
+			Suffix("ON CONFLICT DO NOTHING RETURNING user_pk, segment_pk").
+			ToSql()
+		if err = insertMemberships(ctx, tx, sql, args, users, addList); err != nil {
 			var pgErr *pgconn.PgError
 			if ok := errors.As(err, &pgErr); ok {
-				if pgErr.Code == "23505" {
-					return repoerrs.ErrAlreadyExists
-				}
 				if pgErr.Code == "23503" {
-					return repoerrs.ErrNotFound
+					return nil, repoerrs.ErrNotFound
 				}
 			}
-			return fmt.Errorf("UsersSegmentsRepo.AddAndRemoveSegmentsUser (add) - tx.Exec: %v", err)
+			return nil, fmt.Errorf("UsersSegmentsRepo.AddAndRemoveSegmentsUser (add) - %w", err)
+		}
+		events, err := addedEvents(users, addList, change, now)
+		if err != nil {
+			return nil, fmt.Errorf("UsersSegmentsRepo.AddAndRemoveSegmentsUser - addedEvents: %w", err)
+		}
+		if err = insertOutbox(ctx, tx, r.Builder, events...); err != nil {
+			return nil, fmt.Errorf("UsersSegmentsRepo.AddAndRemoveSegmentsUser - insertOutbox: %w", err)
 		}
-
 	}
 	if len(removeList) != 0 {
-		sql, args, _ := r.getInsertSqlAddSegmentsToUser(users, removeList, entity.SEGMENT_REMOVED)
+		sql, args, _ := r.getInsertSqlAddSegmentsToUser(users, removeList, entity.SEGMENT_REMOVED, change)
 		if _, err = tx.Exec(ctx, sql, args...); err != nil {
-			return fmt.Errorf("UsersSegmentsRepo.AddAndRemoveSegmentsUser (remove to stats) - tx.Exec: %v", err)
+			return nil, fmt.Errorf("UsersSegmentsRepo.AddAndRemoveSegmentsUser (remove to stats) - tx.Exec: %w", err)
 		}
 
-		sql, args, _ = r.getDeleteUsersSegmentsSql(users, removeList)
-
+		if err = r.deleteUsersSegments(ctx, tx, users, removeList, change, now); err != nil {
+			return nil, fmt.Errorf("UsersSegmentsRepo.AddAndRemoveSegmentsUser (remove) - %w", err)
+		}
+	}
+	if len(expireList) != 0 {
+		sql, args, _ := r.Builder.
+			Update("users_segments").
+			Set("expires_at", expiresAt).
+			Where(squirrel.Eq{"user_pk": users, "segment_pk": expireList}).
+			ToSql()
 		if _, err = tx.Exec(ctx, sql, args...); err != nil {
-			return fmt.Errorf("UsersSegmentsRepo.AddAndRemoveSegmentsUser (remove) - tx.Exec: %v", err)
+			return nil, fmt.Errorf("UsersSegmentsRepo.AddAndRemoveSegmentsUser (expire) - tx.Exec: %w", err)
 		}
 	}
 
+	// read in the transaction, so it is the state this change produced
+	sql, args, _ := r.userSegmentsSelect(users, true).ToSql()
+	changed, err := scanUserSegments(tx.Query(ctx, sql, args...))
+	if err != nil {
+		return nil, fmt.Errorf("UsersSegmentsRepo.AddAndRemoveSegmentsUser - %w", err)
+	}
+
 	if err = tx.Commit(ctx); err != nil {
-		return fmt.Errorf("UsersSegmentsRepo.AddAndRemoveSegmentsUser - tx.Commit: %v", err)
+		return nil, fmt.Errorf("UsersSegmentsRepo.AddAndRemoveSegmentsUser - tx.Commit: %w", err)
 	}
 
+	return changed, nil
+}
+
+// insertMemberships runs the membership insert of sql, which skips existing
+// memberships, and fails with a repoerrs.KeyError naming the segment of the
+// first one that was skipped.
+func insertMemberships(ctx context.Context, tx pgx.Tx, sql string, args []interface{}, users []int, segments []string) error {
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("tx.Query: %w", err)
+	}
+	type membership struct {
+		user    int
+		segment string
+	}
+	inserted := make(map[membership]bool, len(users)*len(segments))
+	for rows.Next() {
+		var m membership
+		if err = rows.Scan(&m.user, &m.segment); err != nil {
+			rows.Close()
+			return fmt.Errorf("rows.Scan: %w", err)
+		}
+		inserted[m] = true
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("rows.Err: %w", err)
+	}
+	for _, user := range users {
+		for _, segment := range segments {
+			m := membership{user: user, segment: segment}
+			if !inserted[m] {
+				return &repoerrs.KeyError{Key: segment, Err: repoerrs.ErrAlreadyExists}
+			}
+			delete(inserted, m)
+		}
+	}
 	return nil
 }
 
-func (r *UsersSegmentsRepo) GetUserSegments(ctx context.Context, id int) ([]string, error) {
+// checkSegmentsVersion locks the users and fails with repoerrs.ErrConflict
+// unless every one of them is still at version.
+func (r *UsersSegmentsRepo) checkSegmentsVersion(ctx context.Context, tx pgx.Tx, users []int, version int64) error {
 	sql, args, _ := r.Builder.
-		Select("segment_pk").
-		From("users_segments").
-		Where("user_pk = ?", id).
+		Select("segments_version").
+		From("users").
+		Where(squirrel.Eq{"id": users}).
+		Suffix("FOR UPDATE").
 		ToSql()
 
-	rows, err := r.Pool.Query(ctx, sql, args...)
+	rows, err := tx.Query(ctx, sql, args...)
 	if err != nil {
-		return nil, fmt.Errorf("UsersSegmentsRepo.GetUserSegments - r.Pool.Query: %v", err)
+		return fmt.Errorf("UsersSegmentsRepo.checkSegmentsVersion - tx.Query: %w", err)
 	}
 	defer rows.Close()
-	var segments []string
+	stale := false
 	for rows.Next() {
-		var s string
-		err := rows.Scan(&s)
-		if err != nil {
-			return nil, fmt.Errorf("UsersSegmentsRepo.GetUserSegments - rows.Scan: %v", err)
+		var current int64
+		if err := rows.Scan(&current); err != nil {
+			return fmt.Errorf("UsersSegmentsRepo.checkSegmentsVersion - rows.Scan: %w", err)
 		}
-		segments = append(segments, s)
+		stale = stale || current != version
 	}
-
 	if err = rows.Err(); err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, repoerrs.ErrNotFound
-		}
-		return nil, fmt.Errorf("UsersSegmentsRepo.GetUserSegments -rows.Err: %v", err)
+		return fmt.Errorf("UsersSegmentsRepo.checkSegmentsVersion - rows.Err: %w", err)
+	}
+	if stale {
+		return repoerrs.ErrConflict
+	}
+	return nil
+}
+
+// GetUserSegments returns the segments the user sees, without paused and
+// archived ones, and their version, read in one statement so they match.
+func (r *UsersSegmentsRepo) GetUserSegments(ctx context.Context, id int) ([]string, int64, error) {
+	segments, version, err := r.getUserSegments(ctx, id, true)
+	if err != nil {
+		return nil, 0, fmt.Errorf("UsersSegmentsRepo.GetUserSegments - %w", err)
 	}
+	return segments, version, nil
+}
 
-	return segments, nil
+// GetAllUserSegments is GetUserSegments including paused and archived segments.
+func (r *UsersSegmentsRepo) GetAllUserSegments(ctx context.Context, id int) ([]string, int64, error) {
+	segments, version, err := r.getUserSegments(ctx, id, false)
+	if err != nil {
+		return nil, 0, fmt.Errorf("UsersSegmentsRepo.GetAllUserSegments - %w", err)
+	}
+	return segments, version, nil
 }
 
-func (r *UsersSegmentsRepo) GetStatsPerPeriod(ctx context.Context, year int, month int) ([]entity.UsersSegmentsStats, error) {
-	month_start, month_end := getMonthStartEndDates(month)
-	sql, args, _ := r.Builder.
-		Select("*").
+func (r *UsersSegmentsRepo) getUserSegments(ctx context.Context, id int, visibleOnly bool) ([]string, int64, error) {
+	sql, args, _ := r.userSegmentsSelect([]int{id}, visibleOnly).ToSql()
+	users, err := scanUserSegments(r.Pool.Query(ctx, sql, args...))
+	if err != nil {
+		return nil, 0, err
+	}
+	user, ok := users[id]
+	if !ok {
+		return nil, 0, repoerrs.ErrNotFound
+	}
+	return user.Segments, user.Version, nil
+}
+
+// userSegmentsSelect selects the id, segments version and segments of the
+// users in one statement, so the version matches the segments. Paused and
+// archived segments are left out when visibleOnly.
+func (r *UsersSegmentsRepo) userSegmentsSelect(users []int, visibleOnly bool) squirrel.SelectBuilder {
+	join := "users_segments us ON us.user_pk = u.id"
+	var joinArgs []interface{}
+	if visibleOnly {
+		join += " AND us.segment_pk IN (SELECT slug FROM segments WHERE status NOT IN (?, ?))"
+		joinArgs = append(joinArgs, entity.SEGMENT_PAUSED, entity.SEGMENT_ARCHIVED)
+	}
+	return r.Builder.
+		Select("u.id", "u.segments_version", "array_agg(us.segment_pk ORDER BY us.segment_pk) FILTER (WHERE us.segment_pk IS NOT NULL)").
+		From("users u").
+		LeftJoin(join, joinArgs...).
+		Where(squirrel.Eq{"u.id": users}).
+		GroupBy("u.id")
+}
+
+func scanUserSegments(rows pgx.Rows, err error) (map[int]entity.UserSegments, error) {
+	if err != nil {
+		return nil, fmt.Errorf("Query: %w", err)
+	}
+	defer rows.Close()
+	users := make(map[int]entity.UserSegments)
+	for rows.Next() {
+		var id int
+		var user entity.UserSegments
+		if err := rows.Scan(&id, &user.Version, &user.Segments); err != nil {
+			return nil, fmt.Errorf("rows.Scan: %w", err)
+		}
+		users[id] = user
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows.Err: %w", err)
+	}
+	return users, nil
+}
+
+func (r *UsersSegmentsRepo) GetStats(ctx context.Context, filter entity.StatsFilter) ([]entity.UsersSegmentsStats, error) {
+	builder := r.Builder.
+		Select("id", "user_pk", "segment_pk", "created_at", "operation", "actor", "source", "reason").
 		From("users_segments_stats").
-		Where("created_at >= ? AND created_at < ?", month_start, month_end).
-		ToSql()
+		OrderBy("id")
+	if !filter.From.IsZero() {
+		builder = builder.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		builder = builder.Where("created_at < ?", filter.To)
+	}
+	if filter.User > 0 {
+		builder = builder.Where("user_pk = ?", filter.User)
+	}
+	if filter.Segment != "" {
+		builder = builder.Where("segment_pk = ?", filter.Segment)
+	}
+	if filter.Operation != "" {
+		builder = builder.Where("operation = ?", filter.Operation)
+	}
+	if filter.Actor != "" {
+		builder = builder.Where("actor = ?", filter.Actor)
+	}
+	if filter.Source != "" {
+		builder = builder.Where("source = ?", filter.Source)
+	}
+	if filter.Teams != nil {
+		builder = builder.Where("segment_pk IN (SELECT slug FROM segments WHERE team = ANY(?))", filter.Teams)
+	}
+	if filter.After > 0 {
+		builder = builder.Where("id > ?", filter.After)
+	}
+	if filter.Limit > 0 {
+		builder = builder.Limit(uint64(filter.Limit))
+	}
+	sql, args, _ := builder.ToSql()
 
 	rows, err := r.Pool.Query(ctx, sql, args...)
 	if err != nil {
-		return nil, fmt.Errorf("UsersSegmentsRepo.GetUserSegments - r.Pool.Query: %v", err)
+		return nil, fmt.Errorf("UsersSegmentsRepo.GetStats - r.Pool.Query: %w", err)
 	}
 	defer rows.Close()
 
-	var segments []entity.UsersSegmentsStats
+	stats := []entity.UsersSegmentsStats{}
 	for rows.Next() {
 		var s entity.UsersSegmentsStats
 		err := rows.Scan(
+			&s.Id,
 			&s.User,
 			&s.Segment,
 			&s.Created_at,
 			&s.Operation,
+			&s.Actor,
+			&s.Source,
+			&s.Reason,
 		)
 		if err != nil {
-			return nil, fmt.Errorf("UsersSegmentsRepo.GetUserSegments - rows.Scan: %v", err)
+			return nil, fmt.Errorf("UsersSegmentsRepo.GetStats - rows.Scan: %w", err)
 		}
-		segments = append(segments, s)
+		stats = append(stats, s)
 	}
 
 	if err = rows.Err(); err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, repoerrs.ErrNotFound
+		return nil, fmt.Errorf("UsersSegmentsRepo.GetStats - rows.Err: %w", err)
+	}
+
+	return stats, nil
+}
+
+// AddSegmentByPercent stores percent as the segment rollout and adds the segment to
+// every user whose bucket for the segment salt falls below percent of entity.BUCKETS.
+// Buckets are stable, so the same percent always selects the same users and a bigger
+// one only adds users. Existing memberships are kept. A segment that is missing or
+// not active fails with repoerrs.ErrConflict.
+func (r *UsersSegmentsRepo) AddSegmentByPercent(ctx context.Context, segment string, percent int, expiresAt *time.Time, change entity.Change) (int, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("UsersSegmentsRepo.AddSegmentByPercent - r.Pool.Begin: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	added, err := rollout(ctx, tx, r.Builder, segment, percent, expiresAt, change)
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrConflict) {
+			return 0, err
 		}
-		return nil, fmt.Errorf("UsersSegmentsRepo.GetUserSegments -rows.Err: %v", err)
+		return 0, fmt.Errorf("UsersSegmentsRepo.AddSegmentByPercent - %w", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("UsersSegmentsRepo.AddSegmentByPercent - tx.Commit: %w", err)
+	}
+	return added, nil
+}
+
+// rollout is AddSegmentByPercent within tx, so segments can be created and
+// rolled out at once. Only active segments are rolled out, others fail with
+// repoerrs.ErrConflict.
+func rollout(ctx context.Context, tx pgx.Tx, builder squirrel.StatementBuilderType, segment string, percent int, expiresAt *time.Time, change entity.Change) (int, error) {
+	sql, args, _ := builder.
+		Update("segments").
+		Set("rollout_percentage", percent).
+		Set("rollout_expires_at", expiresAt).
+		Where("slug = ? AND status = ?", segment, entity.SEGMENT_ACTIVE).
+		ToSql()
+	tag, err := tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return 0, fmt.Errorf("rollout (rollout) - tx.Exec: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return 0, repoerrs.ErrConflict
 	}
 
-	return segments, nil
+	placeholders := changeArgs{actor: "$6", source: "$7", reason: "$8"}
+	sql = `WITH added AS (
+		INSERT INTO users_segments (user_pk, segment_pk, expires_at)
+		SELECT u.id, s.slug, $3::timestamptz
+		FROM users u JOIN segments s ON s.slug = $1
+		WHERE user_bucket(s.salt, u.id) < $2
+		ON CONFLICT (user_pk, segment_pk) DO NOTHING
+		RETURNING user_pk, segment_pk
+	), stats AS (
+		` + statsSql("added", "$5", "$4", placeholders) + `
+	)
+	` + membershipEventsSql("added", "$5", "$4", placeholders)
 
+	threshold := percent * entity.BUCKETS / 100
+	tag, err = tx.Exec(ctx, sql, segment, threshold, expiresAt, time.Now(), string(entity.SEGMENT_ADDED),
+		change.Actor, string(change.Source), change.Reason)
+	if err != nil {
+		return 0, fmt.Errorf("rollout (add) - tx.Exec: %w", err)
+	}
+	return int(tag.RowsAffected()), nil
 }
-func (r *UsersSegmentsRepo) DeleteSegmentFromUser(ctx context.Context, users []int, segments []string) error {
+
+// enrollUser adds the user to every active segment with an unexpired rollout whose
+// bucket threshold the user falls under, as if the user had existed when the
+// rollout was made.
+func enrollUser(ctx context.Context, tx pgx.Tx, user int, change entity.Change) (int, error) {
+	placeholders := changeArgs{actor: "$6", source: "$7", reason: "$8"}
+	sql := `WITH added AS (
+		INSERT INTO users_segments (user_pk, segment_pk, expires_at)
+		SELECT $1::int, s.slug, s.rollout_expires_at
+		FROM segments s
+		WHERE s.rollout_percentage > 0
+		  AND s.status = $5
+		  AND (s.rollout_expires_at IS NULL OR s.rollout_expires_at > now())
+		  AND user_bucket(s.salt, $1::int) < s.rollout_percentage * $2::int
+		ON CONFLICT (user_pk, segment_pk) DO NOTHING
+		RETURNING user_pk, segment_pk
+	), stats AS (
+		` + statsSql("added", "$4", "$3", placeholders) + `
+	)
+	` + membershipEventsSql("added", "$4", "$3", placeholders)
+
+	tag, err := tx.Exec(ctx, sql, user, entity.BUCKETS/100, time.Now(), string(entity.SEGMENT_ADDED), string(entity.SEGMENT_ACTIVE),
+		change.Actor, string(change.Source), change.Reason)
+	if err != nil {
+		return 0, fmt.Errorf("enrollUser - tx.Exec: %w", err)
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+func (r *UsersSegmentsRepo) GetSegmentMembers(ctx context.Context, filter entity.MembersFilter) ([]entity.UsersSegments, error) {
+	builder := r.Builder.
+		Select("user_pk", "segment_pk", "assigned_at", "expires_at").
+		From("users_segments").
+		Where("segment_pk = ?", filter.Segment).
+		OrderBy("user_pk").
+		Limit(uint64(filter.Limit))
+	if filter.After > 0 {
+		builder = builder.Where("user_pk > ?", filter.After)
+	}
+	if !filter.AssignedFrom.IsZero() {
+		builder = builder.Where("assigned_at >= ?", filter.AssignedFrom)
+	}
+	if !filter.AssignedTo.IsZero() {
+		builder = builder.Where("assigned_at < ?", filter.AssignedTo)
+	}
+	if !filter.ExpiresAfter.IsZero() {
+		builder = builder.Where("expires_at >= ?", filter.ExpiresAfter)
+	}
+	if !filter.ExpiresBefore.IsZero() {
+		builder = builder.Where("expires_at < ?", filter.ExpiresBefore)
+	}
+	if filter.HasExpiry != nil {
+		if *filter.HasExpiry {
+			builder = builder.Where("expires_at IS NOT NULL")
+		} else {
+			builder = builder.Where("expires_at IS NULL")
+		}
+	}
+	sql, args, _ := builder.ToSql()
+
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("UsersSegmentsRepo.GetSegmentMembers - r.Pool.Query: %w", err)
+	}
+	defer rows.Close()
+
+	members := []entity.UsersSegments{}
+	for rows.Next() {
+		var m entity.UsersSegments
+		if err := rows.Scan(&m.User, &m.Segment, &m.AssignedAt, &m.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("UsersSegmentsRepo.GetSegmentMembers - rows.Scan: %w", err)
+		}
+		members = append(members, m)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("UsersSegmentsRepo.GetSegmentMembers - rows.Err: %w", err)
+	}
+	return members, nil
+}
+
+// CountMembers returns the number of users in each of the given segments.
+// Segments without members are missing from the result.
+func (r *UsersSegmentsRepo) CountMembers(ctx context.Context, segments []string) (map[string]int, error) {
+	sql, args, _ := r.Builder.
+		Select("segment_pk", "count(*)").
+		From("users_segments").
+		Where(squirrel.Eq{"segment_pk": segments}).
+		GroupBy("segment_pk").
+		ToSql()
+
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("UsersSegmentsRepo.CountMembers - r.Pool.Query: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int, len(segments))
+	for rows.Next() {
+		var (
+			segment string
+			count   int
+		)
+		if err := rows.Scan(&segment, &count); err != nil {
+			return nil, fmt.Errorf("UsersSegmentsRepo.CountMembers - rows.Scan: %w", err)
+		}
+		counts[segment] = count
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("UsersSegmentsRepo.CountMembers - rows.Err: %w", err)
+	}
+	return counts, nil
+}
+
+// DeleteExpired removes up to limit memberships whose expires_at is not after now
+// and records a segment_removed stats row and event for each of them in the same
+// transaction.
+// Rows locked by a concurrent sweeper are skipped.
+func (r *UsersSegmentsRepo) DeleteExpired(ctx context.Context, now time.Time, limit int, change entity.Change) (int, error) {
+	placeholders := changeArgs{actor: "$5", source: "$6", reason: "$7"}
+	sql := `WITH expired AS (
+		DELETE FROM users_segments
+		WHERE (user_pk, segment_pk) IN (
+			SELECT user_pk, segment_pk FROM users_segments
+			WHERE expires_at <= $1
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING user_pk, segment_pk
+	), stats AS (
+		` + statsSql("expired", "$4", "$3", placeholders) + `
+	)
+	` + membershipEventsSql("expired", "$4", "$3", placeholders)
+
+	tag, err := r.Pool.Exec(ctx, sql, now, limit, time.Now(), string(entity.SEGMENT_REMOVED),
+		change.Actor, string(change.Source), change.Reason)
+	if err != nil {
+		return 0, fmt.Errorf("UsersSegmentsRepo.DeleteExpired - r.Pool.Exec: %w", err)
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// SetExpiresAt brings the expiry of the memberships of the users in the
+// segments forward to expiresAt; an earlier expiry is kept. It returns the
+// number of memberships changed.
+func (r *UsersSegmentsRepo) SetExpiresAt(ctx context.Context, users []int, segments []string, expiresAt time.Time) (int, error) {
+	sql, args, _ := r.Builder.
+		Update("users_segments").
+		Set("expires_at", expiresAt).
+		Where(squirrel.Eq{"user_pk": users, "segment_pk": segments}).
+		Where("(expires_at IS NULL OR expires_at > ?)", expiresAt).
+		ToSql()
+
+	tag, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return 0, fmt.Errorf("UsersSegmentsRepo.SetExpiresAt - r.Pool.Exec: %w", err)
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+func (r *UsersSegmentsRepo) DeleteSegmentFromUser(ctx context.Context, users []int, segments []string, change entity.Change) error {
 	tx, err := r.Pool.Begin(ctx)
 	if err != nil {
-		return fmt.Errorf("UsersSegmentsRepo.DeleteSegmentFromUser - r.Pool.Begin: %v", err)
+		return fmt.Errorf("UsersSegmentsRepo.DeleteSegmentFromUser - r.Pool.Begin: %w", err)
 	}
 	defer func() { _ = tx.Rollback(ctx) }()
 
 	if len(users) != 0 && len(segments) != 0 {
-		sql, args, _ := r.getInsertSqlAddSegmentsToUser(users, segments, entity.SEGMENT_REMOVED)
+		sql, args, _ := r.getInsertSqlAddSegmentsToUser(users, segments, entity.SEGMENT_REMOVED, change)
 		if _, err = tx.Exec(ctx, sql, args...); err != nil {
-			return fmt.Errorf("UsersSegmentsRepo.DeleteSegmentFromUser (remove to stats) - tx.Exec: %v", err)
+			return fmt.Errorf("UsersSegmentsRepo.DeleteSegmentFromUser (remove to stats) - tx.Exec: %w", err)
 		}
 
-		sql, args, _ = r.getDeleteUsersSegmentsSql(users, segments)
-
-		if _, err = tx.Exec(ctx, sql, args...); err != nil {
-			return fmt.Errorf("UsersSegmentsRepo.DeleteSegmentFromUser (remove) - tx.Exec: %v", err)
+		if err = r.deleteUsersSegments(ctx, tx, users, segments, change, time.Now()); err != nil {
+			return fmt.Errorf("UsersSegmentsRepo.DeleteSegmentFromUser (remove) - %w", err)
 		}
 	}
 	if err = tx.Commit(ctx); err != nil {
-		return fmt.Errorf("UsersSegmentsRepo.DeleteSegmentFromUser - tx.Commit: %v", err)
+		return fmt.Errorf("UsersSegmentsRepo.DeleteSegmentFromUser - tx.Commit: %w", err)
 	}
 
 	return nil
@@ -0,0 +1,219 @@
+package pgdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/repo/repoerrs"
+	"github.com/ABDURAZZAKK/avito_experiment/pkg/postgres"
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+)
+
+type WebhookRepo struct {
+	*postgres.Postgres
+}
+
+func NewWebhookRepo(pg *postgres.Postgres) *WebhookRepo {
+	return &WebhookRepo{pg}
+}
+
+func (r *WebhookRepo) Create(ctx context.Context, webhook entity.Webhook) (int64, error) {
+	sql, args, _ := r.Builder.
+		Insert("webhooks").
+		Columns("url", "secret", "segments").
+		Values(webhook.URL, webhook.Secret, nonNilTags(webhook.Segments)).
+		Suffix("RETURNING id").
+		ToSql()
+
+	var id int64
+	if err := r.Pool.QueryRow(ctx, sql, args...).Scan(&id); err != nil {
+		return 0, fmt.Errorf("WebhookRepo.Create - r.Pool.QueryRow: %w", err)
+	}
+	return id, nil
+}
+
+func (r *WebhookRepo) GetById(ctx context.Context, id int64) (entity.Webhook, error) {
+	sql, args, _ := r.Builder.
+		Select("id", "url", "secret", "segments", "created_at").
+		From("webhooks").
+		Where("id = ?", id).
+		ToSql()
+
+	var w entity.Webhook
+	err := r.Pool.QueryRow(ctx, sql, args...).Scan(&w.Id, &w.URL, &w.Secret, &w.Segments, &w.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return entity.Webhook{}, repoerrs.ErrNotFound
+		}
+		return entity.Webhook{}, fmt.Errorf("WebhookRepo.GetById - r.Pool.QueryRow: %w", err)
+	}
+	return w, nil
+}
+
+func (r *WebhookRepo) List(ctx context.Context) ([]entity.Webhook, error) {
+	sql, args, _ := r.Builder.
+		Select("id", "url", "secret", "segments", "created_at").
+		From("webhooks").
+		OrderBy("id").
+		ToSql()
+
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("WebhookRepo.List - r.Pool.Query: %w", err)
+	}
+	defer rows.Close()
+
+	webhooks := []entity.Webhook{}
+	for rows.Next() {
+		var w entity.Webhook
+		if err := rows.Scan(&w.Id, &w.URL, &w.Secret, &w.Segments, &w.CreatedAt); err != nil {
+			return nil, fmt.Errorf("WebhookRepo.List - rows.Scan: %w", err)
+		}
+		webhooks = append(webhooks, w)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("WebhookRepo.List - rows.Err: %w", err)
+	}
+	return webhooks, nil
+}
+
+// Delete removes the webhook together with its delivery log.
+func (r *WebhookRepo) Delete(ctx context.Context, id int64) error {
+	sql, args, _ := r.Builder.
+		Delete("webhooks").
+		Where("id = ?", id).
+		ToSql()
+
+	tag, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("WebhookRepo.Delete - r.Pool.Exec: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return repoerrs.ErrNotFound
+	}
+	return nil
+}
+
+func (r *WebhookRepo) GetDeliveries(ctx context.Context, filter entity.WebhookDeliveryFilter) ([]entity.WebhookDelivery, error) {
+	builder := r.Builder.
+		Select("id", "webhook_id", "payload", "status", "attempts", "response_status",
+			"last_error", "created_at", "next_attempt_at", "delivered_at").
+		From("webhook_deliveries").
+		Where("webhook_id = ?", filter.WebhookId).
+		OrderBy("id").
+		Limit(uint64(filter.Limit))
+	if filter.Status != "" {
+		builder = builder.Where("status = ?", filter.Status)
+	}
+	if filter.After > 0 {
+		builder = builder.Where("id > ?", filter.After)
+	}
+	sql, args, _ := builder.ToSql()
+
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("WebhookRepo.GetDeliveries - r.Pool.Query: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := []entity.WebhookDelivery{}
+	for rows.Next() {
+		var d entity.WebhookDelivery
+		err := rows.Scan(
+			&d.Id,
+			&d.WebhookId,
+			&d.Payload,
+			&d.Status,
+			&d.Attempts,
+			&d.ResponseStatus,
+			&d.LastError,
+			&d.CreatedAt,
+			&d.NextAttemptAt,
+			&d.DeliveredAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("WebhookRepo.GetDeliveries - rows.Scan: %w", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("WebhookRepo.GetDeliveries - rows.Err: %w", err)
+	}
+	return deliveries, nil
+}
+
+// ClaimDeliveries takes up to limit due pending deliveries and hides them from
+// other dispatchers for lease, long enough to send them and record the result.
+// A dispatcher that dies in between only delays the deliveries by lease.
+func (r *WebhookRepo) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]entity.WebhookDelivery, error) {
+	sql := `UPDATE webhook_deliveries d
+	SET next_attempt_at = now() + make_interval(secs => $2::float8)
+	FROM webhooks w
+	WHERE w.id = d.webhook_id AND d.id IN (
+		SELECT id FROM webhook_deliveries
+		WHERE status = $3 AND next_attempt_at <= now()
+		ORDER BY id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING d.id, d.webhook_id, w.url, w.secret, d.payload, d.attempts, d.created_at`
+
+	rows, err := r.Pool.Query(ctx, sql, limit, lease.Seconds(), string(entity.WEBHOOK_PENDING))
+	if err != nil {
+		return nil, fmt.Errorf("WebhookRepo.ClaimDeliveries - r.Pool.Query: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []entity.WebhookDelivery
+	for rows.Next() {
+		d := entity.WebhookDelivery{Status: entity.WEBHOOK_PENDING}
+		if err := rows.Scan(&d.Id, &d.WebhookId, &d.URL, &d.Secret, &d.Payload, &d.Attempts, &d.CreatedAt); err != nil {
+			return nil, fmt.Errorf("WebhookRepo.ClaimDeliveries - rows.Scan: %w", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("WebhookRepo.ClaimDeliveries - rows.Err: %w", err)
+	}
+	return deliveries, nil
+}
+
+func (r *WebhookRepo) MarkDelivered(ctx context.Context, id int64, responseStatus int) error {
+	sql, args, _ := r.Builder.
+		Update("webhook_deliveries").
+		Set("status", entity.WEBHOOK_SUCCEEDED).
+		Set("attempts", squirrel.Expr("attempts + 1")).
+		Set("response_status", responseStatus).
+		Set("last_error", "").
+		Set("delivered_at", squirrel.Expr("now()")).
+		Where("id = ?", id).
+		ToSql()
+
+	if _, err := r.Pool.Exec(ctx, sql, args...); err != nil {
+		return fmt.Errorf("WebhookRepo.MarkDelivered - r.Pool.Exec: %w", err)
+	}
+	return nil
+}
+
+// MarkFailed records a failed attempt. The delivery is retried with an
+// exponential backoff capped at maxBackoff and given up after maxAttempts.
+func (r *WebhookRepo) MarkFailed(ctx context.Context, id int64, responseStatus *int, reason string, maxAttempts int, maxBackoff time.Duration) error {
+	sql, args, _ := r.Builder.
+		Update("webhook_deliveries").
+		Set("status", squirrel.Expr("CASE WHEN attempts + 1 >= ? THEN ? ELSE status END", maxAttempts, string(entity.WEBHOOK_FAILED))).
+		Set("attempts", squirrel.Expr("attempts + 1")).
+		Set("response_status", responseStatus).
+		Set("last_error", reason).
+		Set("next_attempt_at", squirrel.Expr("now() + make_interval(secs => LEAST(power(2, attempts), ?::float8))", maxBackoff.Seconds())).
+		Where("id = ?", id).
+		ToSql()
+
+	if _, err := r.Pool.Exec(ctx, sql, args...); err != nil {
+		return fmt.Errorf("WebhookRepo.MarkFailed - r.Pool.Exec: %w", err)
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package repo
 
 import (
 	"context"
+	"time"
 
 	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
 	"github.com/ABDURAZZAKK/avito_experiment/internal/repo/pgdb"
@@ -9,37 +10,113 @@ import (
 )
 
 type User interface {
-	Create(ctx context.Context, slug string) (int, error)
+	Create(ctx context.Context, slug string, change entity.Change) (int, error)
 	GetById(ctx context.Context, id int) (entity.User, error)
-	GetRandomIDs(ctx context.Context, limit int) ([]int, error)
 	GetCount(ctx context.Context) (int, error)
-	Delete(ctx context.Context, id int) (int, error)
+	Delete(ctx context.Context, id int, change entity.Change) (int, error)
 }
 
 type Segment interface {
 	GetBySlug(ctx context.Context, slug string) (entity.Segment, error)
-	Create(ctx context.Context, slug string) (string, error)
-	CreateAll(ctx context.Context, slugs []string) error
+	List(ctx context.Context, filter entity.SegmentFilter) ([]entity.Segment, error)
+	Create(ctx context.Context, segment entity.Segment, change entity.Change) (string, error)
+	CreateAll(ctx context.Context, slugs []string, team string, percent int, expiresAt *time.Time, change entity.Change) error
+	GetTeams(ctx context.Context, slugs []string) (map[string]string, error)
+	Update(ctx context.Context, segment entity.Segment) (entity.Segment, error)
 	Delete(ctx context.Context, slug string) (string, error)
 }
 
 type UsersSegments interface {
-	AddAndRemoveSegmentsUser(ctx context.Context, users []int, addList []string, removeList []string) error
-	GetUserSegments(ctx context.Context, id int) ([]string, error)
-	GetStatsPerPeriod(ctx context.Context, year int, month int) ([]entity.UsersSegmentsStats, error)
-	DeleteSegmentFromUser(ctx context.Context, users []int, segments []string) error
+	AddAndRemoveSegmentsUser(ctx context.Context, users []int, addList []string, removeList []string, expireList []string, expiresAt *time.Time, ifVersion *int64, change entity.Change) (map[int]entity.UserSegments, error)
+	AddSegmentByPercent(ctx context.Context, segment string, percent int, expiresAt *time.Time, change entity.Change) (int, error)
+	GetUserSegments(ctx context.Context, id int) ([]string, int64, error)
+	GetAllUserSegments(ctx context.Context, id int) ([]string, int64, error)
+	GetSegmentMembers(ctx context.Context, filter entity.MembersFilter) ([]entity.UsersSegments, error)
+	CountMembers(ctx context.Context, segments []string) (map[string]int, error)
+	GetStats(ctx context.Context, filter entity.StatsFilter) ([]entity.UsersSegmentsStats, error)
+	DeleteSegmentFromUser(ctx context.Context, users []int, segments []string, change entity.Change) error
+	SetExpiresAt(ctx context.Context, users []int, segments []string, expiresAt time.Time) (int, error)
+	DeleteExpired(ctx context.Context, now time.Time, limit int, change entity.Change) (int, error)
+}
+
+type ExportJob interface {
+	NextId(ctx context.Context) (int64, error)
+	Create(ctx context.Context, job entity.ExportJob, task entity.OutboxMessage, maxPerDay int) error
+	GetById(ctx context.Context, id int64) (entity.ExportJob, error)
+	MarkRunning(ctx context.Context, id int64) error
+	MarkSucceeded(ctx context.Context, id int64, filename string, rowCount int) error
+	MarkRetrying(ctx context.Context, id int64, reason string) error
+	MarkFailed(ctx context.Context, id int64, reason string) error
+}
+
+type Outbox interface {
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]entity.OutboxMessage, error)
+	MarkSent(ctx context.Context, id int64) error
+	MarkFailed(ctx context.Context, id int64, reason string, maxBackoff time.Duration) error
+}
+
+type RejectedMessage interface {
+	Create(ctx context.Context, msg entity.RejectedMessage) (int64, error)
+}
+
+type Webhook interface {
+	Create(ctx context.Context, webhook entity.Webhook) (int64, error)
+	GetById(ctx context.Context, id int64) (entity.Webhook, error)
+	List(ctx context.Context) ([]entity.Webhook, error)
+	Delete(ctx context.Context, id int64) error
+	GetDeliveries(ctx context.Context, filter entity.WebhookDeliveryFilter) ([]entity.WebhookDelivery, error)
+	ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]entity.WebhookDelivery, error)
+	MarkDelivered(ctx context.Context, id int64, responseStatus int) error
+	MarkFailed(ctx context.Context, id int64, responseStatus *int, reason string, maxAttempts int, maxBackoff time.Duration) error
+}
+
+type IdempotencyKey interface {
+	Reserve(ctx context.Context, apiKeyId int64, key string, requestHash string, expiredBefore time.Time, staleBefore time.Time) (entity.IdempotencyKey, bool, error)
+	Complete(ctx context.Context, apiKeyId int64, key string, startedAt time.Time, status int, contentType string, headers map[string]string, body []byte) error
+	Delete(ctx context.Context, apiKeyId int64, key string, startedAt time.Time) error
+	DeleteExpired(ctx context.Context, expiredBefore time.Time, limit int) (int, error)
+}
+
+type ApiKey interface {
+	Create(ctx context.Context, key entity.ApiKey) (int64, error)
+	GetById(ctx context.Context, id int64) (entity.ApiKey, error)
+	GetByHash(ctx context.Context, hash string) (entity.ApiKey, error)
+	List(ctx context.Context) ([]entity.ApiKey, error)
+	Revoke(ctx context.Context, id int64, at time.Time) error
+	Touch(ctx context.Context, id int64, at time.Time) error
+	SetRole(ctx context.Context, id int64, team string, role entity.Role) error
+	DeleteRole(ctx context.Context, id int64, team string) error
+}
+
+type Audit interface {
+	Create(ctx context.Context, entry entity.AuditEntry) error
+	List(ctx context.Context, filter entity.AuditFilter) ([]entity.AuditEntry, error)
 }
 
 type Repositories struct {
 	User
 	Segment
 	UsersSegments
+	ExportJob
+	Outbox
+	RejectedMessage
+	Webhook
+	IdempotencyKey
+	ApiKey
+	Audit
 }
 
 func NewRepositories(pg *postgres.Postgres) *Repositories {
 	return &Repositories{
-		User:          pgdb.NewUserRepo(pg),
-		Segment:       pgdb.NewSegmentRepo(pg),
-		UsersSegments: pgdb.NewUsersSegmentsRepo(pg),
+		User:            pgdb.NewUserRepo(pg),
+		Segment:         pgdb.NewSegmentRepo(pg),
+		UsersSegments:   pgdb.NewUsersSegmentsRepo(pg),
+		ExportJob:       pgdb.NewExportJobRepo(pg),
+		Outbox:          pgdb.NewOutboxRepo(pg),
+		RejectedMessage: pgdb.NewRejectedMessageRepo(pg),
+		Webhook:         pgdb.NewWebhookRepo(pg),
+		IdempotencyKey:  pgdb.NewIdempotencyKeyRepo(pg),
+		ApiKey:          pgdb.NewApiKeyRepo(pg),
+		Audit:           pgdb.NewAuditRepo(pg),
 	}
 }
@@ -1,10 +1,30 @@
 package repoerrs
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	ErrNotFound      = errors.New("not found")
 	ErrAlreadyExists = errors.New("already exists")
+	ErrConflict      = errors.New("version conflict")
+	ErrLimitExceeded = errors.New("limit exceeded")
 
 	ErrNotEnoughBalance = errors.New("not enough balance")
 )
+
+// KeyError names the key a constraint failed on, such as the slug of a
+// segment that already exists.
+type KeyError struct {
+	Key string
+	Err error
+}
+
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Key, e.Err)
+}
+
+func (e *KeyError) Unwrap() error {
+	return e.Err
+}
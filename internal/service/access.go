@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ABDURAZZAKK/avito_experiment/internal/actor"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/repo"
+	log "github.com/sirupsen/logrus"
+)
+
+// accessControl checks the team roles of the API key a request is made with.
+// Requests without a key are denied, and every denial is written to the
+// audit log.
+type accessControl struct {
+	auditRepo repo.Audit
+}
+
+// authorize fails with ErrForbidden unless the key of ctx has at least role in
+// team. resource is what the action is made on, e.g. a segment.
+func (a accessControl) authorize(ctx context.Context, action, resource, team string, role entity.Role) error {
+	key, ok := actor.Key(ctx)
+	if ok && key.RoleIn(team).Allows(role) {
+		return nil
+	}
+
+	reason := fmt.Sprintf("requires the %s role in team %q", role, team)
+	if !ok {
+		reason = "no api key"
+	}
+	err := a.auditRepo.Create(context.WithoutCancel(ctx), entity.AuditEntry{
+		Actor:    actor.From(ctx),
+		Action:   action,
+		Resource: resource,
+		Team:     team,
+		Outcome:  entity.AUDIT_DENIED,
+		Reason:   reason,
+	})
+	if err != nil {
+		log.Errorf("service - accessControl.authorize - auditRepo.Create: %v", err)
+	}
+	return ErrForbidden
+}
+
+// teamsOf returns the teams the key of ctx has at least role in, nil when it
+// has role in every team. Without a key the list is empty.
+func teamsOf(ctx context.Context, role entity.Role) []string {
+	key, ok := actor.Key(ctx)
+	if !ok {
+		return []string{}
+	}
+	if key.RoleIn(entity.ALL_TEAMS).Allows(role) {
+		return nil
+	}
+	teams := []string{}
+	for team, r := range key.Roles {
+		if r.Allows(role) {
+			teams = append(teams, team)
+		}
+	}
+	return teams
+}
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/repo"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/repo/repoerrs"
+)
+
+const (
+	// API_KEY_PREFIX starts every key, so leaked keys are easy to search for.
+	API_KEY_PREFIX = "sk_"
+	// API_KEY_TOUCH_INTERVAL limits how often last_used_at is written.
+	API_KEY_TOUCH_INTERVAL = time.Minute
+
+	apiKeyDisplayLength = 10
+)
+
+// ApiKeyService issues and manages API keys. Granting or taking away a role
+// in a team takes the admin role in that team, so a key cannot hand out more
+// than it has.
+type ApiKeyService struct {
+	apiKeyRepo repo.ApiKey
+	access     accessControl
+}
+
+func NewApiKeyService(apiKeyRepo repo.ApiKey, auditRepo repo.Audit) *ApiKeyService {
+	return &ApiKeyService{
+		apiKeyRepo: apiKeyRepo,
+		access:     accessControl{auditRepo: auditRepo},
+	}
+}
+
+type ApiKeyIssueInput struct {
+	Name   string
+	Scopes []entity.Scope
+	// Roles maps teams to the role of the key in them.
+	Roles map[string]entity.Role
+}
+
+type ApiKeyIssueOutput struct {
+	Key entity.ApiKey
+	// Token is the key itself. It is not stored and cannot be shown again.
+	Token string
+}
+
+// Issue creates a key with the scopes and roles and returns it with its token.
+// It takes the admin role in every team the key gets a role in.
+func (s *ApiKeyService) Issue(ctx context.Context, input ApiKeyIssueInput) (ApiKeyIssueOutput, error) {
+	name := strings.TrimSpace(input.Name)
+	if name == "" {
+		return ApiKeyIssueOutput{}, ErrInvalidApiKeyName
+	}
+	for _, scope := range input.Scopes {
+		if !scope.Valid() {
+			return ApiKeyIssueOutput{}, ErrInvalidScope
+		}
+	}
+	for team, role := range input.Roles {
+		if err := validateRole(team, role); err != nil {
+			return ApiKeyIssueOutput{}, err
+		}
+	}
+	var denied error
+	for team := range input.Roles {
+		if err := s.access.authorize(ctx, entity.ACTION_KEY_ISSUE, name, team, entity.ROLE_ADMIN); err != nil {
+			denied = err
+		}
+	}
+	if denied != nil {
+		return ApiKeyIssueOutput{}, denied
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return ApiKeyIssueOutput{}, fmt.Errorf("ApiKeyService.Issue - rand.Read: %w", err)
+	}
+	token := API_KEY_PREFIX + base64.RawURLEncoding.EncodeToString(secret)
+	id, err := s.apiKeyRepo.Create(ctx, entity.ApiKey{
+		Name:   name,
+		Prefix: token[:apiKeyDisplayLength],
+		Hash:   hashApiKey(token),
+		Scopes: input.Scopes,
+		Roles:  input.Roles,
+	})
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrAlreadyExists) {
+			return ApiKeyIssueOutput{}, ErrApiKeyAlreadyExists
+		}
+		return ApiKeyIssueOutput{}, fmt.Errorf("ApiKeyService.Issue - apiKeyRepo.Create: %w", err)
+	}
+	key, err := s.apiKeyRepo.GetById(ctx, id)
+	if err != nil {
+		return ApiKeyIssueOutput{}, fmt.Errorf("ApiKeyService.Issue - apiKeyRepo.GetById: %w", err)
+	}
+	return ApiKeyIssueOutput{Key: key, Token: token}, nil
+}
+
+// Authenticate returns the key of token, ErrInvalidApiKey when there is no such
+// key or it was revoked.
+func (s *ApiKeyService) Authenticate(ctx context.Context, token string) (entity.ApiKey, error) {
+	if !strings.HasPrefix(token, API_KEY_PREFIX) {
+		return entity.ApiKey{}, ErrInvalidApiKey
+	}
+	key, err := s.apiKeyRepo.GetByHash(ctx, hashApiKey(token))
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrNotFound) {
+			return entity.ApiKey{}, ErrInvalidApiKey
+		}
+		return entity.ApiKey{}, fmt.Errorf("ApiKeyService.Authenticate - apiKeyRepo.GetByHash: %w", err)
+	}
+	if key.RevokedAt != nil {
+		return entity.ApiKey{}, ErrInvalidApiKey
+	}
+
+	now := time.Now()
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > API_KEY_TOUCH_INTERVAL {
+		if err = s.apiKeyRepo.Touch(ctx, key.Id, now); err != nil {
+			return entity.ApiKey{}, fmt.Errorf("ApiKeyService.Authenticate - apiKeyRepo.Touch: %w", err)
+		}
+		key.LastUsedAt = &now
+	}
+	return key, nil
+}
+
+func (s *ApiKeyService) List(ctx context.Context) ([]entity.ApiKey, error) {
+	keys, err := s.apiKeyRepo.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("ApiKeyService.List - apiKeyRepo.List: %w", err)
+	}
+	return keys, nil
+}
+
+func (s *ApiKeyService) Revoke(ctx context.Context, id int64) error {
+	err := s.apiKeyRepo.Revoke(ctx, id, time.Now())
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrNotFound) {
+			return ErrApiKeyNotFound
+		}
+		return fmt.Errorf("ApiKeyService.Revoke - apiKeyRepo.Revoke: %w", err)
+	}
+	return nil
+}
+
+// SetRole gives the key role in team, replacing the role it had there. Team
+// entity.ALL_TEAMS grants the role in every team. It takes the admin role in team.
+func (s *ApiKeyService) SetRole(ctx context.Context, id int64, team string, role entity.Role) error {
+	if err := validateRole(team, role); err != nil {
+		return err
+	}
+	if err := s.access.authorize(ctx, entity.ACTION_KEY_SET_ROLE, strconv.FormatInt(id, 10), team, entity.ROLE_ADMIN); err != nil {
+		return err
+	}
+	err := s.apiKeyRepo.SetRole(ctx, id, team, role)
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrNotFound) {
+			return ErrApiKeyNotFound
+		}
+		return fmt.Errorf("ApiKeyService.SetRole - apiKeyRepo.SetRole: %w", err)
+	}
+	return nil
+}
+
+// DeleteRole takes the role in team away from the key. It takes the admin role in team.
+func (s *ApiKeyService) DeleteRole(ctx context.Context, id int64, team string) error {
+	if err := s.access.authorize(ctx, entity.ACTION_KEY_DELETE_ROLE, strconv.FormatInt(id, 10), team, entity.ROLE_ADMIN); err != nil {
+		return err
+	}
+	err := s.apiKeyRepo.DeleteRole(ctx, id, team)
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrNotFound) {
+			return ErrRoleNotFound
+		}
+		return fmt.Errorf("ApiKeyService.DeleteRole - apiKeyRepo.DeleteRole: %w", err)
+	}
+	return nil
+}
+
+func validateRole(team string, role entity.Role) error {
+	if strings.TrimSpace(team) == "" {
+		return ErrInvalidTeam
+	}
+	if !role.Valid() {
+		return ErrInvalidRole
+	}
+	return nil
+}
+
+func hashApiKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
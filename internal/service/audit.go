@@ -0,0 +1,51 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/repo"
+)
+
+type AuditService struct {
+	auditRepo repo.Audit
+	access    accessControl
+}
+
+func NewAuditService(auditRepo repo.Audit) *AuditService {
+	return &AuditService{
+		auditRepo: auditRepo,
+		access:    accessControl{auditRepo: auditRepo},
+	}
+}
+
+type AuditListOutput struct {
+	Entries []entity.AuditEntry
+	// Next is the id to continue after, zero on the last page.
+	Next int64
+}
+
+// List pages through the audit log. The log covers every team, so it takes
+// the admin role in all of them.
+func (s *AuditService) List(ctx context.Context, filter entity.AuditFilter) (AuditListOutput, error) {
+	if err := s.access.authorize(ctx, entity.ACTION_AUDIT_READ, "", entity.ALL_TEAMS, entity.ROLE_ADMIN); err != nil {
+		return AuditListOutput{}, err
+	}
+
+	filter.Limit = listLimit(filter.Limit)
+	limit := filter.Limit
+	filter.Limit++
+	entries, err := s.auditRepo.List(ctx, filter)
+	if err != nil {
+		return AuditListOutput{}, fmt.Errorf("AuditService.List - auditRepo.List: %w", err)
+	}
+
+	var output AuditListOutput
+	if len(entries) > limit {
+		entries = entries[:limit]
+		output.Next = entries[limit-1].Id
+	}
+	output.Entries = entries
+	return output, nil
+}
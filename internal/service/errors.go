@@ -14,4 +14,46 @@ var (
 	ErrUserNotFound      = fmt.Errorf("user not found")
 	ErrCannotGetUser     = fmt.Errorf("cannot get user")
 	ErrCannotDeleteUser  = fmt.Errorf("cannot delete user")
+
+	ErrSegmentNotFound      = fmt.Errorf("segment not found")
+	ErrSegmentAlreadyExists = fmt.Errorf("segment already exists")
+	ErrUserAlreadyInSegment = fmt.Errorf("user is already in the segment")
+	ErrSegmentNotActive     = fmt.Errorf("segment is not active")
+
+	ErrSegmentsVersionMismatch = fmt.Errorf("user segments have changed")
+
+	ErrWebhookNotFound   = fmt.Errorf("webhook not found")
+	ErrInvalidWebhookURL = fmt.Errorf("webhook url must be an absolute http or https url of a public host")
+
+	ErrIdempotencyKeyReused     = fmt.Errorf("idempotency key was already used for a different request")
+	ErrIdempotencyKeyInProgress = fmt.Errorf("a request with this idempotency key is still in progress")
+
+	ErrInvalidApiKey       = fmt.Errorf("invalid api key")
+	ErrApiKeyNotFound      = fmt.Errorf("api key not found")
+	ErrApiKeyAlreadyExists = fmt.Errorf("api key with this name already exists")
+	ErrInvalidApiKeyName   = fmt.Errorf("api key name is required")
+	ErrInvalidScope        = fmt.Errorf("unknown scope")
+	ErrInvalidRole         = fmt.Errorf("unknown role")
+	ErrInvalidTeam         = fmt.Errorf("team is required")
+	ErrRoleNotFound        = fmt.Errorf("api key has no role in this team")
+
+	ErrExportQuotaExceeded = fmt.Errorf("daily export quota exceeded")
+	ErrExportNotReady      = fmt.Errorf("export file is not ready")
+
+	ErrForbidden = fmt.Errorf("api key is not allowed to do this with the segments of this team")
 )
+
+// SegmentError names the segment Err is about, so clients can tell which of
+// the slugs of a request was missing or duplicated.
+type SegmentError struct {
+	Slug string
+	Err  error
+}
+
+func (e *SegmentError) Error() string {
+	return fmt.Sprintf("%v: %s", e.Err, e.Slug)
+}
+
+func (e *SegmentError) Unwrap() error {
+	return e.Err
+}
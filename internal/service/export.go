@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/ABDURAZZAKK/avito_experiment/internal/actor"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/repo"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/repo/repoerrs"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/task"
+)
+
+type ExportService struct {
+	exportJobRepo repo.ExportJob
+	access        accessControl
+	maxPerDay     int
+}
+
+// NewExportService caps the exports an actor may start per UTC day at
+// maxPerDay, zero leaves them unlimited.
+func NewExportService(exportJobRepo repo.ExportJob, auditRepo repo.Audit, maxPerDay int) *ExportService {
+	return &ExportService{
+		exportJobRepo: exportJobRepo,
+		access:        accessControl{auditRepo: auditRepo},
+		maxPerDay:     maxPerDay,
+	}
+}
+
+type ExportCreateInput struct {
+	Year     int
+	Month    int
+	Location *time.Location
+}
+
+// Create registers a queued export job of the stats of the teams the key of
+// ctx is a viewer in. The task for the consumer is stored with the job and
+// published by the outbox relay. It fails with ErrExportQuotaExceeded once the
+// actor of ctx has used up its exports for the day.
+func (s *ExportService) Create(ctx context.Context, input ExportCreateInput) (entity.ExportJob, error) {
+	id, err := s.exportJobRepo.NextId(ctx)
+	if err != nil {
+		return entity.ExportJob{}, fmt.Errorf("ExportService.Create - exportJobRepo.NextId: %w", err)
+	}
+	body, headers, err := task.Encode(&task.CreateCSV{
+		JobId:    id,
+		Year:     input.Year,
+		Month:    input.Month,
+		Timezone: input.Location.String(),
+		Teams:    teamsOf(ctx, entity.ROLE_VIEWER),
+	})
+	if err != nil {
+		return entity.ExportJob{}, fmt.Errorf("ExportService.Create - task.Encode: %w", err)
+	}
+	err = s.exportJobRepo.Create(ctx, entity.ExportJob{
+		Id:          id,
+		Year:        input.Year,
+		Month:       input.Month,
+		Timezone:    input.Location.String(),
+		RequestedBy: actor.From(ctx),
+	}, entity.OutboxMessage{Headers: headers, Payload: body}, s.maxPerDay)
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrLimitExceeded) {
+			return entity.ExportJob{}, ErrExportQuotaExceeded
+		}
+		return entity.ExportJob{}, fmt.Errorf("ExportService.Create - exportJobRepo.Create: %w", err)
+	}
+	return s.GetById(ctx, id)
+}
+
+// GetById returns the job to the actor that started it and to admins of every
+// team; anyone else gets ErrForbidden.
+func (s *ExportService) GetById(ctx context.Context, id int64) (entity.ExportJob, error) {
+	job, err := s.exportJobRepo.GetById(ctx, id)
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrNotFound) {
+			return entity.ExportJob{}, ErrNotFound
+		}
+		return entity.ExportJob{}, fmt.Errorf("ExportService.GetById - exportJobRepo.GetById: %w", err)
+	}
+	if job.RequestedBy != actor.From(ctx) {
+		if err = s.access.authorize(ctx, entity.ACTION_EXPORT_READ, strconv.FormatInt(id, 10), entity.ALL_TEAMS, entity.ROLE_ADMIN); err != nil {
+			return entity.ExportJob{}, err
+		}
+	}
+	return job, nil
+}
+
+// GetFile returns the path of the file of a succeeded job, with the access of
+// GetById. It fails with ErrExportNotReady until the file is written.
+func (s *ExportService) GetFile(ctx context.Context, id int64) (string, error) {
+	job, err := s.GetById(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if job.Status != entity.EXPORT_SUCCEEDED {
+		return "", ErrExportNotReady
+	}
+	return job.Filename, nil
+}
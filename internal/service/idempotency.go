@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/repo"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/repo/repoerrs"
+)
+
+type IdempotencyService struct {
+	idempotencyKeyRepo repo.IdempotencyKey
+	retention          time.Duration
+	lease              time.Duration
+}
+
+func NewIdempotencyService(idempotencyKeyRepo repo.IdempotencyKey, retention time.Duration, lease time.Duration) *IdempotencyService {
+	return &IdempotencyService{
+		idempotencyKeyRepo: idempotencyKeyRepo,
+		retention:          retention,
+		lease:              lease,
+	}
+}
+
+// Begin reserves key of the API key for a request identified by requestHash.
+// It returns true and the reservation when the caller should run the request
+// and Complete or Release the reservation afterwards, otherwise the stored key
+// whose response is to be replayed. A key held longer than the lease by a
+// request that never completed, e.g. on a crashed replica, is taken over; the
+// request that lost it can no longer complete or release it.
+func (s *IdempotencyService) Begin(ctx context.Context, apiKeyId int64, key string, requestHash string) (entity.IdempotencyKey, bool, error) {
+	now := time.Now()
+	stored, reserved, err := s.idempotencyKeyRepo.Reserve(ctx, apiKeyId, key, requestHash, now.Add(-s.retention), now.Add(-s.lease))
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrNotFound) {
+			// Released by the request that held it a moment ago.
+			return entity.IdempotencyKey{}, false, ErrIdempotencyKeyInProgress
+		}
+		return entity.IdempotencyKey{}, false, fmt.Errorf("IdempotencyService.Begin - idempotencyKeyRepo.Reserve: %w", err)
+	}
+	if reserved {
+		return stored, true, nil
+	}
+	if stored.RequestHash != requestHash {
+		return entity.IdempotencyKey{}, false, ErrIdempotencyKeyReused
+	}
+	if stored.ResponseStatus == nil {
+		return entity.IdempotencyKey{}, false, ErrIdempotencyKeyInProgress
+	}
+	return stored, false, nil
+}
+
+// Complete stores the response to replay, headers are those replayed with the body.
+func (s *IdempotencyService) Complete(ctx context.Context, reservation entity.IdempotencyKey, status int, contentType string, headers map[string]string, body []byte) error {
+	err := s.idempotencyKeyRepo.Complete(ctx, reservation.ApiKeyId, reservation.Key, reservation.StartedAt, status, contentType, headers, body)
+	if err != nil {
+		return fmt.Errorf("IdempotencyService.Complete - idempotencyKeyRepo.Complete: %w", err)
+	}
+	return nil
+}
+
+// Release forgets the reserved key so the client can retry the request with it.
+func (s *IdempotencyService) Release(ctx context.Context, reservation entity.IdempotencyKey) error {
+	if err := s.idempotencyKeyRepo.Delete(ctx, reservation.ApiKeyId, reservation.Key, reservation.StartedAt); err != nil {
+		return fmt.Errorf("IdempotencyService.Release - idempotencyKeyRepo.Delete: %w", err)
+	}
+	return nil
+}
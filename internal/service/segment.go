@@ -2,8 +2,11 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
+	"github.com/ABDURAZZAKK/avito_experiment/internal/actor"
 	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
 	"github.com/ABDURAZZAKK/avito_experiment/internal/repo"
 	"github.com/ABDURAZZAKK/avito_experiment/internal/repo/repoerrs"
@@ -13,73 +16,286 @@ type SegmentService struct {
 	segmentRepo       repo.Segment
 	usersSegmentsRepo repo.UsersSegments
 	userRepo          repo.User
+	access            accessControl
 }
 
-func NewSegmentService(segmentRepo repo.Segment, usersSegmentsRepo repo.UsersSegments, userRepo repo.User) *SegmentService {
+func NewSegmentService(segmentRepo repo.Segment, usersSegmentsRepo repo.UsersSegments, userRepo repo.User, auditRepo repo.Audit) *SegmentService {
 	return &SegmentService{
 		segmentRepo:       segmentRepo,
 		usersSegmentsRepo: usersSegmentsRepo,
 		userRepo:          userRepo,
+		access:            accessControl{auditRepo: auditRepo},
 	}
 }
 
 func (s *SegmentService) GetBySlug(ctx context.Context, slug string) (entity.Segment, error) {
 	segment, err := s.segmentRepo.GetBySlug(ctx, slug)
 	if err != nil {
-		if err == repoerrs.ErrNotFound {
-			return entity.Segment{}, ErrNotFound
+		if errors.Is(err, repoerrs.ErrNotFound) {
+			return entity.Segment{}, &SegmentError{Slug: slug, Err: ErrSegmentNotFound}
 		}
-		return entity.Segment{}, fmt.Errorf("SegmentService.GetBySlug - segmentRepo.GetBySlug: %v", err)
+		return entity.Segment{}, fmt.Errorf("SegmentService.GetBySlug - segmentRepo.GetBySlug: %w", err)
 	}
 	return segment, nil
 }
 
-func (s *SegmentService) Create(ctx context.Context, slug string, users []int) (string, error) {
-	slug, err := s.segmentRepo.Create(ctx, slug)
+const (
+	defaultListLimit = 50
+	maxListLimit     = 500
+)
+
+// listLimit clamps a requested page size to (0, maxListLimit].
+func listLimit(limit int) int {
+	if limit <= 0 {
+		return defaultListLimit
+	}
+	if limit > maxListLimit {
+		return maxListLimit
+	}
+	return limit
+}
+
+type SegmentListOutput struct {
+	Segments []entity.Segment
+	// Members holds the member count per slug and is nil unless requested.
+	Members map[string]int
+	// Next is the slug to continue after, empty on the last page.
+	Next string
+}
+
+func (s *SegmentService) List(ctx context.Context, filter entity.SegmentFilter, withMembers bool) (SegmentListOutput, error) {
+	filter.Limit = listLimit(filter.Limit)
+	limit := filter.Limit
+	filter.Limit++
+	segments, err := s.segmentRepo.List(ctx, filter)
 	if err != nil {
-		if err == repoerrs.ErrAlreadyExists {
-			return "", ErrAlreadyExists
-		}
-		return "", fmt.Errorf("SegmentService.Create - segmentRepo.Create: %v", err)
+		return SegmentListOutput{}, fmt.Errorf("SegmentService.List - segmentRepo.List: %w", err)
+	}
+
+	var output SegmentListOutput
+	if len(segments) > limit {
+		segments = segments[:limit]
+		output.Next = segments[limit-1].Slug
 	}
-	if len(users) > 0 {
-		err = s.usersSegmentsRepo.AddAndRemoveSegmentsUser(ctx, users, []string{slug}, []string{})
+	output.Segments = segments
+
+	if withMembers {
+		slugs := make([]string, 0, len(segments))
+		for _, segment := range segments {
+			slugs = append(slugs, segment.Slug)
+		}
+		output.Members, err = s.usersSegmentsRepo.CountMembers(ctx, slugs)
 		if err != nil {
-			return "", fmt.Errorf("SegmentService.Create - usersSegmentsRepo.AddAndRemoveSegmentsUser: %v", err)
+			return SegmentListOutput{}, fmt.Errorf("SegmentService.List - usersSegmentsRepo.CountMembers: %w", err)
 		}
 	}
+	return output, nil
+}
 
-	return slug, nil
+type SegmentMembersOutput struct {
+	Members []entity.UsersSegments
+	// Next is the user to continue after, zero on the last page.
+	Next int
 }
-func (s *SegmentService) CreateAll(ctx context.Context, slugs []string, users []int) error {
-	err := s.segmentRepo.CreateAll(ctx, slugs)
+
+func (s *SegmentService) GetMembers(ctx context.Context, filter entity.MembersFilter) (SegmentMembersOutput, error) {
+	segment, err := s.segmentRepo.GetBySlug(ctx, filter.Segment)
 	if err != nil {
-		if err == repoerrs.ErrAlreadyExists {
-			return ErrAlreadyExists
+		if errors.Is(err, repoerrs.ErrNotFound) {
+			return SegmentMembersOutput{}, &SegmentError{Slug: filter.Segment, Err: ErrSegmentNotFound}
 		}
-		return fmt.Errorf("SegmentService.CreateAll - segmentRepo.CreateAll: %v", err)
+		return SegmentMembersOutput{}, fmt.Errorf("SegmentService.GetMembers - segmentRepo.GetBySlug: %w", err)
 	}
-	if len(users) > 0 {
-		err = s.usersSegmentsRepo.AddAndRemoveSegmentsUser(ctx, users, slugs, []string{})
-		if err != nil {
-			return fmt.Errorf("SegmentService.CreateAll - usersSegmentsRepo.AddAndRemoveSegmentsUser: %v", err)
+	err = s.access.authorize(ctx, entity.ACTION_SEGMENT_MEMBERS, segment.Slug, segment.Team, entity.ROLE_VIEWER)
+	if err != nil {
+		return SegmentMembersOutput{}, err
+	}
+
+	filter.Limit = listLimit(filter.Limit)
+	limit := filter.Limit
+	filter.Limit++
+	members, err := s.usersSegmentsRepo.GetSegmentMembers(ctx, filter)
+	if err != nil {
+		return SegmentMembersOutput{}, fmt.Errorf("SegmentService.GetMembers - usersSegmentsRepo.GetSegmentMembers: %w", err)
+	}
+
+	var output SegmentMembersOutput
+	if len(members) > limit {
+		members = members[:limit]
+		output.Next = members[limit-1].User
+	}
+	output.Members = members
+	return output, nil
+}
+
+type SegmentCreateInput struct {
+	Slug        string
+	Description string
+	Owner       string
+	Team        string
+	Status      entity.SegmentStatus
+	Tags        []string
+	Percent     int
+	ExpiresAt   *time.Time
+	// Reason is recorded with the memberships of the initial rollout.
+	Reason string
+}
+
+func (s *SegmentService) Create(ctx context.Context, input SegmentCreateInput) (string, error) {
+	err := s.access.authorize(ctx, entity.ACTION_SEGMENT_CREATE, input.Slug, input.Team, entity.ROLE_EDITOR)
+	if err != nil {
+		return "", err
+	}
+	status := input.Status
+	if status == "" {
+		status = entity.SEGMENT_ACTIVE
+	}
+	if input.Percent > 0 && status != entity.SEGMENT_ACTIVE {
+		return "", &SegmentError{Slug: input.Slug, Err: ErrSegmentNotActive}
+	}
+	slug, err := s.segmentRepo.Create(ctx, entity.Segment{
+		Slug:              input.Slug,
+		Description:       input.Description,
+		Owner:             input.Owner,
+		Team:              input.Team,
+		Status:            status,
+		Tags:              input.Tags,
+		RolloutPercentage: input.Percent,
+		RolloutExpiresAt:  input.ExpiresAt,
+	}, rolloutChange(ctx, input.Reason))
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrAlreadyExists) {
+			return "", &SegmentError{Slug: input.Slug, Err: ErrSegmentAlreadyExists}
 		}
+		return "", fmt.Errorf("SegmentService.Create - segmentRepo.Create: %w", err)
 	}
+	return slug, nil
+}
 
+// CreateAll creates the segments in team.
+func (s *SegmentService) CreateAll(ctx context.Context, slugs []string, team string, percent int, expiresAt *time.Time, reason string) error {
+	for _, slug := range slugs {
+		if err := s.access.authorize(ctx, entity.ACTION_SEGMENT_CREATE, slug, team, entity.ROLE_EDITOR); err != nil {
+			return err
+		}
+	}
+	err := s.segmentRepo.CreateAll(ctx, slugs, team, percent, expiresAt, rolloutChange(ctx, reason))
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrAlreadyExists) {
+			var keyErr *repoerrs.KeyError
+			if errors.As(err, &keyErr) {
+				return &SegmentError{Slug: keyErr.Key, Err: ErrSegmentAlreadyExists}
+			}
+			return ErrSegmentAlreadyExists
+		}
+		return fmt.Errorf("SegmentService.CreateAll - segmentRepo.CreateAll: %w", err)
+	}
 	return nil
 }
 
+// Rollout extends the segment to percent of all users. Users are picked by their
+// bucket for the segment, so repeating it selects the same users and raising the
+// percent only adds new ones. The percent is kept on the segment, so users created
+// later are enrolled by the same rule. Only active segments can be rolled out.
+// It returns the number of users added.
+func (s *SegmentService) Rollout(ctx context.Context, slug string, percent int, expiresAt *time.Time, reason string) (int, error) {
+	segment, err := s.segmentRepo.GetBySlug(ctx, slug)
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrNotFound) {
+			return 0, &SegmentError{Slug: slug, Err: ErrSegmentNotFound}
+		}
+		return 0, fmt.Errorf("SegmentService.Rollout - segmentRepo.GetBySlug: %w", err)
+	}
+	err = s.access.authorize(ctx, entity.ACTION_SEGMENT_ROLLOUT, slug, segment.Team, entity.ROLE_EDITOR)
+	if err != nil {
+		return 0, err
+	}
+	if segment.Status != entity.SEGMENT_ACTIVE {
+		return 0, &SegmentError{Slug: slug, Err: ErrSegmentNotActive}
+	}
+	added, err := s.usersSegmentsRepo.AddSegmentByPercent(ctx, slug, percent, expiresAt, rolloutChange(ctx, reason))
+	if err != nil {
+		// the segment was paused, archived or deleted since it was read
+		if errors.Is(err, repoerrs.ErrConflict) {
+			return 0, &SegmentError{Slug: slug, Err: ErrSegmentNotActive}
+		}
+		return 0, fmt.Errorf("SegmentService.Rollout - usersSegmentsRepo.AddSegmentByPercent: %w", err)
+	}
+	return added, nil
+}
+
+// rolloutChange attributes the memberships of a rollout to the actor of ctx.
+func rolloutChange(ctx context.Context, reason string) entity.Change {
+	return entity.Change{Actor: actor.From(ctx), Source: entity.SOURCE_ROLLOUT, Reason: reason}
+}
+
+// SegmentUpdateInput holds the metadata to change. Nil fields are left as they are.
+type SegmentUpdateInput struct {
+	Description *string
+	Owner       *string
+	// Team moves the segment to another team, which takes the admin role in both.
+	Team   *string
+	Status *entity.SegmentStatus
+	Tags   *[]string
+}
+
+func (s *SegmentService) Update(ctx context.Context, slug string, input SegmentUpdateInput) (entity.Segment, error) {
+	segment, err := s.segmentRepo.GetBySlug(ctx, slug)
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrNotFound) {
+			return entity.Segment{}, &SegmentError{Slug: slug, Err: ErrSegmentNotFound}
+		}
+		return entity.Segment{}, fmt.Errorf("SegmentService.Update - segmentRepo.GetBySlug: %w", err)
+	}
+	err = s.access.authorize(ctx, entity.ACTION_SEGMENT_UPDATE, slug, segment.Team, entity.ROLE_EDITOR)
+	if err != nil {
+		return entity.Segment{}, err
+	}
+	if input.Team != nil && *input.Team != segment.Team {
+		for _, team := range []string{segment.Team, *input.Team} {
+			if err = s.access.authorize(ctx, entity.ACTION_SEGMENT_UPDATE, slug, team, entity.ROLE_ADMIN); err != nil {
+				return entity.Segment{}, err
+			}
+		}
+		segment.Team = *input.Team
+	}
+	if input.Description != nil {
+		segment.Description = *input.Description
+	}
+	if input.Owner != nil {
+		segment.Owner = *input.Owner
+	}
+	if input.Status != nil {
+		segment.Status = *input.Status
+	}
+	if input.Tags != nil {
+		segment.Tags = *input.Tags
+	}
+	segment, err = s.segmentRepo.Update(ctx, segment)
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrNotFound) {
+			return entity.Segment{}, &SegmentError{Slug: slug, Err: ErrSegmentNotFound}
+		}
+		return entity.Segment{}, fmt.Errorf("SegmentService.Update - segmentRepo.Update: %w", err)
+	}
+	return segment, nil
+}
+
 func (s *SegmentService) Delete(ctx context.Context, slug string) (string, error) {
-	_, err := s.segmentRepo.GetBySlug(ctx, slug)
+	segment, err := s.segmentRepo.GetBySlug(ctx, slug)
 	if err != nil {
-		if err == repoerrs.ErrNotFound {
-			return "", ErrNotFound
+		if errors.Is(err, repoerrs.ErrNotFound) {
+			return "", &SegmentError{Slug: slug, Err: ErrSegmentNotFound}
 		}
-		return "", fmt.Errorf("SegmentService.Delete - segmentRepo.GetBySlug: %v", err)
+		return "", fmt.Errorf("SegmentService.Delete - segmentRepo.GetBySlug: %w", err)
+	}
+	err = s.access.authorize(ctx, entity.ACTION_SEGMENT_DELETE, slug, segment.Team, entity.ROLE_ADMIN)
+	if err != nil {
+		return "", err
 	}
 	s_slug, err := s.segmentRepo.Delete(ctx, slug)
 	if err != nil {
-		return "", fmt.Errorf("SegmentService.Delete - segmentRepo.Delete: %v", err)
+		return "", fmt.Errorf("SegmentService.Delete - segmentRepo.Delete: %w", err)
 	}
 
 	return s_slug, nil
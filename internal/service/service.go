@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"time"
 
 	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
 	"github.com/ABDURAZZAKK/avito_experiment/internal/repo"
@@ -9,34 +10,92 @@ import (
 
 type User interface {
 	Create(ctx context.Context, slug string) (int, error)
-	GetRandomIDs(ctx context.Context, limit int) ([]int, error)
-	GetRandomIDsWithPercent(ctx context.Context, percent int) ([]int, error)
 	GetCount(ctx context.Context) (int, error)
 	GetById(ctx context.Context, id int) (entity.User, error)
-	ChangeSegments(ctx context.Context, id int, addList []string, removeList []string) error
-	GetSegments(ctx context.Context, id int) ([]string, error)
-	Delete(ctx context.Context, id int) (int, error)
+	ChangeSegments(ctx context.Context, id int, input UserChangeSegmentsInput) (UserSegmentsOutput, error)
+	GetSegments(ctx context.Context, id int) (UserSegmentsOutput, error)
+	GetAllSegments(ctx context.Context, id int) (UserSegmentsOutput, error)
+	Delete(ctx context.Context, id int, reason string) (int, error)
 }
 
 type Segment interface {
 	GetBySlug(ctx context.Context, slug string) (entity.Segment, error)
-	Create(ctx context.Context, slug string, users []int) (string, error)
-	CreateAll(ctx context.Context, slugs []string, users []int) error
+	List(ctx context.Context, filter entity.SegmentFilter, withMembers bool) (SegmentListOutput, error)
+	GetMembers(ctx context.Context, filter entity.MembersFilter) (SegmentMembersOutput, error)
+	Create(ctx context.Context, input SegmentCreateInput) (string, error)
+	CreateAll(ctx context.Context, slugs []string, team string, percent int, expiresAt *time.Time, reason string) error
+	Rollout(ctx context.Context, slug string, percent int, expiresAt *time.Time, reason string) (int, error)
+	Update(ctx context.Context, slug string, input SegmentUpdateInput) (entity.Segment, error)
 	Delete(ctx context.Context, slug string) (string, error)
 }
 
+type Stats interface {
+	Get(ctx context.Context, filter entity.StatsFilter) (StatsOutput, error)
+}
+
+type Export interface {
+	Create(ctx context.Context, input ExportCreateInput) (entity.ExportJob, error)
+	GetById(ctx context.Context, id int64) (entity.ExportJob, error)
+	GetFile(ctx context.Context, id int64) (string, error)
+}
+
+type Webhook interface {
+	Create(ctx context.Context, input WebhookCreateInput) (entity.Webhook, error)
+	GetById(ctx context.Context, id int64) (entity.Webhook, error)
+	List(ctx context.Context) ([]entity.Webhook, error)
+	Delete(ctx context.Context, id int64) error
+	GetDeliveries(ctx context.Context, filter entity.WebhookDeliveryFilter) (WebhookDeliveriesOutput, error)
+}
+
+type Idempotency interface {
+	Begin(ctx context.Context, apiKeyId int64, key string, requestHash string) (entity.IdempotencyKey, bool, error)
+	Complete(ctx context.Context, reservation entity.IdempotencyKey, status int, contentType string, headers map[string]string, body []byte) error
+	Release(ctx context.Context, reservation entity.IdempotencyKey) error
+}
+
+type ApiKey interface {
+	Issue(ctx context.Context, input ApiKeyIssueInput) (ApiKeyIssueOutput, error)
+	Authenticate(ctx context.Context, token string) (entity.ApiKey, error)
+	List(ctx context.Context) ([]entity.ApiKey, error)
+	Revoke(ctx context.Context, id int64) error
+	SetRole(ctx context.Context, id int64, team string, role entity.Role) error
+	DeleteRole(ctx context.Context, id int64, team string) error
+}
+
+type Audit interface {
+	List(ctx context.Context, filter entity.AuditFilter) (AuditListOutput, error)
+}
+
 type Services struct {
 	User
 	Segment
+	Stats
+	Export
+	Webhook
+	Idempotency
+	ApiKey
+	Audit
 }
 
 type ServicesDependencies struct {
 	Repos *repo.Repositories
+	// IdempotencyRetention is how long responses are replayed for an Idempotency-Key.
+	IdempotencyRetention time.Duration
+	// IdempotencyLease is how long a running request holds its Idempotency-Key.
+	IdempotencyLease time.Duration
+	// ExportsPerDay caps the exports each API key may start per UTC day, 0 disables the cap.
+	ExportsPerDay int
 }
 
 func NewServices(deps ServicesDependencies) *Services {
 	return &Services{
-		User:    NewUserService(deps.Repos.User, deps.Repos.UsersSegments),
-		Segment: NewSegmentService(deps.Repos.Segment, deps.Repos.UsersSegments, deps.Repos.User),
+		User:        NewUserService(deps.Repos.User, deps.Repos.UsersSegments, deps.Repos.Segment, deps.Repos.Audit),
+		Segment:     NewSegmentService(deps.Repos.Segment, deps.Repos.UsersSegments, deps.Repos.User, deps.Repos.Audit),
+		Stats:       NewStatsService(deps.Repos.UsersSegments),
+		Export:      NewExportService(deps.Repos.ExportJob, deps.Repos.Audit, deps.ExportsPerDay),
+		Webhook:     NewWebhookService(deps.Repos.Webhook, deps.Repos.Segment, deps.Repos.Audit),
+		Idempotency: NewIdempotencyService(deps.Repos.IdempotencyKey, deps.IdempotencyRetention, deps.IdempotencyLease),
+		ApiKey:      NewApiKeyService(deps.Repos.ApiKey, deps.Repos.Audit),
+		Audit:       NewAuditService(deps.Repos.Audit),
 	}
 }
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/repo"
+)
+
+type StatsService struct {
+	usersSegmentsRepo repo.UsersSegments
+}
+
+func NewStatsService(usersSegmentsRepo repo.UsersSegments) *StatsService {
+	return &StatsService{usersSegmentsRepo: usersSegmentsRepo}
+}
+
+type StatsOutput struct {
+	Stats []entity.UsersSegmentsStats
+	// Next is the id to continue after, zero on the last page.
+	Next int64
+}
+
+// Get returns the stats of the segments of the teams the key of ctx is a
+// viewer in.
+func (s *StatsService) Get(ctx context.Context, filter entity.StatsFilter) (StatsOutput, error) {
+	filter.Teams = teamsOf(ctx, entity.ROLE_VIEWER)
+	filter.Limit = listLimit(filter.Limit)
+	limit := filter.Limit
+	filter.Limit++
+	stats, err := s.usersSegmentsRepo.GetStats(ctx, filter)
+	if err != nil {
+		return StatsOutput{}, fmt.Errorf("StatsService.Get - usersSegmentsRepo.GetStats: %w", err)
+	}
+
+	var output StatsOutput
+	if len(stats) > limit {
+		stats = stats[:limit]
+		output.Next = stats[limit-1].Id
+	}
+	output.Stats = stats
+	return output, nil
+}
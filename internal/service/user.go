@@ -2,8 +2,11 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
+	"github.com/ABDURAZZAKK/avito_experiment/internal/actor"
 	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
 	"github.com/ABDURAZZAKK/avito_experiment/internal/repo"
 	"github.com/ABDURAZZAKK/avito_experiment/internal/repo/repoerrs"
@@ -12,107 +15,195 @@ import (
 type UserService struct {
 	userRepo          repo.User
 	usersSegmentsRepo repo.UsersSegments
+	segmentRepo       repo.Segment
+	access            accessControl
 }
 
-func NewUserService(userRepo repo.User, usersSegmentsRepo repo.UsersSegments) *UserService {
-	return &UserService{userRepo: userRepo, usersSegmentsRepo: usersSegmentsRepo}
+func NewUserService(userRepo repo.User, usersSegmentsRepo repo.UsersSegments, segmentRepo repo.Segment, auditRepo repo.Audit) *UserService {
+	return &UserService{
+		userRepo:          userRepo,
+		usersSegmentsRepo: usersSegmentsRepo,
+		segmentRepo:       segmentRepo,
+		access:            accessControl{auditRepo: auditRepo},
+	}
 }
 
 func (s *UserService) Create(ctx context.Context, slug string) (int, error) {
-	id, err := s.userRepo.Create(ctx, slug)
+	id, err := s.userRepo.Create(ctx, slug, entity.Change{Actor: actor.From(ctx), Source: entity.SOURCE_ROLLOUT})
 	if err != nil {
-		if err == repoerrs.ErrAlreadyExists {
+		if errors.Is(err, repoerrs.ErrAlreadyExists) {
 			return 0, ErrUserAlreadyExists
 		}
-		return 0, fmt.Errorf("UserService.Create - userRepo.Create: %v", err)
+		return 0, fmt.Errorf("UserService.Create - userRepo.Create: %w", err)
 	}
-
 	return id, nil
 }
 
 func (s *UserService) GetById(ctx context.Context, user_pk int) (entity.User, error) {
 	user, err := s.userRepo.GetById(ctx, user_pk)
 	if err != nil {
-		if err == repoerrs.ErrNotFound {
+		if errors.Is(err, repoerrs.ErrNotFound) {
 			return entity.User{}, ErrUserNotFound
 		}
-		return entity.User{}, fmt.Errorf("UserService.GetById - userRepo.GetById: %v", err)
+		return entity.User{}, fmt.Errorf("UserService.GetById - userRepo.GetById: %w", err)
 	}
 	return user, nil
 }
 
-func (s *UserService) ChangeSegments(ctx context.Context, user_pk int, addList []string, removeList []string) error {
+type UserChangeSegmentsInput struct {
+	AddList    []string
+	RemoveList []string
+	// ExpireList are segments the user already has whose expiry is replaced
+	// by ExpiresAt.
+	ExpireList []string
+	ExpiresAt  *time.Time
+	// IfVersion makes the change fail with ErrSegmentsVersionMismatch unless the
+	// user's segments are still at this version.
+	IfVersion *int64
+	// Reason is recorded with every membership the change adds or removes.
+	Reason string
+}
+
+type UserSegmentsOutput struct {
+	Segments []string
+	Version  int64
+}
+
+// ChangeSegments applies the change and returns the user's segments as the
+// change left them, with their new version.
+// It takes the editor role in the team of every segment it adds, removes or
+// sets the expiry of.
+func (s *UserService) ChangeSegments(ctx context.Context, user_pk int, input UserChangeSegmentsInput) (UserSegmentsOutput, error) {
 	_, err := s.userRepo.GetById(ctx, user_pk)
 	if err != nil {
-		if err == repoerrs.ErrNotFound {
-			return ErrUserNotFound
+		if errors.Is(err, repoerrs.ErrNotFound) {
+			return UserSegmentsOutput{}, ErrUserNotFound
 		}
-		return fmt.Errorf("UserService.ChangeSegments - userRepo.GetById: %v", err)
+		return UserSegmentsOutput{}, fmt.Errorf("UserService.ChangeSegments - userRepo.GetById: %w", err)
+	}
+	if err = s.checkSegments(ctx, append(append(append([]string{}, input.AddList...), input.RemoveList...), input.ExpireList...)); err != nil {
+		return UserSegmentsOutput{}, err
 	}
 
-	err = s.usersSegmentsRepo.AddAndRemoveSegmentsUser(ctx, []int{user_pk}, addList, removeList)
+	changed, err := s.usersSegmentsRepo.AddAndRemoveSegmentsUser(ctx, []int{user_pk}, input.AddList, input.RemoveList, input.ExpireList, input.ExpiresAt, input.IfVersion,
+		entity.Change{Actor: actor.From(ctx), Source: entity.SOURCE_MANUAL, Reason: input.Reason})
 	if err != nil {
-		if err == repoerrs.ErrAlreadyExists {
-			return ErrAlreadyExists
+		if errors.Is(err, repoerrs.ErrAlreadyExists) {
+			var keyErr *repoerrs.KeyError
+			if errors.As(err, &keyErr) {
+				return UserSegmentsOutput{}, &SegmentError{Slug: keyErr.Key, Err: ErrUserAlreadyInSegment}
+			}
+			return UserSegmentsOutput{}, ErrUserAlreadyInSegment
+		}
+		if errors.Is(err, repoerrs.ErrNotFound) {
+			return UserSegmentsOutput{}, ErrNotFound
 		}
-		if err == repoerrs.ErrNotFound {
-			return ErrNotFound
+		if errors.Is(err, repoerrs.ErrConflict) {
+			return UserSegmentsOutput{}, ErrSegmentsVersionMismatch
 		}
-		return fmt.Errorf("UserService.ChangeSegments - usersSegmentsRepo.AddAndRemoveSegmentsUser: %v", err)
+		return UserSegmentsOutput{}, fmt.Errorf("UserService.ChangeSegments - usersSegmentsRepo.AddAndRemoveSegmentsUser: %w", err)
 	}
-	return nil
+	user, ok := changed[user_pk]
+	if !ok {
+		// deleted while the change ran
+		return UserSegmentsOutput{}, ErrUserNotFound
+	}
+	return UserSegmentsOutput{Segments: user.Segments, Version: user.Version}, nil
 }
 
-func (s *UserService) GetSegments(ctx context.Context, id int) ([]string, error) {
-	_, err := s.userRepo.GetById(ctx, id)
+// checkSegments fails with a SegmentError for the first of the slugs that does
+// not exist, and otherwise checks the editor role in the teams of the segments.
+// Every denied segment is recorded before failing.
+func (s *UserService) checkSegments(ctx context.Context, slugs []string) error {
+	if len(slugs) == 0 {
+		return nil
+	}
+	teams, err := s.segmentRepo.GetTeams(ctx, slugs)
 	if err != nil {
-		if err == repoerrs.ErrNotFound {
-			return nil, ErrUserNotFound
+		return fmt.Errorf("UserService.checkSegments - segmentRepo.GetTeams: %w", err)
+	}
+	for _, slug := range slugs {
+		if _, ok := teams[slug]; !ok {
+			return &SegmentError{Slug: slug, Err: ErrSegmentNotFound}
 		}
-		return nil, fmt.Errorf("UserService.GetSegments - userRepo.GetById: %v", err)
 	}
-	return s.usersSegmentsRepo.GetUserSegments(ctx, id)
+	return s.authorizeSegments(ctx, entity.ACTION_USER_CHANGE_SEGMENTS, slugs, teams)
 }
 
-func (s *UserService) Delete(ctx context.Context, id int) (int, error) {
-	_, err := s.userRepo.GetById(ctx, id)
-	if err != nil {
-		if err == repoerrs.ErrNotFound {
-			return 0, ErrUserNotFound
-		}
-		return 0, fmt.Errorf("UserService.Delete - userRepo.GetById: %v", err)
+// checkTeams checks the editor role in the teams of the segments for action.
+func (s *UserService) checkTeams(ctx context.Context, action string, slugs []string) error {
+	if len(slugs) == 0 {
+		return nil
 	}
-	u_id, err := s.userRepo.Delete(ctx, id)
+	teams, err := s.segmentRepo.GetTeams(ctx, slugs)
 	if err != nil {
-		return 0, fmt.Errorf("UserService.Delete - userRepo.Delete: %v", err)
+		return fmt.Errorf("UserService.checkTeams - segmentRepo.GetTeams: %w", err)
 	}
-	return u_id, nil
+	return s.authorizeSegments(ctx, action, slugs, teams)
 }
 
-func (s *UserService) GetRandomIDs(ctx context.Context, limit int) ([]int, error) {
-	return s.userRepo.GetRandomIDs(ctx, limit)
-
-}
-func (s *UserService) GetCount(ctx context.Context) (int, error) {
-	return s.userRepo.GetCount(ctx)
+// authorizeSegments checks the editor role in the team of each of the slugs
+// found in teams. Every denied segment is recorded before failing.
+func (s *UserService) authorizeSegments(ctx context.Context, action string, slugs []string, teams map[string]string) error {
+	var denied error
+	for _, slug := range slugs {
+		team, ok := teams[slug]
+		if !ok {
+			continue
+		}
+		if err := s.access.authorize(ctx, action, slug, team, entity.ROLE_EDITOR); err != nil {
+			denied = err
+		}
+	}
+	return denied
 }
 
-func (s *UserService) GetRandomIDsWithPercent(ctx context.Context, percent int) ([]int, error) {
-	if percent > 0 {
-		count, err := s.userRepo.GetCount(ctx)
-		if err != nil {
-			return nil, fmt.Errorf("UserService.GetRandomIDsWithPercent - userRepo.GetCount: %v", err)
+// GetSegments returns the user's segments with their version, both of the same snapshot.
+func (s *UserService) GetSegments(ctx context.Context, id int) (UserSegmentsOutput, error) {
+	segments, version, err := s.usersSegmentsRepo.GetUserSegments(ctx, id)
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrNotFound) {
+			return UserSegmentsOutput{}, ErrUserNotFound
 		}
+		return UserSegmentsOutput{}, fmt.Errorf("UserService.GetSegments - usersSegmentsRepo.GetUserSegments: %w", err)
+	}
+	return UserSegmentsOutput{Segments: segments, Version: version}, nil
+}
 
-		limit := int(float32(count) * (float32(percent) / 100))
-		if limit < 1 {
-			limit++
+// GetAllSegments is GetSegments including paused and archived segments, the
+// memberships a replacement of the user's segments has to be compared with.
+func (s *UserService) GetAllSegments(ctx context.Context, id int) (UserSegmentsOutput, error) {
+	segments, version, err := s.usersSegmentsRepo.GetAllUserSegments(ctx, id)
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrNotFound) {
+			return UserSegmentsOutput{}, ErrUserNotFound
 		}
-		users, err := s.userRepo.GetRandomIDs(ctx, limit)
-		if err != nil {
-			return nil, fmt.Errorf("UserService.GetRandomIDsWithPercent - userRepo.GetRandomIDs: %v", err)
+		return UserSegmentsOutput{}, fmt.Errorf("UserService.GetAllSegments - usersSegmentsRepo.GetAllUserSegments: %w", err)
+	}
+	return UserSegmentsOutput{Segments: segments, Version: version}, nil
+}
+
+// Delete removes the user and its memberships. The memberships are recorded as
+// removed with reason. It takes the editor role in the team of every segment
+// the user is in, paused and archived ones included.
+func (s *UserService) Delete(ctx context.Context, id int, reason string) (int, error) {
+	segments, _, err := s.usersSegmentsRepo.GetAllUserSegments(ctx, id)
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrNotFound) {
+			return 0, ErrUserNotFound
 		}
-		return users, nil
+		return 0, fmt.Errorf("UserService.Delete - usersSegmentsRepo.GetAllUserSegments: %w", err)
+	}
+	if err = s.checkTeams(ctx, entity.ACTION_USER_DELETE, segments); err != nil {
+		return 0, err
 	}
-	return []int{}, nil
+	u_id, err := s.userRepo.Delete(ctx, id, entity.Change{Actor: actor.From(ctx), Source: entity.SOURCE_USER_DELETED, Reason: reason})
+	if err != nil {
+		return 0, fmt.Errorf("UserService.Delete - userRepo.Delete: %w", err)
+	}
+	return u_id, nil
+}
+
+func (s *UserService) GetCount(ctx context.Context) (int, error) {
+	return s.userRepo.GetCount(ctx)
 }
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/repo"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/repo/repoerrs"
+	"github.com/ABDURAZZAKK/avito_experiment/pkg/netguard"
+)
+
+type WebhookService struct {
+	webhookRepo repo.Webhook
+	segmentRepo repo.Segment
+	access      accessControl
+}
+
+func NewWebhookService(webhookRepo repo.Webhook, segmentRepo repo.Segment, auditRepo repo.Audit) *WebhookService {
+	return &WebhookService{
+		webhookRepo: webhookRepo,
+		segmentRepo: segmentRepo,
+		access:      accessControl{auditRepo: auditRepo},
+	}
+}
+
+type WebhookCreateInput struct {
+	URL string
+	// Segments limits the webhook to the events of these segments, empty means all.
+	Segments []string
+}
+
+type WebhookDeliveriesOutput struct {
+	Deliveries []entity.WebhookDelivery
+	// Next is the id to continue after, zero on the last page.
+	Next int64
+}
+
+// Create registers a webhook with a freshly generated signing secret. The
+// returned webhook is the only place the secret is handed out. URLs of local
+// and private addresses are rejected. The key of ctx needs the viewer role in
+// the team of every segment the webhook is limited to, or in every team for a
+// webhook of all segments.
+func (s *WebhookService) Create(ctx context.Context, input WebhookCreateInput) (entity.Webhook, error) {
+	u, err := url.Parse(input.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || !netguard.IsPublicHost(u.Hostname()) {
+		return entity.Webhook{}, ErrInvalidWebhookURL
+	}
+	if err = s.checkSegments(ctx, u.String(), input.Segments); err != nil {
+		return entity.Webhook{}, err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return entity.Webhook{}, fmt.Errorf("WebhookService.Create - rand.Read: %w", err)
+	}
+	webhook := entity.Webhook{
+		URL:      u.String(),
+		Secret:   hex.EncodeToString(secret),
+		Segments: input.Segments,
+	}
+	id, err := s.webhookRepo.Create(ctx, webhook)
+	if err != nil {
+		return entity.Webhook{}, fmt.Errorf("WebhookService.Create - webhookRepo.Create: %w", err)
+	}
+	return s.webhookRepo.GetById(ctx, id)
+}
+
+// checkSegments checks the viewer role in the teams of the segments of a
+// webhook to resource. Every denied segment is recorded before failing.
+func (s *WebhookService) checkSegments(ctx context.Context, resource string, slugs []string) error {
+	if len(slugs) == 0 {
+		return s.access.authorize(ctx, entity.ACTION_WEBHOOK_CREATE, resource, entity.ALL_TEAMS, entity.ROLE_VIEWER)
+	}
+	teams, err := s.segmentRepo.GetTeams(ctx, slugs)
+	if err != nil {
+		return fmt.Errorf("WebhookService.checkSegments - segmentRepo.GetTeams: %w", err)
+	}
+	var denied error
+	for _, slug := range slugs {
+		team, ok := teams[slug]
+		if !ok {
+			return &SegmentError{Slug: slug, Err: ErrSegmentNotFound}
+		}
+		if err := s.access.authorize(ctx, entity.ACTION_WEBHOOK_CREATE, slug, team, entity.ROLE_VIEWER); err != nil {
+			denied = err
+		}
+	}
+	return denied
+}
+
+func (s *WebhookService) GetById(ctx context.Context, id int64) (entity.Webhook, error) {
+	webhook, err := s.webhookRepo.GetById(ctx, id)
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrNotFound) {
+			return entity.Webhook{}, ErrWebhookNotFound
+		}
+		return entity.Webhook{}, fmt.Errorf("WebhookService.GetById - webhookRepo.GetById: %w", err)
+	}
+	return webhook, nil
+}
+
+func (s *WebhookService) List(ctx context.Context) ([]entity.Webhook, error) {
+	webhooks, err := s.webhookRepo.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("WebhookService.List - webhookRepo.List: %w", err)
+	}
+	return webhooks, nil
+}
+
+func (s *WebhookService) Delete(ctx context.Context, id int64) error {
+	err := s.webhookRepo.Delete(ctx, id)
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrNotFound) {
+			return ErrWebhookNotFound
+		}
+		return fmt.Errorf("WebhookService.Delete - webhookRepo.Delete: %w", err)
+	}
+	return nil
+}
+
+func (s *WebhookService) GetDeliveries(ctx context.Context, filter entity.WebhookDeliveryFilter) (WebhookDeliveriesOutput, error) {
+	if _, err := s.GetById(ctx, filter.WebhookId); err != nil {
+		return WebhookDeliveriesOutput{}, err
+	}
+
+	filter.Limit = listLimit(filter.Limit)
+	limit := filter.Limit
+	filter.Limit++
+	deliveries, err := s.webhookRepo.GetDeliveries(ctx, filter)
+	if err != nil {
+		return WebhookDeliveriesOutput{}, fmt.Errorf("WebhookService.GetDeliveries - webhookRepo.GetDeliveries: %w", err)
+	}
+
+	var output WebhookDeliveriesOutput
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+		output.Next = deliveries[limit-1].Id
+	}
+	output.Deliveries = deliveries
+	return output, nil
+}
@@ -0,0 +1,65 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+	_ "time/tzdata"
+)
+
+const (
+	TYPE_CREATE_CSV = "createCSVFromUsersSegments"
+
+	// EXPORT_DIR is the directory the export files are written to.
+	EXPORT_DIR = "assets/csv"
+)
+
+// CreateCSV asks the consumer to write the stats of a month to a CSV file
+// and to report the outcome in the export job. The file is always ExportPath
+// of the job; a filename sent by older versions is ignored.
+type CreateCSV struct {
+	JobId    int64  `json:"job_id"`
+	Year     int    `json:"year"`
+	Month    int    `json:"month"`
+	Timezone string `json:"timezone"`
+	// Teams limits the export to the segments of these teams, null means every
+	// team.
+	Teams []string `json:"teams"`
+}
+
+// ExportPath is the file of the export job. It is derived from the id alone, so
+// no message can make the consumer write outside of EXPORT_DIR.
+func ExportPath(jobId int64) string {
+	return filepath.Join(EXPORT_DIR, fmt.Sprintf("export_%d.csv", jobId))
+}
+
+func (t *CreateCSV) Type() string { return TYPE_CREATE_CSV }
+
+func (t *CreateCSV) Version() int { return 1 }
+
+func (t *CreateCSV) Validate() error {
+	if t.JobId <= 0 {
+		return errors.New("job_id must be positive")
+	}
+	if t.Year <= 0 {
+		return errors.New("year must be positive")
+	}
+	if t.Month < 1 || t.Month > 12 {
+		return errors.New("month must be between 1 and 12")
+	}
+	if _, err := time.LoadLocation(t.Timezone); err != nil {
+		return errors.New("unknown timezone")
+	}
+	return nil
+}
+
+// Location is the timezone the month boundaries and timestamps are taken in.
+// An empty timezone means UTC.
+func (t *CreateCSV) Location() *time.Location {
+	loc, err := time.LoadLocation(t.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
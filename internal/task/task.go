@@ -0,0 +1,84 @@
+// Package task defines the messages the app sends to the consumer. The task
+// type and schema version travel in the message headers, the body is the JSON
+// encoded task.
+package task
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/ABDURAZZAKK/avito_experiment/pkg/broker"
+)
+
+const (
+	HEADER_TYPE    = "x-task-type"
+	HEADER_VERSION = "x-task-version"
+)
+
+// ErrInvalid is returned for messages that can never be handled: unknown task
+// types or versions and malformed or incomplete payloads.
+var ErrInvalid = errors.New("invalid task")
+
+type Task interface {
+	Type() string
+	Version() int
+	Validate() error
+}
+
+func Encode(t Task) ([]byte, broker.Headers, error) {
+	body, err := json.Marshal(t)
+	if err != nil {
+		return nil, nil, fmt.Errorf("task - Encode - json.Marshal: %w", err)
+	}
+	return body, broker.Headers{
+		HEADER_TYPE:    t.Type(),
+		HEADER_VERSION: strconv.Itoa(t.Version()),
+	}, nil
+}
+
+// Decode turns a delivery back into a validated task. Messages published before
+// tasks carried headers are read as version 1 of the type named in their body.
+func Decode(body []byte, headers broker.Headers) (Task, error) {
+	taskType, version, err := typeAndVersion(body, headers)
+	if err != nil {
+		return nil, err
+	}
+
+	var t Task
+	switch {
+	case taskType == TYPE_CREATE_CSV && version == 1:
+		t = &CreateCSV{}
+	case taskType == TYPE_DELETE_SEGMENT_ON_TIME && version == 1:
+		t = &DeleteSegmentOnTime{}
+	default:
+		return nil, fmt.Errorf("%w: unsupported task %q version %d", ErrInvalid, taskType, version)
+	}
+
+	if err := json.Unmarshal(body, t); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalid, taskType, err)
+	}
+	if err := t.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalid, taskType, err)
+	}
+	return t, nil
+}
+
+func typeAndVersion(body []byte, headers broker.Headers) (string, int, error) {
+	taskType, ok := headers[HEADER_TYPE]
+	if !ok {
+		var legacy struct {
+			Task string `json:"task"`
+		}
+		if err := json.Unmarshal(body, &legacy); err != nil || legacy.Task == "" {
+			return "", 0, fmt.Errorf("%w: missing %s header", ErrInvalid, HEADER_TYPE)
+		}
+		return legacy.Task, 1, nil
+	}
+	version, err := strconv.Atoi(headers[HEADER_VERSION])
+	if err != nil {
+		return "", 0, fmt.Errorf("%w: bad %s header: %q", ErrInvalid, HEADER_VERSION, headers[HEADER_VERSION])
+	}
+	return taskType, version, nil
+}
@@ -0,0 +1,67 @@
+package task
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	TYPE_DELETE_SEGMENT_ON_TIME = "DeleteSegmentFromUserOnTime"
+
+	// legacyTimeLayout and legacyTimezone are how the time of a
+	// DeleteSegmentOnTime was written.
+	legacyTimeLayout = "2006-01-02 15:04:05"
+	legacyTimezone   = "Europe/Moscow"
+)
+
+// DeleteSegmentOnTime is the message older versions published to remove
+// segments from users at a time. Segment TTLs are stored as the expires_at of
+// the membership now, so the consumer only turns messages still queued into
+// that expiry and the sweeper removes the memberships.
+type DeleteSegmentOnTime struct {
+	Time     string   `json:"time"`
+	User     int      `json:"user"`
+	Users    []int    `json:"users"`
+	Segments []string `json:"segments"`
+}
+
+func (t *DeleteSegmentOnTime) Type() string { return TYPE_DELETE_SEGMENT_ON_TIME }
+
+func (t *DeleteSegmentOnTime) Version() int { return 1 }
+
+func (t *DeleteSegmentOnTime) Validate() error {
+	if _, err := t.ExpiresAt(); err != nil {
+		return err
+	}
+	if len(t.UserIds()) == 0 {
+		return errors.New("user or users is required")
+	}
+	if len(t.Segments) == 0 {
+		return errors.New("segments must not be empty")
+	}
+	return nil
+}
+
+// ExpiresAt is the time the segments are to be removed at.
+func (t *DeleteSegmentOnTime) ExpiresAt() (time.Time, error) {
+	loc, err := time.LoadLocation(legacyTimezone)
+	if err != nil {
+		return time.Time{}, err
+	}
+	at, err := time.ParseInLocation(legacyTimeLayout, t.Time, loc)
+	if err != nil {
+		return time.Time{}, errors.New("time must be YYYY-MM-DD HH:MM:SS")
+	}
+	return at, nil
+}
+
+// UserIds are the users of the message, which named either one or several.
+func (t *DeleteSegmentOnTime) UserIds() []int {
+	if len(t.Users) != 0 {
+		return t.Users
+	}
+	if t.User > 0 {
+		return []int{t.User}
+	}
+	return nil
+}
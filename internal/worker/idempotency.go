@@ -0,0 +1,54 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/ABDURAZZAKK/avito_experiment/internal/repo"
+	log "github.com/sirupsen/logrus"
+)
+
+// IdempotencyPurger periodically deletes the idempotency keys older than the
+// retention window.
+type IdempotencyPurger struct {
+	idempotencyKeyRepo repo.IdempotencyKey
+	interval           time.Duration
+	batchSize          int
+	retention          time.Duration
+}
+
+func NewIdempotencyPurger(idempotencyKeyRepo repo.IdempotencyKey, interval time.Duration, batchSize int, retention time.Duration) *IdempotencyPurger {
+	return &IdempotencyPurger{
+		idempotencyKeyRepo: idempotencyKeyRepo,
+		interval:           interval,
+		batchSize:          batchSize,
+		retention:          retention,
+	}
+}
+
+func (p *IdempotencyPurger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		p.purge(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *IdempotencyPurger) purge(ctx context.Context) {
+	for {
+		removed, err := p.idempotencyKeyRepo.DeleteExpired(ctx, time.Now().Add(-p.retention), p.batchSize)
+		if err != nil {
+			log.Errorf("worker - IdempotencyPurger.purge - idempotencyKeyRepo.DeleteExpired: %v", err)
+			return
+		}
+		if removed < p.batchSize {
+			return
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/repo"
+	"github.com/ABDURAZZAKK/avito_experiment/pkg/broker"
+	log "github.com/sirupsen/logrus"
+)
+
+// Relay publishes the messages stored in the outbox. A message stays in the
+// outbox and is retried with backoff until the broker accepts it. Messages are
+// claimed for lease and published outside of any transaction.
+type Relay struct {
+	outboxRepo repo.Outbox
+	publisher  broker.Publisher
+	interval   time.Duration
+	batchSize  int
+	lease      time.Duration
+	maxBackoff time.Duration
+}
+
+func NewRelay(outboxRepo repo.Outbox, publisher broker.Publisher, interval time.Duration, batchSize int, lease time.Duration, maxBackoff time.Duration) *Relay {
+	return &Relay{
+		outboxRepo: outboxRepo,
+		publisher:  publisher,
+		interval:   interval,
+		batchSize:  batchSize,
+		lease:      lease,
+		maxBackoff: maxBackoff,
+	}
+}
+
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		r.relay(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Relay) relay(ctx context.Context) {
+	for {
+		messages, err := r.outboxRepo.Claim(ctx, r.batchSize, r.lease)
+		if err != nil {
+			log.Errorf("worker - Relay.relay - outboxRepo.Claim: %v", err)
+			return
+		}
+		for _, m := range messages {
+			if err = r.publish(ctx, m); err != nil {
+				err = r.outboxRepo.MarkFailed(ctx, m.Id, err.Error(), r.maxBackoff)
+			} else {
+				err = r.outboxRepo.MarkSent(ctx, m.Id)
+			}
+			// the message is published again once its lease runs out
+			if err != nil {
+				log.Errorf("worker - Relay.relay - outbox message %d: %v", m.Id, err)
+			}
+		}
+		if len(messages) < r.batchSize {
+			return
+		}
+	}
+}
+
+func (r *Relay) publish(ctx context.Context, m entity.OutboxMessage) error {
+	var err error
+	if m.RoutingKey == "" {
+		err = r.publisher.Publish(ctx, m.Payload, m.Headers)
+	} else {
+		err = r.publisher.PublishEvent(ctx, m.RoutingKey, m.Payload, m.Headers)
+	}
+	if err != nil {
+		log.Warnf("worker - Relay.publish - outbox message %d, attempt %d: %v", m.Id, m.Attempts+1, err)
+		return err
+	}
+	return nil
+}
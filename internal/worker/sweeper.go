@@ -0,0 +1,58 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/ABDURAZZAKK/avito_experiment/internal/actor"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/repo"
+	log "github.com/sirupsen/logrus"
+)
+
+// Sweeper periodically removes memberships whose expires_at has passed.
+// Expiry is stored in users_segments, so nothing is lost when the process restarts.
+type Sweeper struct {
+	usersSegmentsRepo repo.UsersSegments
+	interval          time.Duration
+	batchSize         int
+}
+
+func NewSweeper(usersSegmentsRepo repo.UsersSegments, interval time.Duration, batchSize int) *Sweeper {
+	return &Sweeper{
+		usersSegmentsRepo: usersSegmentsRepo,
+		interval:          interval,
+		batchSize:         batchSize,
+	}
+}
+
+func (s *Sweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.sweep(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Sweeper) sweep(ctx context.Context) {
+	for {
+		removed, err := s.usersSegmentsRepo.DeleteExpired(ctx, time.Now(), s.batchSize,
+			entity.Change{Actor: actor.SWEEPER, Source: entity.SOURCE_TTL})
+		if err != nil {
+			log.Errorf("worker - Sweeper.sweep - usersSegmentsRepo.DeleteExpired: %v", err)
+			return
+		}
+		if removed > 0 {
+			log.Infof("Sweeper removed %d expired memberships", removed)
+		}
+		if removed < s.batchSize {
+			return
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/repo"
+	"github.com/ABDURAZZAKK/avito_experiment/pkg/netguard"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	HEADER_WEBHOOK_ID        = "X-Webhook-Id"
+	HEADER_WEBHOOK_DELIVERY  = "X-Webhook-Delivery"
+	HEADER_WEBHOOK_TIMESTAMP = "X-Webhook-Timestamp"
+	HEADER_WEBHOOK_SIGNATURE = "X-Webhook-Signature"
+)
+
+// WebhookDispatcher POSTs pending webhook deliveries. A delivery succeeds on a
+// 2xx response; anything else is retried with backoff up to maxAttempts.
+// The deliveries of a batch are sent in parallel and failed ones are retried
+// later, so a webhook may receive its events out of order. Only public
+// addresses are connected to.
+type WebhookDispatcher struct {
+	webhookRepo repo.Webhook
+	client      *http.Client
+	interval    time.Duration
+	batchSize   int
+	maxAttempts int
+	maxBackoff  time.Duration
+}
+
+func NewWebhookDispatcher(webhookRepo repo.Webhook, timeout time.Duration, interval time.Duration, batchSize int, maxAttempts int, maxBackoff time.Duration) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		webhookRepo: webhookRepo,
+		client:      newWebhookClient(timeout),
+		interval:    interval,
+		batchSize:   batchSize,
+		maxAttempts: maxAttempts,
+		maxBackoff:  maxBackoff,
+	}
+}
+
+// newWebhookClient refuses to connect to local and private addresses, also when
+// a public name resolves to one or a response redirects to one.
+func newWebhookClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, Control: netguard.Control}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		d.dispatch(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *WebhookDispatcher) dispatch(ctx context.Context) {
+	// The lease must outlast a batch, whose requests run in parallel.
+	lease := d.client.Timeout + d.interval
+	for {
+		deliveries, err := d.webhookRepo.ClaimDeliveries(ctx, d.batchSize, lease)
+		if err != nil {
+			log.Errorf("worker - WebhookDispatcher.dispatch - webhookRepo.ClaimDeliveries: %v", err)
+			return
+		}
+
+		var wg sync.WaitGroup
+		for _, delivery := range deliveries {
+			wg.Add(1)
+			go func(delivery entity.WebhookDelivery) {
+				defer wg.Done()
+				d.deliver(ctx, delivery)
+			}(delivery)
+		}
+		wg.Wait()
+
+		if len(deliveries) < d.batchSize {
+			return
+		}
+	}
+}
+
+func (d *WebhookDispatcher) deliver(ctx context.Context, delivery entity.WebhookDelivery) {
+	status, err := d.post(ctx, delivery)
+	if err == nil {
+		if err := d.webhookRepo.MarkDelivered(ctx, delivery.Id, status); err != nil {
+			log.Errorf("worker - WebhookDispatcher.deliver - webhookRepo.MarkDelivered: %v", err)
+		}
+		return
+	}
+
+	log.Warnf("worker - WebhookDispatcher.deliver - delivery %d to webhook %d, attempt %d: %v", delivery.Id, delivery.WebhookId, delivery.Attempts+1, err)
+	var responseStatus *int
+	if status != 0 {
+		responseStatus = &status
+	}
+	if err := d.webhookRepo.MarkFailed(ctx, delivery.Id, responseStatus, err.Error(), d.maxAttempts, d.maxBackoff); err != nil {
+		log.Errorf("worker - WebhookDispatcher.deliver - webhookRepo.MarkFailed: %v", err)
+	}
+}
+
+// post sends the delivery and returns the response status, zero when there is no response.
+func (d *WebhookDispatcher) post(ctx context.Context, delivery entity.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, fmt.Errorf("http.NewRequest: %w", err)
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HEADER_WEBHOOK_ID, strconv.FormatInt(delivery.WebhookId, 10))
+	req.Header.Set(HEADER_WEBHOOK_DELIVERY, strconv.FormatInt(delivery.Id, 10))
+	req.Header.Set(HEADER_WEBHOOK_TIMESTAMP, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HEADER_WEBHOOK_SIGNATURE, signWebhook(delivery.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// signWebhook is "sha256=" and the hex HMAC-SHA256 of "<timestamp>.<body>" keyed
+// with the webhook secret. Signing the timestamp lets receivers reject replays.
+func signWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,163 @@
+package worker
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ABDURAZZAKK/avito_experiment/internal/entity"
+	"github.com/ABDURAZZAKK/avito_experiment/internal/repo"
+)
+
+// webhookRepo hands out the deliveries once and records how they end.
+type webhookRepo struct {
+	repo.Webhook
+
+	mu         sync.Mutex
+	deliveries []entity.WebhookDelivery
+	delivered  map[int64]int
+	failed     map[int64]failure
+}
+
+type failure struct {
+	responseStatus *int
+	reason         string
+	maxAttempts    int
+	maxBackoff     time.Duration
+}
+
+func newWebhookRepo(deliveries ...entity.WebhookDelivery) *webhookRepo {
+	return &webhookRepo{deliveries: deliveries, delivered: map[int64]int{}, failed: map[int64]failure{}}
+}
+
+func (r *webhookRepo) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]entity.WebhookDelivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := limit
+	if n > len(r.deliveries) {
+		n = len(r.deliveries)
+	}
+	claimed := r.deliveries[:n]
+	r.deliveries = r.deliveries[n:]
+	return claimed, nil
+}
+
+func (r *webhookRepo) MarkDelivered(ctx context.Context, id int64, responseStatus int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.delivered[id] = responseStatus
+	return nil
+}
+
+func (r *webhookRepo) MarkFailed(ctx context.Context, id int64, responseStatus *int, reason string, maxAttempts int, maxBackoff time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failed[id] = failure{responseStatus, reason, maxAttempts, maxBackoff}
+	return nil
+}
+
+// newTestDispatcher talks to the loopback test server, which the guarded client refuses.
+func newTestDispatcher(webhookRepo repo.Webhook) *WebhookDispatcher {
+	d := NewWebhookDispatcher(webhookRepo, time.Second, time.Second, 2, 5, time.Minute)
+	d.client = &http.Client{Timeout: time.Second}
+	return d
+}
+
+func TestSignWebhook(t *testing.T) {
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(`1700000000.{"a":1}`))
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	if got := signWebhook("secret", 1700000000, []byte(`{"a":1}`)); got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	if got := signWebhook("other", 1700000000, []byte(`{"a":1}`)); got == want {
+		t.Fatal("signature does not depend on the secret")
+	}
+}
+
+func TestWebhookDispatcherDelivers(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		// the receiver checks the signature the way the README describes
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write([]byte(r.Header.Get(HEADER_WEBHOOK_TIMESTAMP) + "." + string(body)))
+		if r.Header.Get(HEADER_WEBHOOK_SIGNATURE) != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get(HEADER_WEBHOOK_ID) != "7" || r.Header.Get(HEADER_WEBHOOK_DELIVERY) == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	webhooks := newWebhookRepo(
+		entity.WebhookDelivery{Id: 1, WebhookId: 7, URL: server.URL, Secret: "secret", Payload: []byte(`{"n":1}`)},
+		entity.WebhookDelivery{Id: 2, WebhookId: 7, URL: server.URL, Secret: "secret", Payload: []byte(`{"n":2}`)},
+		entity.WebhookDelivery{Id: 3, WebhookId: 7, URL: server.URL, Secret: "secret", Payload: []byte(`{"n":3}`)},
+	)
+	newTestDispatcher(webhooks).dispatch(context.Background())
+
+	if len(webhooks.failed) != 0 {
+		t.Fatalf("got failures %v", webhooks.failed)
+	}
+	for id := int64(1); id <= 3; id++ {
+		if status := webhooks.delivered[id]; status != http.StatusAccepted {
+			t.Fatalf("delivery %d marked delivered with %d, want %d", id, status, http.StatusAccepted)
+		}
+	}
+}
+
+func TestWebhookDispatcherMarksFailed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	webhooks := newWebhookRepo(
+		entity.WebhookDelivery{Id: 1, WebhookId: 7, URL: server.URL, Secret: "secret", Attempts: 2},
+		entity.WebhookDelivery{Id: 2, WebhookId: 8, URL: closed.URL, Secret: "secret"},
+	)
+	newTestDispatcher(webhooks).dispatch(context.Background())
+
+	if len(webhooks.delivered) != 0 {
+		t.Fatalf("got deliveries %v", webhooks.delivered)
+	}
+	f, ok := webhooks.failed[1]
+	if !ok || f.responseStatus == nil || *f.responseStatus != http.StatusInternalServerError || f.reason == "" {
+		t.Fatalf("got failure %+v for an error response", f)
+	}
+	if f.maxAttempts != 5 || f.maxBackoff != time.Minute {
+		t.Fatalf("got max attempts %d and backoff %s, want 5 and 1m", f.maxAttempts, f.maxBackoff)
+	}
+	if f, ok = webhooks.failed[2]; !ok || f.responseStatus != nil || f.reason == "" {
+		t.Fatalf("got failure %+v for a refused connection", f)
+	}
+}
+
+// The guarded client does not reach the loopback address a webhook points to.
+func TestWebhookDispatcherRefusesLocalAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request reached the local server")
+	}))
+	defer server.Close()
+
+	webhooks := newWebhookRepo(entity.WebhookDelivery{Id: 1, WebhookId: 7, URL: server.URL, Secret: "secret"})
+	NewWebhookDispatcher(webhooks, time.Second, time.Second, 2, 5, time.Minute).dispatch(context.Background())
+
+	if f, ok := webhooks.failed[1]; !ok || f.responseStatus != nil {
+		t.Fatalf("got failure %+v, want a refused connection", f)
+	}
+}
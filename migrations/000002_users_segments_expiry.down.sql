@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS users_segments_expires_at_idx;
+
+ALTER TABLE users_segments DROP COLUMN IF EXISTS expires_at;
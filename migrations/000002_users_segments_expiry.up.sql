@@ -0,0 +1,3 @@
+ALTER TABLE users_segments ADD COLUMN expires_at TIMESTAMPTZ;
+
+CREATE INDEX users_segments_expires_at_idx ON users_segments (expires_at) WHERE expires_at IS NOT NULL;
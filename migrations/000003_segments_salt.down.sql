@@ -0,0 +1,3 @@
+DROP FUNCTION IF EXISTS user_bucket(TEXT, INT);
+
+ALTER TABLE segments DROP COLUMN IF EXISTS salt;
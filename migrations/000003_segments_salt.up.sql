@@ -0,0 +1,6 @@
+ALTER TABLE segments ADD COLUMN salt VARCHAR(32) NOT NULL DEFAULT md5(random()::text);
+
+-- Places a user into one of 10000 stable buckets for the given segment salt.
+CREATE FUNCTION user_bucket(salt TEXT, user_id INT) RETURNS INT AS $$
+    SELECT (('x' || substr(md5(salt || ':' || user_id::text), 1, 8))::bit(32)::bigint % 10000)::int
+$$ LANGUAGE SQL IMMUTABLE;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS segments_rollout_idx;
+
+ALTER TABLE segments DROP COLUMN IF EXISTS rollout_expires_at;
+
+ALTER TABLE segments DROP COLUMN IF EXISTS rollout_percentage;
@@ -0,0 +1,6 @@
+ALTER TABLE segments ADD COLUMN rollout_percentage SMALLINT NOT NULL DEFAULT 0
+    CHECK (rollout_percentage BETWEEN 0 AND 100);
+
+ALTER TABLE segments ADD COLUMN rollout_expires_at TIMESTAMPTZ;
+
+CREATE INDEX segments_rollout_idx ON segments (rollout_percentage) WHERE rollout_percentage > 0;
@@ -0,0 +1,7 @@
+ALTER TABLE segments
+    ADD COLUMN description TEXT         NOT NULL DEFAULT '',
+    ADD COLUMN owner       VARCHAR(100) NOT NULL DEFAULT '',
+    ADD COLUMN status      VARCHAR(20)  NOT NULL DEFAULT 'active'
+        CHECK (status IN ('draft', 'active', 'paused', 'archived')),
+    ADD COLUMN created_at  TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    ADD COLUMN updated_at  TIMESTAMPTZ  NOT NULL DEFAULT now();
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS users_segments_segment_pk_idx;
+
+DROP INDEX IF EXISTS segments_tags_idx;
+
+ALTER TABLE segments DROP COLUMN IF EXISTS tags;
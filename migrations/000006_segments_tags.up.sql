@@ -0,0 +1,5 @@
+ALTER TABLE segments ADD COLUMN tags TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE INDEX segments_tags_idx ON segments USING GIN (tags);
+
+CREATE INDEX users_segments_segment_pk_idx ON users_segments (segment_pk);
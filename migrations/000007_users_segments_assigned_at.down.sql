@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS users_segments_segment_user_idx;
+
+CREATE INDEX users_segments_segment_pk_idx ON users_segments (segment_pk);
+
+ALTER TABLE users_segments DROP COLUMN IF EXISTS assigned_at;
@@ -0,0 +1,15 @@
+ALTER TABLE users_segments ADD COLUMN assigned_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+UPDATE users_segments us
+SET assigned_at = st.assigned_at
+FROM (
+    SELECT user_pk, segment_pk, max(created_at) AT TIME ZONE 'UTC' AS assigned_at
+    FROM users_segments_stats
+    WHERE operation = 'segment_added'
+    GROUP BY user_pk, segment_pk
+) st
+WHERE st.user_pk = us.user_pk AND st.segment_pk = us.segment_pk;
+
+DROP INDEX IF EXISTS users_segments_segment_pk_idx;
+
+CREATE INDEX users_segments_segment_user_idx ON users_segments (segment_pk, user_pk);
@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS users_segments_stats_segment_pk_idx;
+
+DROP INDEX IF EXISTS users_segments_stats_user_pk_idx;
+
+DROP INDEX IF EXISTS users_segments_stats_created_at_idx;
+
+ALTER TABLE users_segments_stats
+    ALTER COLUMN created_at TYPE TIMESTAMP USING created_at AT TIME ZONE 'UTC';
+
+ALTER TABLE users_segments_stats DROP COLUMN IF EXISTS id;
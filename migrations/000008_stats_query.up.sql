@@ -0,0 +1,10 @@
+ALTER TABLE users_segments_stats ADD COLUMN id BIGSERIAL PRIMARY KEY;
+
+ALTER TABLE users_segments_stats
+    ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE 'UTC';
+
+CREATE INDEX users_segments_stats_created_at_idx ON users_segments_stats (created_at);
+
+CREATE INDEX users_segments_stats_user_pk_idx ON users_segments_stats (user_pk);
+
+CREATE INDEX users_segments_stats_segment_pk_idx ON users_segments_stats (segment_pk);
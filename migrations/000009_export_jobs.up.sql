@@ -0,0 +1,14 @@
+CREATE TABLE export_jobs (
+    id          BIGSERIAL    PRIMARY KEY,
+    status      VARCHAR(20)  NOT NULL DEFAULT 'queued'
+        CHECK (status IN ('queued', 'running', 'succeeded', 'failed')),
+    year        INT          NOT NULL,
+    month       INT          NOT NULL,
+    timezone    VARCHAR(64)  NOT NULL,
+    filename    VARCHAR(255) NOT NULL DEFAULT '',
+    row_count   INT          NOT NULL DEFAULT 0,
+    error       TEXT         NOT NULL DEFAULT '',
+    created_at  TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    started_at  TIMESTAMPTZ,
+    finished_at TIMESTAMPTZ
+);
@@ -0,0 +1,10 @@
+CREATE TABLE outbox (
+    id              BIGSERIAL   PRIMARY KEY,
+    payload         BYTEA       NOT NULL,
+    attempts        INT         NOT NULL DEFAULT 0,
+    last_error      TEXT        NOT NULL DEFAULT '',
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX outbox_next_attempt_at_idx ON outbox (next_attempt_at);
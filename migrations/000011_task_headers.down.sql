@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS rejected_messages;
+
+ALTER TABLE outbox DROP COLUMN IF EXISTS headers;
@@ -0,0 +1,9 @@
+ALTER TABLE outbox ADD COLUMN headers JSONB NOT NULL DEFAULT '{}';
+
+CREATE TABLE rejected_messages (
+    id         BIGSERIAL   PRIMARY KEY,
+    headers    JSONB       NOT NULL DEFAULT '{}',
+    body       BYTEA       NOT NULL,
+    reason     TEXT        NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,3 @@
+DELETE FROM outbox WHERE routing_key <> '';
+
+ALTER TABLE outbox DROP COLUMN IF EXISTS routing_key;
@@ -0,0 +1,3 @@
+-- Empty routing_key: a task for the main queue. Otherwise an event for the
+-- events exchange.
+ALTER TABLE outbox ADD COLUMN routing_key TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS outbox_fan_out_webhooks ON outbox;
+DROP FUNCTION IF EXISTS fan_out_webhooks();
+
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhooks;
@@ -0,0 +1,42 @@
+CREATE TABLE webhooks (
+    id         BIGSERIAL   PRIMARY KEY,
+    url        TEXT        NOT NULL,
+    secret     TEXT        NOT NULL,
+    -- Empty means every segment.
+    segments   TEXT[]      NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE webhook_deliveries (
+    id              BIGSERIAL   PRIMARY KEY,
+    webhook_id      BIGINT      NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+    payload         BYTEA       NOT NULL,
+    status          VARCHAR(16) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'succeeded', 'failed')),
+    attempts        INT         NOT NULL DEFAULT 0,
+    response_status INT,
+    last_error      TEXT        NOT NULL DEFAULT '',
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    delivered_at    TIMESTAMPTZ
+);
+
+CREATE INDEX webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX webhook_deliveries_webhook_idx ON webhook_deliveries (webhook_id, id);
+
+-- Every membership event queued in the outbox becomes a delivery for each
+-- webhook subscribed to its segment, in the transaction of the change itself.
+CREATE FUNCTION fan_out_webhooks() RETURNS TRIGGER AS $$
+BEGIN
+    INSERT INTO webhook_deliveries (webhook_id, payload)
+    SELECT w.id, NEW.payload
+    FROM webhooks w
+    WHERE cardinality(w.segments) = 0
+       OR convert_from(NEW.payload, 'UTF8')::jsonb ->> 'segment' = ANY (w.segments);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER outbox_fan_out_webhooks AFTER INSERT ON outbox
+    FOR EACH ROW WHEN (NEW.headers ->> 'x-event-type' = 'membership')
+    EXECUTE FUNCTION fan_out_webhooks();
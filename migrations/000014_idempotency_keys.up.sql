@@ -0,0 +1,12 @@
+-- Responses of mutating requests sent with an Idempotency-Key header. A row
+-- without response_status belongs to a request that is still running.
+CREATE TABLE idempotency_keys (
+    key                   VARCHAR(255) PRIMARY KEY,
+    request_hash          VARCHAR(64)  NOT NULL,
+    response_status       INT,
+    response_content_type TEXT         NOT NULL DEFAULT '',
+    response_body         BYTEA,
+    created_at            TIMESTAMPTZ  NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idempotency_keys_created_at_idx ON idempotency_keys (created_at);
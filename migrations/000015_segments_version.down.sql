@@ -0,0 +1,8 @@
+DROP TRIGGER IF EXISTS segments_status_changed ON segments;
+DROP FUNCTION IF EXISTS bump_segment_members_version();
+
+DROP TRIGGER IF EXISTS users_segments_deleted ON users_segments;
+DROP TRIGGER IF EXISTS users_segments_inserted ON users_segments;
+DROP FUNCTION IF EXISTS bump_segments_version();
+
+ALTER TABLE users DROP COLUMN IF EXISTS segments_version;
@@ -0,0 +1,31 @@
+-- Version of the segment set a user sees, bumped on every change to it.
+ALTER TABLE users ADD COLUMN segments_version BIGINT NOT NULL DEFAULT 1;
+
+CREATE FUNCTION bump_segments_version() RETURNS TRIGGER AS $$
+BEGIN
+    UPDATE users SET segments_version = segments_version + 1
+    WHERE id IN (SELECT user_pk FROM changed_rows);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER users_segments_inserted AFTER INSERT ON users_segments
+    REFERENCING NEW TABLE AS changed_rows
+    FOR EACH STATEMENT EXECUTE FUNCTION bump_segments_version();
+
+CREATE TRIGGER users_segments_deleted AFTER DELETE ON users_segments
+    REFERENCING OLD TABLE AS changed_rows
+    FOR EACH STATEMENT EXECUTE FUNCTION bump_segments_version();
+
+-- Pausing or archiving a segment hides it from its members.
+CREATE FUNCTION bump_segment_members_version() RETURNS TRIGGER AS $$
+BEGIN
+    UPDATE users SET segments_version = segments_version + 1
+    WHERE id IN (SELECT user_pk FROM users_segments WHERE segment_pk = NEW.slug);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER segments_status_changed AFTER UPDATE OF status ON segments
+    FOR EACH ROW WHEN (OLD.status IS DISTINCT FROM NEW.status)
+    EXECUTE FUNCTION bump_segment_members_version();
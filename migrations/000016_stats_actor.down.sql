@@ -0,0 +1,4 @@
+ALTER TABLE users_segments_stats
+    DROP COLUMN IF EXISTS reason,
+    DROP COLUMN IF EXISTS source,
+    DROP COLUMN IF EXISTS actor;
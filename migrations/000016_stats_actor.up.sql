@@ -0,0 +1,6 @@
+-- Who made a membership change, what made it and why. Rows written before
+-- these columns existed keep them empty.
+ALTER TABLE users_segments_stats
+    ADD COLUMN actor  VARCHAR(100) NOT NULL DEFAULT '',
+    ADD COLUMN source VARCHAR(20)  NOT NULL DEFAULT '',
+    ADD COLUMN reason TEXT         NOT NULL DEFAULT '';
@@ -0,0 +1,12 @@
+-- Only the SHA-256 of a key is stored. prefix is the start of the key, kept to
+-- tell keys apart in listings.
+CREATE TABLE api_keys (
+    id           BIGSERIAL    PRIMARY KEY,
+    name         VARCHAR(100) NOT NULL UNIQUE,
+    prefix       VARCHAR(16)  NOT NULL,
+    hash         CHAR(64)     NOT NULL UNIQUE,
+    scopes       TEXT[]       NOT NULL DEFAULT '{}',
+    created_at   TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    last_used_at TIMESTAMPTZ,
+    revoked_at   TIMESTAMPTZ
+);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS audit_log;
+DROP TABLE IF EXISTS api_key_roles;
+
+DROP INDEX IF EXISTS segments_team_idx;
+ALTER TABLE segments DROP COLUMN IF EXISTS team;
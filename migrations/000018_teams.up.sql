@@ -0,0 +1,25 @@
+-- Segments belong to a team. Segments created before teams belong to none and
+-- can only be managed by keys with a role in every team ('*').
+ALTER TABLE segments ADD COLUMN team VARCHAR(100) NOT NULL DEFAULT '';
+
+CREATE INDEX segments_team_idx ON segments (team);
+
+CREATE TABLE api_key_roles (
+    api_key_id BIGINT       NOT NULL REFERENCES api_keys (id) ON DELETE CASCADE,
+    team       VARCHAR(100) NOT NULL,
+    role       VARCHAR(20)  NOT NULL CHECK (role IN ('viewer', 'editor', 'admin')),
+    PRIMARY KEY (api_key_id, team)
+);
+
+CREATE TABLE audit_log (
+    id         BIGSERIAL    PRIMARY KEY,
+    created_at TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    actor      VARCHAR(100) NOT NULL,
+    action     VARCHAR(50)  NOT NULL,
+    resource   VARCHAR(150) NOT NULL,
+    team       VARCHAR(100) NOT NULL,
+    outcome    VARCHAR(20)  NOT NULL,
+    reason     TEXT         NOT NULL DEFAULT ''
+);
+
+CREATE INDEX audit_log_created_at_idx ON audit_log (created_at);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS export_jobs_requested_by_created_at_idx;
+
+ALTER TABLE export_jobs DROP COLUMN IF EXISTS requested_by;
@@ -0,0 +1,4 @@
+-- The key that started an export, to cap the exports per key and day.
+ALTER TABLE export_jobs ADD COLUMN requested_by VARCHAR(100) NOT NULL DEFAULT '';
+
+CREATE INDEX export_jobs_requested_by_created_at_idx ON export_jobs (requested_by, created_at);
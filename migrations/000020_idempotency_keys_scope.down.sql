@@ -0,0 +1,11 @@
+DELETE FROM idempotency_keys a
+USING idempotency_keys b
+WHERE a.key = b.key AND a.api_key_id > b.api_key_id;
+
+ALTER TABLE idempotency_keys DROP CONSTRAINT idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (key);
+
+ALTER TABLE idempotency_keys
+    DROP COLUMN response_headers,
+    DROP COLUMN started_at,
+    DROP COLUMN api_key_id;
@@ -0,0 +1,12 @@
+-- Keys are scoped to the API key that sent them; rows stored before get
+-- api_key_id 0, match no client and expire as usual. A request still running
+-- after the lease since started_at loses its key to the next request.
+ALTER TABLE idempotency_keys
+    ADD COLUMN api_key_id       BIGINT      NOT NULL DEFAULT 0,
+    ADD COLUMN started_at       TIMESTAMPTZ NOT NULL DEFAULT now(),
+    ADD COLUMN response_headers JSONB       NOT NULL DEFAULT '{}';
+
+ALTER TABLE idempotency_keys ALTER COLUMN api_key_id DROP DEFAULT;
+
+ALTER TABLE idempotency_keys DROP CONSTRAINT idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (api_key_id, key);
@@ -0,0 +1,117 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	KIND_AMQP   = "amqp"
+	KIND_MEMORY = "memory"
+
+	// EVENTS_EXCHANGE is the topic exchange events are published to.
+	EVENTS_EXCHANGE = "events"
+)
+
+// Headers travel next to the message body, as AMQP headers for RabbitMQ.
+type Headers map[string]string
+
+const (
+	// HEADER_ATTEMPT counts the deliveries of a message, starting at 1.
+	HEADER_ATTEMPT = "x-attempt"
+	// HEADER_DEAD_REASON explains why a message was dead-lettered.
+	HEADER_DEAD_REASON = "x-dead-reason"
+)
+
+// Delivery is a message handed to a consumer. Every delivery must be settled
+// with exactly one of Ack, Nack, Retry or DeadLetter.
+type Delivery struct {
+	Body    []byte
+	Headers Headers
+
+	ack        func() error
+	nack       func() error
+	retry      func(ctx context.Context, delay time.Duration) error
+	deadLetter func(ctx context.Context, reason string) error
+}
+
+// Attempt is the number of times the message has been delivered, including this one.
+func (d Delivery) Attempt() int {
+	attempt, err := strconv.Atoi(d.Headers[HEADER_ATTEMPT])
+	if err != nil || attempt < 1 {
+		return 1
+	}
+	return attempt
+}
+
+// Ack confirms the message has been handled.
+func (d Delivery) Ack() error {
+	return d.ack()
+}
+
+// Nack hands the message back to the broker untouched, to be delivered again
+// as soon as a consumer is available.
+func (d Delivery) Nack() error {
+	return d.nack()
+}
+
+// Retry delivers the message again after delay with its attempt counter increased.
+func (d Delivery) Retry(ctx context.Context, delay time.Duration) error {
+	return d.retry(ctx, delay)
+}
+
+// DeadLetter moves the message to the dead-letter queue.
+func (d Delivery) DeadLetter(ctx context.Context, reason string) error {
+	return d.deadLetter(ctx, reason)
+}
+
+// nextAttempt copies headers with the attempt counter of the next delivery.
+func nextAttempt(d Delivery) Headers {
+	headers := make(Headers, len(d.Headers)+1)
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[HEADER_ATTEMPT] = strconv.Itoa(d.Attempt() + 1)
+	return headers
+}
+
+// deadHeaders copies headers with the reason of the dead-lettering.
+func deadHeaders(d Delivery, reason string) Headers {
+	headers := make(Headers, len(d.Headers)+1)
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[HEADER_DEAD_REASON] = reason
+	return headers
+}
+
+// Publisher sends tasks to the consumer queue and events to the events
+// exchange, where they are routed by routingKey.
+type Publisher interface {
+	Publish(ctx context.Context, msg []byte, headers Headers) error
+	PublishEvent(ctx context.Context, routingKey string, msg []byte, headers Headers) error
+}
+
+// Consumer streams deliveries until ctx is done or the broker is closed.
+type Consumer interface {
+	Consume(ctx context.Context) (<-chan Delivery, error)
+}
+
+type Broker interface {
+	Publisher
+	Consumer
+	Close()
+}
+
+// New returns the broker of the given kind. url is only used by amqp.
+func New(kind string, url string, opts ...Option) (Broker, error) {
+	switch kind {
+	case KIND_AMQP, "":
+		return NewRabbitMQ(url, opts...)
+	case KIND_MEMORY:
+		return NewMemory(defaultMemoryBuffer), nil
+	}
+	return nil, fmt.Errorf("unknown broker kind: %s", kind)
+}
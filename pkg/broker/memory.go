@@ -0,0 +1,168 @@
+package broker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const defaultMemoryBuffer = 1024
+
+// Memory is an in-process broker backed by a buffered channel. It lets the app
+// and the task handlers run in one process without RabbitMQ. Messages, pending
+// retries and dead letters are lost when the process exits.
+type Memory struct {
+	queue     chan Delivery
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu sync.Mutex
+	// requeued holds the messages given back by consumers. They are delivered
+	// before the queue, and are kept even when the queue is full.
+	requeued []Delivery
+	wake     chan struct{}
+	dead     []Delivery
+}
+
+func NewMemory(buffer int) *Memory {
+	return &Memory{
+		queue: make(chan Delivery, buffer),
+		done:  make(chan struct{}),
+		wake:  make(chan struct{}, 1),
+	}
+}
+
+func (m *Memory) Publish(ctx context.Context, msg []byte, headers Headers) error {
+	select {
+	case <-m.done:
+		return errors.New("failed to publish a message: broker is closed")
+	default:
+	}
+
+	body := make([]byte, len(msg))
+	copy(body, msg)
+	h := make(Headers, len(headers))
+	for k, v := range headers {
+		h[k] = v
+	}
+
+	select {
+	case m.queue <- Delivery{Body: body, Headers: h}:
+		return nil
+	case <-m.done:
+		return errors.New("failed to publish a message: broker is closed")
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "failed to publish a message")
+	}
+}
+
+// PublishEvent drops the event: nothing in the process subscribes to events,
+// just like an exchange without bound queues.
+func (m *Memory) PublishEvent(ctx context.Context, routingKey string, msg []byte, headers Headers) error {
+	select {
+	case <-m.done:
+		return errors.New("failed to publish an event: broker is closed")
+	default:
+	}
+	return nil
+}
+
+func (m *Memory) Consume(ctx context.Context) (<-chan Delivery, error) {
+	deliveries := make(chan Delivery)
+	go func() {
+		defer close(deliveries)
+		for {
+			d, ok := m.takeRequeued()
+			if !ok {
+				select {
+				case d = <-m.queue:
+				case <-m.wake:
+					continue
+				case <-ctx.Done():
+					return
+				case <-m.done:
+					return
+				}
+			}
+			m.settle(&d)
+			select {
+			case deliveries <- d:
+			case <-ctx.Done():
+				m.requeue(d)
+				return
+			case <-m.done:
+				return
+			}
+		}
+	}()
+	return deliveries, nil
+}
+
+func (m *Memory) settle(d *Delivery) {
+	msg := *d
+	d.ack = func() error { return nil }
+	d.nack = func() error {
+		m.requeue(msg)
+		return nil
+	}
+	d.retry = func(ctx context.Context, delay time.Duration) error {
+		headers := nextAttempt(msg)
+		time.AfterFunc(delay, func() {
+			m.requeue(Delivery{Body: msg.Body, Headers: headers})
+		})
+		return nil
+	}
+	d.deadLetter = func(ctx context.Context, reason string) error {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.dead = append(m.dead, Delivery{Body: msg.Body, Headers: deadHeaders(msg, reason)})
+		return nil
+	}
+}
+
+// DeadLetters returns the messages dead-lettered so far.
+func (m *Memory) DeadLetters() []Delivery {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Delivery(nil), m.dead...)
+}
+
+// requeue gives back a message taken from the queue. It never blocks, so
+// consumers can always settle their deliveries, and the message is never
+// dropped while the broker is open.
+func (m *Memory) requeue(d Delivery) {
+	m.mu.Lock()
+	m.requeued = append(m.requeued, d)
+	m.mu.Unlock()
+	m.signal()
+}
+
+// takeRequeued returns the oldest requeued message, if any.
+func (m *Memory) takeRequeued() (Delivery, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.requeued) == 0 {
+		return Delivery{}, false
+	}
+	d := m.requeued[0]
+	m.requeued = m.requeued[1:]
+	if len(m.requeued) != 0 {
+		// another waiting consumer can take the next one
+		m.signal()
+	}
+	return d, true
+}
+
+// signal wakes a consumer waiting for the queue to look at the requeued messages.
+func (m *Memory) signal() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (m *Memory) Close() {
+	m.closeOnce.Do(func() { close(m.done) })
+}
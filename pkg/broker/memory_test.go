@@ -0,0 +1,193 @@
+package broker
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+const testTimeout = 2 * time.Second
+
+func receive(t *testing.T, deliveries <-chan Delivery) Delivery {
+	t.Helper()
+	select {
+	case d, ok := <-deliveries:
+		if !ok {
+			t.Fatal("deliveries closed")
+		}
+		return d
+	case <-time.After(testTimeout):
+		t.Fatal("no delivery")
+	}
+	return Delivery{}
+}
+
+func TestMemoryPublishConsume(t *testing.T) {
+	m := NewMemory(1)
+	defer m.Close()
+	ctx := context.Background()
+
+	if err := m.Publish(ctx, []byte("task"), Headers{"k": "v"}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	deliveries, err := m.Consume(ctx)
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	d := receive(t, deliveries)
+	if string(d.Body) != "task" || d.Headers["k"] != "v" || d.Attempt() != 1 {
+		t.Fatalf("got %q %v attempt %d", d.Body, d.Headers, d.Attempt())
+	}
+	if err = d.Ack(); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+}
+
+// A consumer that stops while holding a message gives it back even when the
+// queue has filled up in the meantime.
+func TestMemoryRequeueWhenFull(t *testing.T) {
+	m := NewMemory(1)
+	defer m.Close()
+
+	if err := m.Publish(context.Background(), []byte("first"), nil); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped, err := m.Consume(ctx)
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	// blocks until the consumer has taken the first message off the queue
+	publishCtx, publishCancel := context.WithTimeout(context.Background(), testTimeout)
+	defer publishCancel()
+	if err = m.Publish(publishCtx, []byte("second"), nil); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	cancel()
+	select {
+	case _, ok := <-stopped:
+		if ok {
+			t.Fatal("got a delivery after cancel")
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("consumer did not stop")
+	}
+
+	deliveries, err := m.Consume(context.Background())
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	for _, want := range []string{"first", "second"} {
+		if d := receive(t, deliveries); string(d.Body) != want {
+			t.Fatalf("got %q, want %q", d.Body, want)
+		}
+	}
+}
+
+func TestMemoryNackWhenFull(t *testing.T) {
+	m := NewMemory(1)
+	defer m.Close()
+	ctx := context.Background()
+
+	if err := m.Publish(ctx, []byte("first"), nil); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	deliveries, err := m.Consume(ctx)
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	d := receive(t, deliveries)
+	// the consumer holds the second message and the third fills the queue
+	publishCtx, publishCancel := context.WithTimeout(ctx, testTimeout)
+	defer publishCancel()
+	for _, body := range []string{"second", "third"} {
+		if err = m.Publish(publishCtx, []byte(body), nil); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- d.Nack() }()
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatalf("Nack: %v", err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("Nack blocked on the full queue")
+	}
+
+	got := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		got[string(receive(t, deliveries).Body)] = true
+	}
+	if !got["first"] || !got["second"] || !got["third"] {
+		t.Fatalf("got %v, want every message", got)
+	}
+}
+
+func TestMemoryRetry(t *testing.T) {
+	m := NewMemory(1)
+	defer m.Close()
+	ctx := context.Background()
+
+	if err := m.Publish(ctx, []byte("task"), Headers{"k": "v"}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	deliveries, err := m.Consume(ctx)
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	d := receive(t, deliveries)
+	if err = d.Retry(ctx, 10*time.Millisecond); err != nil {
+		t.Fatalf("Retry: %v", err)
+	}
+
+	d = receive(t, deliveries)
+	if string(d.Body) != "task" || d.Headers["k"] != "v" || d.Attempt() != 2 {
+		t.Fatalf("got %q %v attempt %d", d.Body, d.Headers, d.Attempt())
+	}
+}
+
+func TestMemoryDeadLetter(t *testing.T) {
+	m := NewMemory(1)
+	defer m.Close()
+	ctx := context.Background()
+
+	if err := m.Publish(ctx, []byte("task"), nil); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	deliveries, err := m.Consume(ctx)
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	if err = receive(t, deliveries).DeadLetter(ctx, "bad payload"); err != nil {
+		t.Fatalf("DeadLetter: %v", err)
+	}
+
+	dead := m.DeadLetters()
+	if len(dead) != 1 || string(dead[0].Body) != "task" || dead[0].Headers[HEADER_DEAD_REASON] != "bad payload" {
+		t.Fatalf("got dead letters %v", dead)
+	}
+}
+
+func TestMemoryClosed(t *testing.T) {
+	m := NewMemory(1)
+	deliveries, err := m.Consume(context.Background())
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	m.Close()
+
+	if err = m.Publish(context.Background(), []byte("task"), nil); err == nil {
+		t.Fatal("Publish after Close succeeded")
+	}
+	select {
+	case _, ok := <-deliveries:
+		if ok {
+			t.Fatal("got a delivery after Close")
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("deliveries not closed")
+	}
+}
@@ -0,0 +1,49 @@
+package broker
+
+import "time"
+
+const (
+	defaultConfirmTimeout = 5 * time.Second
+	defaultReconnectDelay = 30 * time.Second
+)
+
+type options struct {
+	prefetch       int
+	confirmTimeout time.Duration
+	reconnectDelay time.Duration
+}
+
+type Option func(*options)
+
+// Prefetch limits how many unacknowledged messages the broker hands to a
+// consumer at once. Zero means no limit.
+func Prefetch(count int) Option {
+	return func(o *options) {
+		o.prefetch = count
+	}
+}
+
+// ConfirmTimeout bounds how long a publish waits for the broker to confirm it.
+func ConfirmTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.confirmTimeout = timeout
+	}
+}
+
+// ReconnectDelay caps the pause between reconnect attempts.
+func ReconnectDelay(delay time.Duration) Option {
+	return func(o *options) {
+		o.reconnectDelay = delay
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		confirmTimeout: defaultConfirmTimeout,
+		reconnectDelay: defaultReconnectDelay,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
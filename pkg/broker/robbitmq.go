@@ -1,83 +1,459 @@
 package broker
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"fmt"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+const (
+	mainQueue = "main"
+	deadQueue = "main.dead"
+
+	minReconnectDelay = 100 * time.Millisecond
+)
+
+// retryTiers are the delays of the retry queues. Every message of a retry queue
+// waits the same queue TTL and is then dead-lettered back into the main queue,
+// so a long delay never holds up a shorter one behind it. A retry waits for the
+// shortest tier that is at least its delay, or the longest tier.
+var retryTiers = []time.Duration{
+	time.Second,
+	5 * time.Second,
+	30 * time.Second,
+	time.Minute,
+	5 * time.Minute,
+	15 * time.Minute,
+	time.Hour,
+}
+
+func retryQueue(tier time.Duration) string {
+	return fmt.Sprintf("%s.retry.%ds", mainQueue, int(tier.Seconds()))
+}
+
+// retryTier picks the retry tier for delay.
+func retryTier(delay time.Duration) time.Duration {
+	for _, tier := range retryTiers {
+		if tier >= delay {
+			return tier
+		}
+	}
+	return retryTiers[len(retryTiers)-1]
+}
+
+// RabbitMQ keeps one connection with a publishing channel in confirm mode and
+// a consuming channel. When the broker closes one of the channels it is opened
+// again, and when the connection drops it redials in the background and
+// declares the queues again; publishers and consumers wait for it.
 type RabbitMQ struct {
-	Connection *amqp.Connection
-	Channel *amqp.Channel
-	Queue *amqp.Queue
+	url  string
+	opts options
+
+	mu        sync.RWMutex
+	conn      *amqp.Connection
+	pubCh     *amqp.Channel
+	subCh     *amqp.Channel
+	ready     chan struct{} // closed while connected
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
+func NewRabbitMQ(URL string, opts ...Option) (*RabbitMQ, error) {
+	r := &RabbitMQ{
+		url:   URL,
+		opts:  newOptions(opts),
+		ready: make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	if err := r.connect(); err != nil {
+		return nil, err
+	}
+	go r.watch()
+	return r, nil
+}
 
+// connect dials RabbitMQ, opens both channels and declares the topology.
+func (r *RabbitMQ) connect() error {
+	conn, err := amqp.Dial(r.url)
+	if err != nil {
+		return errors.Wrap(err, "failed to connect to RabbitMQ")
+	}
 
-func NewRabbitMQ(URL string) (*RabbitMQ, error) {
-	conn, err := amqp.Dial(URL)
+	pubCh, err := publishChannel(conn)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	subCh, err := r.consumeChannel(conn)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	r.mu.Lock()
+	r.conn, r.pubCh, r.subCh = conn, pubCh, subCh
+	close(r.ready)
+	r.mu.Unlock()
+	return nil
+}
 
+// publishChannel opens a channel in confirm mode and declares the topology on it.
+func publishChannel(conn *amqp.Connection) (*amqp.Channel, error) {
+	ch, err := conn.Channel()
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to connect to RabbitMQ")
+		return nil, errors.Wrap(err, "failed to get channel")
 	}
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return nil, errors.Wrap(err, "failed to put channel into confirm mode.")
+	}
+	if err := declare(ch); err != nil {
+		ch.Close()
+		return nil, err
+	}
+	return ch, nil
+}
 
+// consumeChannel opens a channel with the prefetch limit of the options.
+func (r *RabbitMQ) consumeChannel(conn *amqp.Connection) (*amqp.Channel, error) {
 	ch, err := conn.Channel()
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get channel")
 	}
+	if r.opts.prefetch > 0 {
+		if err := ch.Qos(r.opts.prefetch, 0, false); err != nil {
+			ch.Close()
+			return nil, errors.Wrap(err, "failed to set QoS.")
+		}
+	}
+	return ch, nil
+}
+
+// declare creates the queues and the events exchange. All of them are durable
+// so scheduled tasks survive a broker restart.
+func declare(ch *amqp.Channel) error {
+	err := ch.ExchangeDeclare(
+		EVENTS_EXCHANGE, // name
+		"topic",         // type
+		true,            // durable
+		false,           // auto-deleted
+		false,           // internal
+		false,           // no-wait
+		nil,             // arguments
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to declare the events exchange.")
+	}
 
-	q, err := ch.QueueDeclare(
-		"main", // name
-		false,   // durable
-		false,   // delete when unused
-		false,   // exclusive
-		false,   // no-wait
-		nil,     // arguments
+	_, err = ch.QueueDeclare(
+		mainQueue, // name
+		true,      // durable
+		false,     // delete when unused
+		false,     // exclusive
+		false,     // no-wait
+		nil,       // arguments
 	)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to declare a queue.")
+		return errors.Wrap(err, "failed to declare a queue.")
+	}
+
+	for _, tier := range retryTiers {
+		_, err = ch.QueueDeclare(
+			retryQueue(tier), // name
+			true,             // durable
+			false,            // delete when unused
+			false,            // exclusive
+			false,            // no-wait
+			amqp.Table{
+				"x-message-ttl":             tier.Milliseconds(),
+				"x-dead-letter-exchange":    "",
+				"x-dead-letter-routing-key": mainQueue,
+			},
+		)
+		if err != nil {
+			return errors.Wrap(err, "failed to declare a retry queue.")
+		}
 	}
 
-	return &RabbitMQ{Connection: conn, Channel: ch, Queue: &q}, nil
+	_, err = ch.QueueDeclare(
+		deadQueue, // name
+		true,      // durable
+		false,     // delete when unused
+		false,     // exclusive
+		false,     // no-wait
+		nil,       // arguments
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to declare the dead-letter queue.")
+	}
+	return nil
 }
 
-func (r *RabbitMQ) Close()  {
-	r.Channel.Close()
-	r.Connection.Close()
+// watch opens a channel again every time the broker closes it, for example
+// after a channel error, and reconnects every time the connection is lost,
+// until the broker is closed. Consume registers its consumer again on the new
+// consuming channel.
+func (r *RabbitMQ) watch() {
+	for {
+		r.mu.RLock()
+		conn := r.conn
+		connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+		pubClosed := r.pubCh.NotifyClose(make(chan *amqp.Error, 1))
+		subClosed := r.subCh.NotifyClose(make(chan *amqp.Error, 1))
+		r.mu.RUnlock()
+
+		var reopen func() error
+		select {
+		case <-connClosed:
+		case <-pubClosed:
+			reopen = func() error {
+				ch, err := publishChannel(conn)
+				if err == nil {
+					r.mu.Lock()
+					r.pubCh = ch
+					r.mu.Unlock()
+				}
+				return err
+			}
+		case <-subClosed:
+			reopen = func() error {
+				ch, err := r.consumeChannel(conn)
+				if err == nil {
+					r.mu.Lock()
+					r.subCh = ch
+					r.mu.Unlock()
+				}
+				return err
+			}
+		case <-r.done:
+			return
+		}
+		select {
+		case <-r.done:
+			return
+		default:
+		}
+
+		r.mu.Lock()
+		r.ready = make(chan struct{})
+		r.mu.Unlock()
+
+		// channels are closed with their connection too, so a channel is only
+		// opened again while the connection is still up
+		if reopen != nil && !conn.IsClosed() && reopen() == nil {
+			r.mu.Lock()
+			close(r.ready)
+			r.mu.Unlock()
+			continue
+		}
+		conn.Close()
+
+		delay := minReconnectDelay
+		for r.connect() != nil {
+			select {
+			case <-time.After(delay):
+			case <-r.done:
+				return
+			}
+			delay *= 2
+			if delay > r.opts.reconnectDelay {
+				delay = r.opts.reconnectDelay
+			}
+		}
+	}
 }
 
-type Message map[string]interface{}
+// channels waits until the broker is connected and returns its channels.
+func (r *RabbitMQ) channels(ctx context.Context) (*amqp.Channel, *amqp.Channel, error) {
+	r.mu.RLock()
+	ready := r.ready
+	r.mu.RUnlock()
+
+	select {
+	case <-ready:
+	case <-r.done:
+		return nil, nil, errors.New("broker is closed")
+	case <-ctx.Done():
+		return nil, nil, errors.Wrap(ctx.Err(), "broker is not connected")
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.pubCh, r.subCh, nil
+}
 
-func MsgSerialize(msg Message) ([]byte, error) {
-    var b bytes.Buffer
-    encoder := json.NewEncoder(&b)
-    err := encoder.Encode(msg)
-    return b.Bytes(), err
+func (r *RabbitMQ) Close() {
+	r.closeOnce.Do(func() {
+		close(r.done)
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		r.conn.Close()
+	})
 }
 
-func MsgDeserialize(b []byte) (Message, error) {
-    var msg Message
-    buf := bytes.NewBuffer(b)
-    decoder := json.NewDecoder(buf)
-    err := decoder.Decode(&msg)
-    return msg, err
+func (r *RabbitMQ) Publish(ctx context.Context, msg []byte, headers Headers) error {
+	return r.publish(ctx, "", mainQueue, msg, headers)
 }
 
-func (r *RabbitMQ) Publish(msg []byte) error {
-	err := r.Channel.Publish(
-		"",     // exchange
-		r.Queue.Name, // routing key
-		false,  // mandatory
-		false,  // immediate
+func (r *RabbitMQ) PublishEvent(ctx context.Context, routingKey string, msg []byte, headers Headers) error {
+	return r.publish(ctx, EVENTS_EXCHANGE, routingKey, msg, headers)
+}
+
+// publish sends a persistent msg to exchange, the default exchange routes it
+// to the queue named by key, and waits for the broker to confirm it.
+func (r *RabbitMQ) publish(ctx context.Context, exchange string, key string, msg []byte, headers Headers) error {
+	ctx, cancel := context.WithTimeout(ctx, r.opts.confirmTimeout)
+	defer cancel()
+
+	ch, _, err := r.channels(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to publish a message.")
+	}
+
+	table := make(amqp.Table, len(headers))
+	for k, v := range headers {
+		table[k] = v
+	}
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(ctx,
+		exchange, // exchange
+		key,      // routing key
+		false,    // mandatory
+		false,    // immediate
 		amqp.Publishing{
-			ContentType: "text/plain",
-			Body:        []byte(msg),
+			ContentType:  "application/json",
+			DeliveryMode: amqp.Persistent,
+			Headers:      table,
+			Body:         []byte(msg),
 		})
 	if err != nil {
 		return errors.Wrap(err, "failed to publish a message.")
 	}
 
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return errors.Wrap(err, "publish was not confirmed.")
+	}
+	if !acked {
+		return errors.New("publish was rejected by the broker.")
+	}
 	return nil
 }
+
+// Consume forwards deliveries from the main queue and registers the consumer
+// again once its channel has been opened again or the broker has reconnected.
+// Messages delivered on a closed channel can't be settled any more; RabbitMQ
+// delivers them again.
+func (r *RabbitMQ) Consume(ctx context.Context) (<-chan Delivery, error) {
+	messages, err := r.consume(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make(chan Delivery)
+	go func() {
+		defer close(deliveries)
+		for {
+			r.forward(ctx, messages, deliveries)
+			for {
+				if ctx.Err() != nil {
+					return
+				}
+				if messages, err = r.consume(ctx); err == nil {
+					break
+				}
+				select {
+				case <-time.After(minReconnectDelay):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return deliveries, nil
+}
+
+func (r *RabbitMQ) consume(ctx context.Context) (<-chan amqp.Delivery, error) {
+	_, ch, err := r.channels(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to register a consumer.")
+	}
+	messages, err := ch.Consume(
+		mainQueue, // queue
+		"",        // consumer
+		false,     // auto-ack
+		false,     // exclusive
+		false,     // no-local
+		false,     // no-wait
+		nil,       // args
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to register a consumer.")
+	}
+	return messages, nil
+}
+
+// forward passes messages on until ctx is done or the channel is closed.
+func (r *RabbitMQ) forward(ctx context.Context, messages <-chan amqp.Delivery, deliveries chan<- Delivery) {
+	for {
+		select {
+		case message, ok := <-messages:
+			if !ok {
+				return
+			}
+			select {
+			case deliveries <- r.delivery(message):
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *RabbitMQ) delivery(message amqp.Delivery) Delivery {
+	d := Delivery{Body: message.Body, Headers: fromTable(message.Headers)}
+	d.ack = func() error {
+		return message.Ack(false)
+	}
+	d.nack = func() error {
+		return message.Nack(false, true)
+	}
+	d.retry = func(ctx context.Context, delay time.Duration) error {
+		if err := r.publish(ctx, "", retryQueue(retryTier(delay)), d.Body, nextAttempt(d)); err != nil {
+			return requeue(message, err)
+		}
+		return message.Ack(false)
+	}
+	d.deadLetter = func(ctx context.Context, reason string) error {
+		if err := r.publish(ctx, "", deadQueue, d.Body, deadHeaders(d, reason)); err != nil {
+			return requeue(message, err)
+		}
+		return message.Ack(false)
+	}
+	return d
+}
+
+// requeue hands the message back to the main queue when it could not be moved
+// to another queue, so it is neither lost nor left unacknowledged.
+func requeue(message amqp.Delivery, err error) error {
+	if nackErr := message.Nack(false, true); nackErr != nil {
+		return errors.Wrapf(err, "failed to requeue the message: %v", nackErr)
+	}
+	return err
+}
+
+// fromTable keeps the string valued AMQP headers.
+func fromTable(table amqp.Table) Headers {
+	headers := make(Headers, len(table))
+	for k, v := range table {
+		if s, ok := v.(string); ok {
+			headers[k] = s
+		}
+	}
+	return headers
+}
@@ -0,0 +1,81 @@
+// Package netguard keeps requests to URLs supplied by clients away from the
+// internal network.
+package netguard
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+)
+
+var ErrNotPublic = errors.New("address is not public")
+
+// reserved are the special purpose ranges the net.IP methods do not cover.
+var reserved = mustParseCIDRs(
+	"0.0.0.0/8",     // "this network"
+	"100.64.0.0/10", // carrier-grade NAT
+	"192.0.0.0/24",  // IETF protocol assignments
+	"198.18.0.0/15", // benchmarking
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// IsPublic reports whether ip may be reached on behalf of a client: it is not
+// a loopback, private, link-local, multicast, unspecified or other special
+// purpose address.
+func IsPublic(ip net.IP) bool {
+	if ip.IsLoopback() ||
+		ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() ||
+		ip.IsUnspecified() {
+		return false
+	}
+	for _, n := range reserved {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsPublicHost reports whether the host of a URL may be public. Names are not
+// resolved here, only localhost is known to be local; use Control when dialing.
+func IsPublicHost(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" || host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return IsPublic(ip)
+	}
+	return true
+}
+
+// Control is a net.Dialer Control function that refuses connections to
+// addresses that are not public. It runs after names are resolved, so it also
+// catches names and redirects that lead into the internal network.
+func Control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !IsPublic(ip) {
+		return fmt.Errorf("%w: %s", ErrNotPublic, host)
+	}
+	return nil
+}
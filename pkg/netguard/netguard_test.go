@@ -0,0 +1,51 @@
+package netguard
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestIsPublicHost(t *testing.T) {
+	for host, want := range map[string]bool{
+		"example.com":       true,
+		"93.184.216.34":     true,
+		"2606:4700::1111":   true,
+		"":                  false,
+		"localhost":         false,
+		"api.localhost":     false,
+		"LOCALHOST.":        false,
+		"127.0.0.1":         false,
+		"::1":               false,
+		"10.1.2.3":          false,
+		"172.16.0.1":        false,
+		"192.168.1.1":       false,
+		"169.254.169.254":   false,
+		"0.0.0.0":           false,
+		"0.1.2.3":           false,
+		"100.64.0.1":        false,
+		"100.127.255.254":   false,
+		"100.128.0.1":       true,
+		"192.0.0.8":         false,
+		"198.18.0.1":        false,
+		"198.19.255.254":    false,
+		"198.20.0.1":        true,
+		"::ffff:100.64.0.1": false,
+		"fd00::1":           false,
+		"fe80::1":           false,
+	} {
+		if got := IsPublicHost(host); got != want {
+			t.Errorf("IsPublicHost(%q) = %t, want %t", host, got, want)
+		}
+	}
+}
+
+func TestControl(t *testing.T) {
+	if err := Control("tcp", "93.184.216.34:443", nil); err != nil {
+		t.Fatalf("public address refused: %v", err)
+	}
+	for _, address := range []string{"127.0.0.1:80", "[::1]:80", "10.0.0.1:443", "169.254.169.254:80", "100.64.0.1:80", "198.18.0.1:443"} {
+		if err := Control("tcp", address, nil); !errors.Is(err, ErrNotPublic) {
+			t.Errorf("Control(%q) = %v, want ErrNotPublic", address, err)
+		}
+	}
+}
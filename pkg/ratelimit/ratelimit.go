@@ -0,0 +1,110 @@
+// Package ratelimit implements in-memory token buckets, one per key. The
+// buckets are local to the process, so every replica limits on its own.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// pruneInterval is how often buckets that have refilled are dropped.
+const pruneInterval = 10 * time.Minute
+
+// Limit configures the buckets of a Limiter.
+type Limit struct {
+	// Rate is the number of tokens added per second. Zero disables the limit.
+	Rate float64
+	// Burst is the size of a bucket, the most requests allowed at once.
+	Burst int
+}
+
+// Result is the state of a bucket after taking a token from it.
+type Result struct {
+	Allowed bool
+	// Limit is the size of the bucket, zero when requests are not limited.
+	Limit     int
+	Remaining int
+	// Reset is how long it takes the bucket to refill.
+	Reset time.Duration
+	// RetryAfter is how long until the next token, zero when the request was allowed.
+	RetryAfter time.Duration
+}
+
+type bucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// Limiter hands out tokens from a bucket per key. Each bucket starts full and
+// refills at limit.Rate tokens per second.
+type Limiter struct {
+	limit Limit
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	pruned  time.Time
+}
+
+func New(limit Limit) *Limiter {
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+	return &Limiter{
+		limit:   limit,
+		buckets: make(map[string]*bucket),
+		pruned:  time.Now(),
+	}
+}
+
+// Allow takes a token from the bucket of key.
+func (l *Limiter) Allow(key string) Result {
+	return l.allowAt(key, time.Now())
+}
+
+func (l *Limiter) allowAt(key string, now time.Time) Result {
+	if l.limit.Rate <= 0 {
+		return Result{Allowed: true}
+	}
+	burst := float64(l.limit.Burst)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.pruned) > pruneInterval {
+		l.prune(now)
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, at: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.at).Seconds()*l.limit.Rate)
+	b.at = now
+
+	result := Result{Limit: l.limit.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = l.duration(1 - b.tokens)
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = l.duration(burst - b.tokens)
+	return result
+}
+
+// duration is how long it takes to add tokens to a bucket.
+func (l *Limiter) duration(tokens float64) time.Duration {
+	return time.Duration(tokens / l.limit.Rate * float64(time.Second))
+}
+
+// prune drops the buckets that are full again, as they equal new ones.
+func (l *Limiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.at).Seconds()*l.limit.Rate >= float64(l.limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+	l.pruned = now
+}